@@ -2,45 +2,262 @@ package main
 
 import (
 	"bufio"
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"net"
+	"os"
 	"os/exec"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
 )
 
-// AcpiEvent returns a channel from which you can receive ACPI events
-// by reading from acpi_listen output.
-// Arguments are like printed by acpi_listen, for example:
+const (
+	acpidSocketPath = "/var/run/acpid.socket"
+	acpiListenCmd   = "acpi_listen"
+	// acpiMinBackoff and acpiMaxBackoff limit the delay before the event
+	// source is opened again after it ended or could not be opened
+	acpiMinBackoff = time.Second
+	acpiMaxBackoff = time.Minute
+)
+
+// AcpiEventKind tells what an ACPI event was about
+type AcpiEventKind string
+
+const (
+	AcpiOther          AcpiEventKind = "other"
+	AcpiLidOpen        AcpiEventKind = "lid open"
+	AcpiLidClose       AcpiEventKind = "lid close"
+	AcpiACPlugged      AcpiEventKind = "ac plugged"
+	AcpiACUnplugged    AcpiEventKind = "ac unplugged"
+	AcpiBrightnessUp   AcpiEventKind = "brightness up"
+	AcpiBrightnessDown AcpiEventKind = "brightness down"
+)
+
+// AcpiEvent is a single event as reported by acpid
+type AcpiEvent struct {
+	Kind AcpiEventKind
+	// Line is the event as printed by acpi_listen
+	Line string
+}
+
+// ParseAcpiEvent parses an event line like printed by acpi_listen, for
+// example:
 //
 //	"button/lid LID close"
 //	"button/lid LID open"
+//	"ac_adapter ACPI0003:00 00000080 00000001"
 //	"video/brightnessdown BRTDN 00000087 00000000"
 //	"wmi PNP0C14:05 000000d0 00000000"
 //
-// Passing one or more of these strings will make the returned channel
-// only react to the corresponding events. Passing "" will make it react
-// to all events.
-// The item returned from the channel will be the ACPI event string in
-// the same format as the filter argument.
-// AcpiEvent will create a goroutine that spawns an acpi_listen instance
-// and wait for output from it to fill the event channel.
-func AcpiEvent(cmdName string, watchedEvent string) (eventsOut chan string, err error) {
-	eventsOut = make(chan string, 100)
-	cmd := exec.Command(cmdName)
-	out, err := cmd.StdoutPipe()
-	if err != nil {
-		return nil, err
+// Events nerdshade does not care about have Kind AcpiOther.
+func ParseAcpiEvent(line string) AcpiEvent {
+	e := AcpiEvent{Kind: AcpiOther, Line: line}
+	fields := strings.Fields(line)
+	if len(fields) == 0 {
+		return e
+	}
+	switch fields[0] {
+	case "button/lid":
+		if len(fields) >= 3 && fields[2] == "open" {
+			e.Kind = AcpiLidOpen
+		} else if len(fields) >= 3 && fields[2] == "close" {
+			e.Kind = AcpiLidClose
+		}
+	case "ac_adapter":
+		if len(fields) < 4 {
+			break
+		}
+		// the last field is the new state, 1 for online
+		state, err := strconv.ParseUint(fields[3], 16, 32)
+		if err == nil && state == 1 {
+			e.Kind = AcpiACPlugged
+		} else if err == nil && state == 0 {
+			e.Kind = AcpiACUnplugged
+		}
+	case "video/brightnessup":
+		e.Kind = AcpiBrightnessUp
+	case "video/brightnessdown":
+		e.Kind = AcpiBrightnessDown
 	}
-	scanner := bufio.NewScanner(out)
-	err = cmd.Start()
+	return e
+}
+
+// AcpiSource opens a stream of event lines
+type AcpiSource func(ctx context.Context) (io.ReadCloser, error)
+
+// AcpidSocket reads events directly from the acpid socket at path
+func AcpidSocket(path string) AcpiSource {
+	return func(ctx context.Context) (io.ReadCloser, error) {
+		var d net.Dialer
+		return d.DialContext(ctx, "unix", path)
+	}
+}
+
+// acpiCmdOutput is the output of a running command. The command is waited
+// for when its output ended, so it does not linger as zombie. Closing it
+// stops the command, which ends the output.
+type acpiCmdOutput struct {
+	io.ReadCloser
+	cmd  *exec.Cmd
+	wait sync.Once
+	// mu is held while waiting for the command, waited is set afterwards
+	mu     sync.Mutex
+	waited bool
+}
+
+func (o *acpiCmdOutput) Read(p []byte) (int, error) {
+	n, err := o.ReadCloser.Read(p)
 	if err != nil {
-		return nil, err
+		// Wait closes the pipe, so it must not be called before reading
+		// is done
+		o.wait.Do(func() {
+			o.mu.Lock()
+			defer o.mu.Unlock()
+			status := o.cmd.Wait()
+			o.waited = true
+			slog.Debug("ACPI listener stopped", "cmd", o.cmd.Path, "status", status)
+		})
+	}
+	return n, err
+}
+
+func (o *acpiCmdOutput) Close() error {
+	err := o.stop()
+	if errors.Is(err, os.ErrProcessDone) {
+		return nil
 	}
+	return err
+}
+
+// stop sends SIGTERM to the process group of the command, as acpi_listen
+// may be a wrapper script. Once the command was reaped, its process group
+// ID may belong to someone else, so while Wait is running only the command
+// itself is signaled, and not at all after Wait returned.
+func (o *acpiCmdOutput) stop() error {
+	if !o.mu.TryLock() {
+		return o.cmd.Process.Signal(syscall.SIGTERM)
+	}
+	defer o.mu.Unlock()
+	if o.waited {
+		return os.ErrProcessDone
+	}
+	return syscall.Kill(-o.cmd.Process.Pid, syscall.SIGTERM)
+}
+
+// AcpiListenCmd reads events from the output of an acpi_listen compatible
+// command. The command is stopped when ctx is done or the output is closed.
+func AcpiListenCmd(name string) AcpiSource {
+	return func(ctx context.Context) (io.ReadCloser, error) {
+		cmd := exec.CommandContext(ctx, name)
+		cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+		out, err := cmd.StdoutPipe()
+		if err != nil {
+			return nil, err
+		}
+		o := &acpiCmdOutput{ReadCloser: out, cmd: cmd}
+		cmd.Cancel = o.stop
+		err = cmd.Start()
+		if err != nil {
+			return nil, err
+		}
+		return o, nil
+	}
+}
+
+// DefaultAcpiSource reads from the acpid socket, or from acpi_listen if the
+// socket can not be opened (usually because it is only readable by root).
+func DefaultAcpiSource(ctx context.Context) (io.ReadCloser, error) {
+	r, sockErr := AcpidSocket(acpidSocketPath)(ctx)
+	if sockErr == nil {
+		return r, nil
+	}
+	r, cmdErr := AcpiListenCmd(acpiListenCmd)(ctx)
+	if cmdErr == nil {
+		return r, nil
+	}
+	return nil, errors.Join(sockErr, cmdErr)
+}
+
+// AcpiListener delivers the events from its source and opens it again
+// whenever it ends
+type AcpiListener struct {
+	source     AcpiSource
+	minBackoff time.Duration
+	maxBackoff time.Duration
+}
+
+// NewAcpiListener returns a listener for source
+func NewAcpiListener(source AcpiSource) *AcpiListener {
+	return &AcpiListener{source: source, minBackoff: acpiMinBackoff, maxBackoff: acpiMaxBackoff}
+}
+
+// Listen returns a channel receiving all events until ctx is done, then the
+// channel is closed.
+// If the source ends or can not be opened, it is opened again after a delay
+// that doubles with every failure, up to maxBackoff. The delay starts over
+// once an event was received.
+func (l *AcpiListener) Listen(ctx context.Context) <-chan AcpiEvent {
+	events := make(chan AcpiEvent, 100)
 	go func() {
-		for scanner.Scan() {
-			text := scanner.Text()
-			if text == watchedEvent || watchedEvent == "" {
-				line := scanner.Text()
-				eventsOut <- line
+		defer close(events)
+		backoff := l.minBackoff
+		warned := false
+		for {
+			r, err := l.source(ctx)
+			if err != nil {
+				if !warned {
+					slog.Warn("ACPI events not available, retrying in background", "error", err)
+					warned = true
+				} else {
+					slog.Debug("ACPI event source could not be opened", "error", err, "retry", backoff)
+				}
+			} else {
+				if l.read(ctx, r, events) {
+					backoff = l.minBackoff
+					warned = false
+				}
+				if ctx.Err() == nil {
+					slog.Warn("ACPI event source ended, restarting", "retry", backoff)
+				}
+			}
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(backoff):
 			}
+			backoff = min(2*backoff, l.maxBackoff)
 		}
 	}()
-	return eventsOut, err
+	return events
+}
+
+// read sends events from r until it ends or ctx is done. It returns whether
+// any event was read.
+func (l *AcpiListener) read(ctx context.Context, r io.ReadCloser, events chan<- AcpiEvent) (received bool) {
+	// unblock the scanner when ctx is done
+	stop := context.AfterFunc(ctx, func() { r.Close() })
+	defer func() {
+		if stop() {
+			r.Close()
+		}
+		// read up to the end, after which commands are waited for
+		io.Copy(io.Discard, r)
+	}()
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		received = true
+		e := ParseAcpiEvent(scanner.Text())
+		slog.Debug("acpi event", "kind", e.Kind, "line", e.Line)
+		select {
+		case events <- e:
+		case <-ctx.Done():
+			return
+		}
+	}
+	return
 }
@@ -1,16 +1,264 @@
 package main
 
 import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 )
 
 const mockAcpiListen = "./mock_acpi_listen.sh"
 
-func TestAcpiEvent(t *testing.T) {
-	acpiChan, _ := AcpiEvent(mockAcpiListen, acpiLidOpenEvent)
-	event := <-acpiChan
+func TestParseAcpiEvent(t *testing.T) {
+	testCases := map[string]AcpiEventKind{
+		"button/lid LID open":                          AcpiLidOpen,
+		"button/lid LID close":                         AcpiLidClose,
+		"ac_adapter ACPI0003:00 00000080 00000001":     AcpiACPlugged,
+		"ac_adapter ACPI0003:00 00000080 00000000":     AcpiACUnplugged,
+		"ac_adapter ACPI0003:00 00000080":              AcpiOther,
+		"video/brightnessup BRTUP 00000086 00000000":   AcpiBrightnessUp,
+		"video/brightnessdown BRTDN 00000087 00000000": AcpiBrightnessDown,
+		"wmi PNP0C14:05 000000d0 00000000":             AcpiOther,
+		"button/lid LID":                               AcpiOther,
+		"":                                             AcpiOther,
+	}
+	for line, want := range testCases {
+		t.Run(line, func(t *testing.T) {
+			e := ParseAcpiEvent(line)
+			if e.Kind != want || e.Line != line {
+				t.Errorf("Got %+v instead of %q", e, want)
+			}
+		})
+	}
+}
+
+// receiveUntil collects events until one of the given kind arrives
+func receiveUntil(t *testing.T, events <-chan AcpiEvent, kind AcpiEventKind) (kinds []AcpiEventKind) {
+	t.Helper()
+	timeout := time.After(5 * time.Second)
+	for {
+		select {
+		case e, ok := <-events:
+			if !ok {
+				t.Fatalf("Channel closed after %v", kinds)
+			}
+			kinds = append(kinds, e.Kind)
+			if e.Kind == kind {
+				return
+			}
+		case <-timeout:
+			t.Fatalf("No %q event received, got %v", kind, kinds)
+		}
+	}
+}
+
+// waitClosed fails if events is not closed soon
+func waitClosed(t *testing.T, events <-chan AcpiEvent) {
+	t.Helper()
+	timeout := time.After(5 * time.Second)
+	for {
+		select {
+		case _, ok := <-events:
+			if !ok {
+				return
+			}
+		case <-timeout:
+			t.Fatal("Channel not closed after cancel")
+		}
+	}
+}
+
+func TestAcpiListenCmd(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	events := NewAcpiListener(AcpiListenCmd(mockAcpiListen)).Listen(ctx)
+	got := receiveUntil(t, events, AcpiLidOpen)
+	want := []AcpiEventKind{
+		AcpiBrightnessDown, AcpiBrightnessUp, AcpiBrightnessDown, AcpiBrightnessUp,
+		AcpiLidClose, AcpiACUnplugged, AcpiLidOpen,
+	}
+	if !slices.Equal(got, want) {
+		t.Errorf("Got %v instead of %v", got, want)
+	}
+	cancel()
+	waitClosed(t, events)
+}
+
+func TestAcpiListenCmdWaits(t *testing.T) {
+	// the last line is read before the command is waited for
+	script := filepath.Join(t.TempDir(), "acpi_listen")
+	if err := os.WriteFile(script, []byte("#!/bin/sh\necho 'button/lid LID close'\necho 'button/lid LID open'\n"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	r, err := AcpiListenCmd(script)(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	out, err := io.ReadAll(r)
+	if err != nil || !strings.HasSuffix(string(out), "LID open\n") {
+		t.Errorf("Got %q, %v instead of both events", out, err)
+	}
+	if state := r.(*acpiCmdOutput).cmd.ProcessState; state == nil || !state.Success() {
+		t.Errorf("Got %v instead of a successful exit", state)
+	}
+	// the process group is not signaled after the command was reaped
+	if err := r.(*acpiCmdOutput).stop(); !errors.Is(err, os.ErrProcessDone) {
+		t.Errorf("Got %v instead of %v", err, os.ErrProcessDone)
+	}
+	if err := r.Close(); err != nil {
+		t.Errorf("Got error %v", err)
+	}
+
+	// a running command is stopped and waited for when ctx is done
+	ctx, cancel := context.WithCancel(context.Background())
+	r, err = AcpiListenCmd(mockAcpiListen)(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := bufio.NewReader(r).ReadString('\n'); err != nil {
+		t.Fatal(err)
+	}
+	cancel()
+	io.Copy(io.Discard, r)
+	if state := r.(*acpiCmdOutput).cmd.ProcessState; state == nil {
+		t.Error("Command was not waited for")
+	}
+}
 
-	if event != acpiLidOpenEvent {
-		t.Errorf("got %q, want %q", event, acpiLidOpenEvent)
+// countingSource records when it was opened and hands out a reader with
+// lines every time, or fails if fail is set
+type countingSource struct {
+	mu    sync.Mutex
+	lines string
+	opens []time.Time
+	fail  bool
+}
+
+func (s *countingSource) open(ctx context.Context) (io.ReadCloser, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.opens = append(s.opens, time.Now())
+	if s.fail {
+		return nil, errors.New("no acpid")
+	}
+	return io.NopCloser(strings.NewReader(s.lines)), nil
+}
+
+func (s *countingSource) openTimes() []time.Time {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return slices.Clone(s.opens)
+}
+
+func TestAcpiListenerRestarts(t *testing.T) {
+	source := &countingSource{lines: "button/lid LID close\nbutton/lid LID open\n"}
+	l := &AcpiListener{source: source.open, minBackoff: time.Millisecond, maxBackoff: time.Millisecond}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	events := l.Listen(ctx)
+	for range 3 {
+		receiveUntil(t, events, AcpiLidOpen)
+	}
+	if opens := len(source.openTimes()); opens < 3 {
+		t.Errorf("Got %d opens instead of at least 3", opens)
+	}
+	cancel()
+	waitClosed(t, events)
+}
+
+func TestAcpiListenerBackoff(t *testing.T) {
+	source := &countingSource{fail: true}
+	minBackoff := 10 * time.Millisecond
+	l := &AcpiListener{source: source.open, minBackoff: minBackoff, maxBackoff: 4 * minBackoff}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	events := l.Listen(ctx)
+	deadline := time.Now().Add(5 * time.Second)
+	for len(source.openTimes()) < 5 && time.Now().Before(deadline) {
+		time.Sleep(minBackoff)
+	}
+	cancel()
+	waitClosed(t, events)
+	opens := source.openTimes()
+	if len(opens) < 5 {
+		t.Fatalf("Got %d opens instead of at least 5", len(opens))
+	}
+	for i, factor := range []time.Duration{1, 2, 4, 4} {
+		if gap := opens[i+1].Sub(opens[i]); gap < factor*minBackoff {
+			t.Errorf("Retry %d after %s instead of at least %s", i+1, gap, factor*minBackoff)
+		}
+	}
+}
+
+// fakeAcpid listens on a socket like acpid does and sends lines to every
+// client, then closes the connection.
+func fakeAcpid(t *testing.T, lines ...string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "acpid.socket")
+	l, err := net.Listen("unix", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			for _, line := range lines {
+				fmt.Fprintln(conn, line)
+			}
+			conn.Close()
+		}
+	}()
+	return path
+}
+
+func TestAcpidSocket(t *testing.T) {
+	path := fakeAcpid(t, "ac_adapter ACPI0003:00 00000080 00000001", "button/lid LID open")
+	l := NewAcpiListener(AcpidSocket(path))
+	l.minBackoff = time.Millisecond
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	events := l.Listen(ctx)
+	got := receiveUntil(t, events, AcpiLidOpen)
+	if want := []AcpiEventKind{AcpiACPlugged, AcpiLidOpen}; !slices.Equal(got, want) {
+		t.Errorf("Got %v instead of %v", got, want)
+	}
+	// acpid went away, the listener reconnects
+	got = receiveUntil(t, events, AcpiLidOpen)
+	if want := []AcpiEventKind{AcpiACPlugged, AcpiLidOpen}; !slices.Equal(got, want) {
+		t.Errorf("Got %v instead of %v after reconnect", got, want)
+	}
+	cancel()
+	waitClosed(t, events)
+}
+
+func TestAcpiListenerCancelWhileReading(t *testing.T) {
+	// a connection that never sends anything
+	path := filepath.Join(t.TempDir(), "acpid.socket")
+	ln, err := net.Listen("unix", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	ctx, cancel := context.WithCancel(context.Background())
+	events := NewAcpiListener(AcpidSocket(path)).Listen(ctx)
+	conn, err := ln.Accept()
+	if err != nil {
+		t.Fatal(err)
 	}
+	defer conn.Close()
+	cancel()
+	waitClosed(t, events)
 }
@@ -0,0 +1,285 @@
+package main
+
+import (
+	"cmp"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	iioDevicesDir           = "/sys/bus/iio/devices"
+	DefaultAmbientLuxNight  = 10.0
+	DefaultAmbientLuxDay    = 500.0
+	DefaultAmbientPolicy    = "min"
+	DefaultAmbientWeight    = 0.5
+	DefaultAmbientSmoothing = time.Minute
+)
+
+// ambientPolicies are the ways to combine ambient light with the time based
+// brightness level:
+//
+//	min       the darker of both
+//	max       the brighter of both
+//	weighted  the time based level moved towards the ambient level by
+//	          -ambientWeight
+var ambientPolicies = []string{"min", "max", "weighted"}
+
+func validateAmbientPolicy(value string) error {
+	if !slices.Contains(ambientPolicies, value) {
+		return fmt.Errorf("Unknown ambient policy %q, must be one of: %s", value, strings.Join(ambientPolicies, ", "))
+	}
+	return nil
+}
+
+func validateAmbientWeight(value string) error {
+	w, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		return err
+	}
+	if w < 0 || w > 1 {
+		return fmt.Errorf("Value (%g) must be >=0 and <=1", w)
+	}
+	return nil
+}
+
+// readSysfsFloat reads a file holding a single number
+func readSysfsFloat(path string) (float64, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return 0, err
+	}
+	return strconv.ParseFloat(strings.TrimSpace(string(b)), 64)
+}
+
+// isLightSensor tells whether the IIO device in dir measures illuminance
+func isLightSensor(dir string) bool {
+	for _, name := range []string{"in_illuminance_input", "in_illuminance_raw"} {
+		if _, err := os.Stat(filepath.Join(dir, name)); err == nil {
+			return true
+		}
+	}
+	return false
+}
+
+// FindLightSensor returns the first IIO device in devicesDir (usually
+// /sys/bus/iio/devices) that measures illuminance.
+func FindLightSensor(devicesDir string) (string, error) {
+	entries, err := os.ReadDir(devicesDir)
+	if err != nil {
+		return "", err
+	}
+	for _, e := range entries {
+		dir := filepath.Join(devicesDir, e.Name())
+		if isLightSensor(dir) {
+			return dir, nil
+		}
+	}
+	return "", fmt.Errorf("No light sensor found in %s", devicesDir)
+}
+
+// ReadLux reads the illuminance from the IIO device in dir. Processed
+// values (in_illuminance_input) are used as they are. Raw values are
+// converted with the offset and scale the driver provides, or with scale
+// if it is not 0, which also forces using the raw value.
+func ReadLux(dir string, scale float64) (float64, error) {
+	if scale == 0 {
+		lux, err := readSysfsFloat(filepath.Join(dir, "in_illuminance_input"))
+		if err == nil || !errors.Is(err, os.ErrNotExist) {
+			return lux, err
+		}
+	}
+	raw, err := readSysfsFloat(filepath.Join(dir, "in_illuminance_raw"))
+	if err != nil {
+		return 0, err
+	}
+	// offset and scale are optional
+	offset, _ := readSysfsFloat(filepath.Join(dir, "in_illuminance_offset"))
+	if scale == 0 {
+		scale, err = readSysfsFloat(filepath.Join(dir, "in_illuminance_scale"))
+		if err != nil {
+			scale = 1
+		}
+	}
+	return (raw + offset) * scale, nil
+}
+
+// LuxLevel maps illuminance to a brightness level, 0.0 at or below
+// luxNight and 1.0 at or above luxDay. In between it follows the logarithm,
+// which is closer to how bright a room feels.
+func LuxLevel(lux, luxNight, luxDay float64) float64 {
+	if lux <= luxNight {
+		return 0.0
+	}
+	return roundFloat3(clamp(math.Log(lux/luxNight)/math.Log(luxDay/luxNight), 0, 1))
+}
+
+// BlendBrightness combines the time based brightness level with the
+// ambient level according to policy
+func BlendBrightness(brightness, ambient float64, policy string, weight float64) float64 {
+	switch policy {
+	case "max":
+		return max(brightness, ambient)
+	case "weighted":
+		return roundFloat3((1-weight)*brightness + weight*ambient)
+	}
+	return min(brightness, ambient)
+}
+
+// AmbientLight is an ambient light sensor whose readings are smoothed over
+// time
+type AmbientLight struct {
+	mu        sync.Mutex
+	dir       string
+	scale     float64
+	luxNight  float64
+	luxDay    float64
+	policy    string
+	weight    float64
+	smoothing time.Duration
+	// lux is the smoothed illuminance as of sampled, which is zero before
+	// the first successful reading
+	lux     float64
+	sampled time.Time
+}
+
+// AmbientSettings are the flags of the ambient light sensor
+type AmbientSettings struct {
+	// Sensor is the IIO device directory, "auto" or "" for none
+	Sensor    string
+	Scale     float64
+	LuxNight  float64
+	LuxDay    float64
+	Policy    string
+	Weight    float64
+	Smoothing time.Duration
+}
+
+// NewAmbientLight returns the sensor selected in s, or nil if ambient
+// light is not used.
+func NewAmbientLight(s AmbientSettings) (*AmbientLight, error) {
+	return OpenAmbientLight(s, iioDevicesDir)
+}
+
+// OpenAmbientLight is NewAmbientLight looking for sensors in devicesDir
+func OpenAmbientLight(s AmbientSettings, devicesDir string) (*AmbientLight, error) {
+	dir := s.Sensor
+	if dir == "" {
+		return nil, nil
+	}
+	if dir == "auto" {
+		var err error
+		dir, err = FindLightSensor(devicesDir)
+		if err != nil {
+			return nil, err
+		}
+	} else if !isLightSensor(dir) {
+		return nil, fmt.Errorf("%s is no light sensor", dir)
+	}
+	slog.Debug("ambient light sensor", "path", dir)
+	return &AmbientLight{
+		dir:       dir,
+		scale:     s.Scale,
+		luxNight:  cmp.Or(s.LuxNight, DefaultAmbientLuxNight),
+		luxDay:    cmp.Or(s.LuxDay, DefaultAmbientLuxDay),
+		policy:    cmp.Or(s.Policy, DefaultAmbientPolicy),
+		weight:    s.Weight,
+		smoothing: s.Smoothing,
+	}, nil
+}
+
+// Sample reads the sensor and adds the reading to the smoothed value. The
+// weight of a reading grows with the time since the last one, so the
+// result does not depend on how often Sample is called.
+// It is safe to call on a nil AmbientLight.
+func (a *AmbientLight) Sample(now time.Time) error {
+	if a == nil {
+		return nil
+	}
+	lux, err := ReadLux(a.dir, a.scale)
+	if err != nil {
+		return err
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.sampled.IsZero() || a.smoothing <= 0 {
+		a.lux = lux
+	} else {
+		alpha := 1 - math.Exp(-float64(now.Sub(a.sampled))/float64(a.smoothing))
+		a.lux += clamp(alpha, 0, 1) * (lux - a.lux)
+	}
+	a.sampled = now
+	slog.Debug("ambient light", "lux", lux, "smoothed", a.lux)
+	return nil
+}
+
+// Blend returns brightness combined with the ambient light level. Without
+// a reading yet, brightness is returned unchanged.
+// It is safe to call on a nil AmbientLight.
+func (a *AmbientLight) Blend(brightness float64) float64 {
+	if a == nil {
+		return brightness
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.sampled.IsZero() {
+		return brightness
+	}
+	return BlendBrightness(brightness, LuxLevel(a.lux, a.luxNight, a.luxDay), a.policy, a.weight)
+}
+
+// ambientCurve returns the curve for scaling the blended brightness level
+// at when: the one of the transition in progress, otherwise CurveMired if
+// either transition uses it, so changes look alike to the transitions.
+func ambientCurve(cflags Config, when time.Time) Curve {
+	sunrise := Curve(cmp.Or(cflags.SunriseCurve, string(DefaultCurve)))
+	sunset := Curve(cmp.Or(cflags.SunsetCurve, string(DefaultCurve)))
+	source, err := GetSource(cflags, when)
+	if err != nil {
+		return DefaultCurve
+	}
+	phase, err := GetPhase(source, cflags, when)
+	switch {
+	case err != nil:
+		return DefaultCurve
+	case phase == PhaseSunrise:
+		return sunrise
+	case phase == PhaseSunset:
+		return sunset
+	case sunrise == CurveMired || sunset == CurveMired:
+		return CurveMired
+	}
+	return DefaultCurve
+}
+
+// Values returns brightness, temperature and gamma at when with the
+// brightness level blended with the ambient light. If the level does not
+// change, the given values are returned. Keyframes are not affected by
+// ambient light.
+func (a *AmbientLight) Values(cflags Config, when time.Time, brightness float64, temperature, gamma int) (float64, int, int) {
+	if len(cflags.Keyframes) > 0 {
+		return brightness, temperature, gamma
+	}
+	blended := a.Blend(brightness)
+	if blended == brightness {
+		return brightness, temperature, gamma
+	}
+	slog.Debug("blended with ambient light", "brightness", brightness, "blended", blended)
+	temperature, gamma = ScaleValues(cflags, blended, ambientCurve(cflags, when))
+	return blended, temperature, gamma
+}
+
+// BlendStatus returns s with the values blended with the ambient light, as
+// they are applied
+func (a *AmbientLight) BlendStatus(cflags Config, s Status) Status {
+	s.Brightness, s.Temperature, s.Gamma = a.Values(cflags, s.Time, s.Brightness, s.Temperature, s.Gamma)
+	return s
+}
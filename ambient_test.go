@@ -0,0 +1,281 @@
+package main
+
+import (
+	"math"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// fakeIIODevice creates an IIO device directory with the given attribute
+// files in devicesDir and returns its path
+func fakeIIODevice(t *testing.T, devicesDir, name string, attrs map[string]string) string {
+	t.Helper()
+	dir := filepath.Join(devicesDir, name)
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	for file, content := range attrs {
+		if err := os.WriteFile(filepath.Join(dir, file), []byte(content+"\n"), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir
+}
+
+func TestFindLightSensor(t *testing.T) {
+	devicesDir := t.TempDir()
+	fakeIIODevice(t, devicesDir, "iio:device0", map[string]string{"in_accel_x_raw": "12"})
+	if _, err := FindLightSensor(devicesDir); err == nil {
+		t.Error("Expected error without light sensor")
+	}
+	als := fakeIIODevice(t, devicesDir, "iio:device1", map[string]string{"in_illuminance_raw": "120"})
+	if dir, err := FindLightSensor(devicesDir); dir != als || err != nil {
+		t.Errorf("Got %q, %v instead of %q", dir, err, als)
+	}
+}
+
+type ReadLuxTestCase struct {
+	attrs    map[string]string
+	scale    float64
+	expected float64
+}
+
+func TestReadLux(t *testing.T) {
+	tests := map[string]ReadLuxTestCase{
+		"processed": {
+			map[string]string{"in_illuminance_input": "250.5", "in_illuminance_raw": "1000"},
+			0, 250.5,
+		},
+		"raw only": {
+			map[string]string{"in_illuminance_raw": "120"},
+			0, 120,
+		},
+		"raw with scale and offset": {
+			map[string]string{"in_illuminance_raw": "1000", "in_illuminance_scale": "0.25", "in_illuminance_offset": "-200"},
+			0, 200,
+		},
+		"calibrated": {
+			map[string]string{"in_illuminance_input": "250.5", "in_illuminance_raw": "1000", "in_illuminance_scale": "0.25"},
+			0.1, 100,
+		},
+	}
+	for label, test := range tests {
+		t.Run(label, func(t *testing.T) {
+			dir := fakeIIODevice(t, t.TempDir(), "iio:device0", test.attrs)
+			lux, err := ReadLux(dir, test.scale)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if math.Abs(lux-test.expected) > 1e-9 {
+				t.Errorf("Got %v instead of %v", lux, test.expected)
+			}
+		})
+	}
+	t.Run("no sensor", func(t *testing.T) {
+		if _, err := ReadLux(t.TempDir(), 0); err == nil {
+			t.Error("Expected error")
+		}
+	})
+	t.Run("garbage", func(t *testing.T) {
+		dir := fakeIIODevice(t, t.TempDir(), "iio:device0", map[string]string{"in_illuminance_input": "bright"})
+		if _, err := ReadLux(dir, 0); err == nil {
+			t.Error("Expected error")
+		}
+	})
+}
+
+type LuxLevelTestCase struct {
+	lux      float64
+	expected float64
+}
+
+func TestLuxLevel(t *testing.T) {
+	tests := map[string]LuxLevelTestCase{
+		"dark":       {0, 0.0},
+		"night":      {10, 0.0},
+		"dim office": {100, 0.589},
+		"day":        {500, 1.0},
+		"sunlight":   {20000, 1.0},
+	}
+	for label, test := range tests {
+		t.Run(label, func(t *testing.T) {
+			if got := LuxLevel(test.lux, 10, 500); got != test.expected {
+				t.Errorf("Got %v instead of %v", got, test.expected)
+			}
+		})
+	}
+}
+
+type BlendBrightnessTestCase struct {
+	brightness float64
+	ambient    float64
+	policy     string
+	expected   float64
+}
+
+func TestBlendBrightness(t *testing.T) {
+	tests := map[string]BlendBrightnessTestCase{
+		"min dark office":    {1.0, 0.2, "min", 0.2},
+		"min night":          {0.0, 1.0, "min", 0.0},
+		"max bright evening": {0.3, 0.8, "max", 0.8},
+		"max":                {1.0, 0.2, "max", 1.0},
+		"weighted":           {1.0, 0.2, "weighted", 0.6},
+		"weighted same":      {0.4, 0.4, "weighted", 0.4},
+	}
+	for label, test := range tests {
+		t.Run(label, func(t *testing.T) {
+			if got := BlendBrightness(test.brightness, test.ambient, test.policy, 0.5); got != test.expected {
+				t.Errorf("Got %v instead of %v", got, test.expected)
+			}
+		})
+	}
+}
+
+func openTestAmbientLight(t *testing.T, smoothing time.Duration) (*AmbientLight, func(lux string)) {
+	t.Helper()
+	dir := fakeIIODevice(t, t.TempDir(), "iio:device0", map[string]string{"in_illuminance_input": "500"})
+	a, err := OpenAmbientLight(AmbientSettings{Sensor: dir, Smoothing: smoothing}, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	set := func(lux string) {
+		if err := os.WriteFile(filepath.Join(dir, "in_illuminance_input"), []byte(lux), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return a, set
+}
+
+func TestOpenAmbientLight(t *testing.T) {
+	if a, err := OpenAmbientLight(AmbientSettings{}, t.TempDir()); a != nil || err != nil {
+		t.Errorf("Got %v, %v without sensor", a, err)
+	}
+	if _, err := OpenAmbientLight(AmbientSettings{Sensor: "auto"}, t.TempDir()); err == nil {
+		t.Error("Expected error without sensor")
+	}
+	if _, err := OpenAmbientLight(AmbientSettings{Sensor: t.TempDir()}, ""); err == nil {
+		t.Error("Expected error for a directory that is no sensor")
+	}
+	devicesDir := t.TempDir()
+	fakeIIODevice(t, devicesDir, "iio:device0", map[string]string{"in_illuminance_raw": "5"})
+	if a, err := OpenAmbientLight(AmbientSettings{Sensor: "auto"}, devicesDir); a == nil || err != nil {
+		t.Errorf("Got %v, %v instead of the sensor", a, err)
+	}
+}
+
+func TestAmbientLightSmoothing(t *testing.T) {
+	a, set := openTestAmbientLight(t, time.Minute)
+	if got := a.Blend(0.7); got != 0.7 {
+		t.Errorf("Got %v instead of unchanged brightness before the first sample", got)
+	}
+	start := time.Date(2025, time.April, 15, 12, 0, 0, 0, time.Local)
+	a.Sample(start)
+	if a.lux != 500 {
+		t.Errorf("Got %v instead of the first reading", a.lux)
+	}
+	// the lights go off, after one time constant 63% of the change is seen
+	set("10")
+	a.Sample(start.Add(30 * time.Second))
+	a.Sample(start.Add(time.Minute))
+	if expected := 500 - 490*(1-math.Exp(-1)); math.Abs(a.lux-expected) > 1e-6 {
+		t.Errorf("Got %v instead of %v", a.lux, expected)
+	}
+	a.Sample(start.Add(time.Hour))
+	if math.Abs(a.lux-10) > 1e-6 {
+		t.Errorf("Got %v instead of 10 after a long time", a.lux)
+	}
+	if got := a.Blend(1.0); got != 0.0 {
+		t.Errorf("Got %v instead of 0.0 in the dark", got)
+	}
+	set("broken")
+	if err := a.Sample(start.Add(2 * time.Hour)); err == nil {
+		t.Error("Expected error for a broken reading")
+	}
+}
+
+func TestGetAndSetBrightnessAmbient(t *testing.T) {
+	cflags := statusTestConfig()
+	cflags.Wakeup = "7:00"
+	cflags.Bedtime = "22:00"
+	noon := time.Date(2025, time.April, 15, 12, 0, 0, 0, time.Local)
+	a, set := openTestAmbientLight(t, 0)
+	out, _ := NewNoneOutput(cflags)
+
+	// a dark office at noon gets night values
+	set("10")
+	a.Sample(noon)
+	GetAndSetBrightness(cflags, out, noon, nil, a)
+	if temperature, gamma, _ := out.Current(); temperature != cflags.NightTemp || gamma != cflags.NightGamma {
+		t.Errorf("Got %d/%d instead of %d/%d", temperature, gamma, cflags.NightTemp, cflags.NightGamma)
+	}
+
+	// daylight keeps the day values
+	set("800")
+	a.Sample(noon)
+	GetAndSetBrightness(cflags, out, noon, nil, a)
+	if temperature, gamma, _ := out.Current(); temperature != cflags.DayTemp || gamma != cflags.DayGamma {
+		t.Errorf("Got %d/%d instead of %d/%d", temperature, gamma, cflags.DayTemp, cflags.DayGamma)
+	}
+
+	// keyframes are not changed
+	set("10")
+	a.Sample(noon)
+	cflags.Keyframes = testKeyframes()
+	GetAndSetBrightness(cflags, out, noon, nil, a)
+	_, expected, _, _ := GetValues(cflags, noon)
+	if temperature, _, _ := out.Current(); temperature != expected {
+		t.Errorf("Got %d instead of keyframe temperature %d", temperature, expected)
+	}
+}
+
+func TestAmbientBlendStatus(t *testing.T) {
+	cflags := statusTestConfig()
+	cflags.Wakeup = "7:00"
+	cflags.Bedtime = "22:00"
+	noon := time.Date(2025, time.April, 15, 12, 0, 0, 0, time.Local)
+	a, set := openTestAmbientLight(t, 0)
+	set("100")
+	a.Sample(noon)
+	s, err := GetStatus(cflags, noon)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s = a.BlendStatus(cflags, s)
+	temperature, gamma := ScaleValues(cflags, 0.589, CurveLinear)
+	if s.Brightness != 0.589 || s.Temperature != temperature || s.Gamma != gamma {
+		t.Errorf("Got %.3f/%d/%d instead of 0.589/%d/%d", s.Brightness, s.Temperature, s.Gamma, temperature, gamma)
+	}
+
+	// mired transitions make the ambient light scale in mired as well
+	cflags.SunsetCurve = string(CurveMired)
+	s, _ = GetStatus(cflags, noon)
+	s = a.BlendStatus(cflags, s)
+	if expected := ScaleMired(0.589, cflags.NightTemp, cflags.DayTemp); s.Temperature != expected {
+		t.Errorf("Got %d instead of %d", s.Temperature, expected)
+	}
+
+	// without sensor the status is unchanged
+	s, _ = GetStatus(cflags, noon)
+	if blended := (*AmbientLight)(nil).BlendStatus(cflags, s); blended != s {
+		t.Errorf("Got %v instead of %v", blended, s)
+	}
+}
+
+func TestAmbientFlags(t *testing.T) {
+	t.Setenv("XDG_CONFIG_HOME", t.TempDir())
+	tests := map[string][]string{
+		"policy":    {"-ambientPolicy", "average"},
+		"weight":    {"-ambientWeight", "1.5"},
+		"lux range": {"-ambientLuxNight", "600"},
+		"zero lux":  {"-ambientLuxNight", "0"},
+	}
+	for label, args := range tests {
+		t.Run(label, func(t *testing.T) {
+			if _, _, err := GetFlags("foo", args); err == nil {
+				t.Errorf("Expected error for %v", args)
+			}
+		})
+	}
+}
@@ -0,0 +1,276 @@
+package main
+
+import (
+	"cmp"
+	"fmt"
+	"log/slog"
+	"math"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	backlightClassDir     = "/sys/class/backlight"
+	DefaultBacklightDay   = 100
+	DefaultBacklightNight = 50
+	logindSessionPath     = "/org/freedesktop/login1/session/auto"
+	logindSession         = "org.freedesktop.login1.Session"
+)
+
+// backlightModes are the values of the -backlight flag
+var backlightModes = []string{"none", "sysfs", "logind"}
+
+// backlightTypes are the kinds of backlight devices in the order they are
+// preferred, see the "type" attribute in sysfs
+var backlightTypes = []string{"firmware", "platform", "raw"}
+
+func validateBacklight(value string) error {
+	if !slices.Contains(backlightModes, value) {
+		return fmt.Errorf("Unknown backlight mode %q, must be one of: %s", value, strings.Join(backlightModes, ", "))
+	}
+	return nil
+}
+
+func validateBacklightPercent(value string) error {
+	percent, err := strconv.Atoi(value)
+	if err != nil {
+		return err
+	}
+	return isBetween(percent, 1, 100)
+}
+
+// readSysfsInt reads a file holding a single integer
+func readSysfsInt(path string) (int, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return 0, err
+	}
+	return strconv.Atoi(strings.TrimSpace(string(b)))
+}
+
+// FindBacklight returns the name of the backlight device in classDir to use.
+// If there are several, firmware interfaces are preferred over platform
+// specific ones, and those over raw access to the graphics card.
+func FindBacklight(classDir string) (string, error) {
+	entries, err := os.ReadDir(classDir)
+	if err != nil {
+		return "", err
+	}
+	best, bestRank := "", len(backlightTypes)
+	for _, e := range entries {
+		b, _ := os.ReadFile(filepath.Join(classDir, e.Name(), "type"))
+		rank := slices.Index(backlightTypes, strings.TrimSpace(string(b)))
+		if rank < 0 {
+			rank = len(backlightTypes) - 1
+		}
+		if best == "" || rank < bestRank {
+			best, bestRank = e.Name(), rank
+		}
+	}
+	if best == "" {
+		return "", fmt.Errorf("No backlight found in %s", classDir)
+	}
+	return best, nil
+}
+
+// sysfsBacklightWriter writes the brightness file directly, which usually
+// needs root or a udev rule giving the video group write access.
+func sysfsBacklightWriter(dir string) func(int) error {
+	return func(value int) error {
+		return os.WriteFile(filepath.Join(dir, "brightness"), []byte(strconv.Itoa(value)), 0o644)
+	}
+}
+
+// logindBacklightWriter asks logind to set the brightness, which is allowed
+// for the user of the active session without further permissions.
+func logindBacklightWriter(address, name string) func(int) error {
+	return func(value int) error {
+		conn, err := dbusDial(address)
+		if err != nil {
+			return err
+		}
+		defer conn.Close()
+		_, err = conn.call(logindName, logindSessionPath, logindSession, "SetBrightness", "backlight", name, uint32(value))
+		return err
+	}
+}
+
+// Backlight sets the brightness of a backlight device according to the
+// brightness level, between its night and day percentage.
+type Backlight struct {
+	mu    sync.Mutex
+	dir   string
+	name  string
+	max   int
+	write func(value int) error
+	day   int
+	night int
+	// respectManual stops changing the backlight after the user changed it,
+	// until the next phase starts
+	respectManual bool
+	// last is the value last written, or -1
+	last int
+	// holdPhase is the phase in which the user changed the backlight, or ""
+	holdPhase Phase
+	manual    bool
+}
+
+// BacklightSettings are the flags of the backlight
+type BacklightSettings struct {
+	// Mode is "none", "sysfs" or "logind"
+	Mode          string
+	Device        string
+	Day           int
+	Night         int
+	RespectManual bool
+}
+
+// NewBacklight returns the backlight selected in s, or nil if backlight
+// control is off.
+func NewBacklight(s BacklightSettings) (*Backlight, error) {
+	return OpenBacklight(s, backlightClassDir, dbusSystemBusAddress())
+}
+
+// OpenBacklight is NewBacklight looking for devices in classDir and talking
+// to logind on the bus at address.
+func OpenBacklight(s BacklightSettings, classDir, address string) (*Backlight, error) {
+	if s.Mode == "" || s.Mode == "none" {
+		return nil, nil
+	}
+	name := s.Device
+	if name == "" {
+		var err error
+		name, err = FindBacklight(classDir)
+		if err != nil {
+			return nil, err
+		}
+	}
+	dir := filepath.Join(classDir, name)
+	max, err := readSysfsInt(filepath.Join(dir, "max_brightness"))
+	if err != nil {
+		return nil, err
+	}
+	if max <= 0 {
+		return nil, fmt.Errorf("Backlight %s has no brightness levels", name)
+	}
+	b := &Backlight{
+		dir:           dir,
+		name:          name,
+		max:           max,
+		write:         sysfsBacklightWriter(dir),
+		day:           cmp.Or(s.Day, DefaultBacklightDay),
+		night:         cmp.Or(s.Night, DefaultBacklightNight),
+		respectManual: s.RespectManual,
+		last:          -1,
+	}
+	if s.Mode == "logind" {
+		b.write = logindBacklightWriter(address, name)
+	}
+	slog.Debug("backlight", "device", name, "max", max, "mode", s.Mode)
+	return b, nil
+}
+
+// Value returns the device value for the brightness level. It never is 0,
+// since that turns the screen off with some drivers.
+func (b *Backlight) Value(brightness float64) int {
+	percent := ScaleBrightness(brightness, b.night, b.day)
+	return max(1, int(math.Round(float64(percent)*float64(b.max)/100)))
+}
+
+// ManualChange tells the backlight that the user is changing the
+// brightness, e. g. because a brightness key was pressed.
+func (b *Backlight) ManualChange() {
+	if b == nil {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.manual = true
+}
+
+// Update sets the backlight for the brightness level in the given phase.
+// With respectManual, nothing is changed after the user changed the
+// backlight until the phase changes.
+// It is safe to call on a nil Backlight.
+func (b *Backlight) Update(brightness float64, phase Phase) error {
+	if b == nil {
+		return nil
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	current, err := readSysfsInt(filepath.Join(b.dir, "brightness"))
+	if err != nil {
+		return err
+	}
+	if b.last >= 0 && current != b.last {
+		b.manual = true
+	}
+	if b.respectManual {
+		if b.manual && b.holdPhase == "" {
+			slog.Info("backlight changed by user, leaving it alone until the next phase", "phase", phase)
+			b.holdPhase = phase
+		}
+		if b.holdPhase == phase {
+			b.manual = false
+			b.last = current
+			return nil
+		}
+		if b.holdPhase != "" {
+			slog.Info("adjusting backlight again", "phase", phase)
+			b.holdPhase = ""
+		}
+	}
+	b.manual = false
+	value := b.Value(brightness)
+	if value == current {
+		b.last = current
+		return nil
+	}
+	slog.Debug("setting backlight", "device", b.name, "value", value, "max", b.max)
+	err = b.write(value)
+	if err != nil {
+		// try again next time instead of taking the old value for a
+		// manual change
+		b.last = -1
+		return err
+	}
+	b.last = value
+	return nil
+}
+
+// BacklightLevel returns the brightness level and phase the backlight
+// follows at the given time
+func BacklightLevel(cflags Config, when time.Time) (float64, Phase, error) {
+	brightness, _, _, err := GetValues(cflags, when)
+	if err != nil {
+		return 0, "", err
+	}
+	source, err := GetSource(cflags, when)
+	if err != nil {
+		return 0, "", err
+	}
+	phase, err := GetPhase(source, cflags, when)
+	return brightness, phase, err
+}
+
+// SetBacklight updates the backlight for the given time, if there is one.
+// If ambient is not nil, the brightness is blended with the ambient light.
+func SetBacklight(cflags Config, backlight *Backlight, ambient *AmbientLight, when time.Time) {
+	if backlight == nil {
+		return
+	}
+	brightness, phase, err := BacklightLevel(cflags, when)
+	if err != nil {
+		slog.Warn("error getting backlight level", "err", err)
+		return
+	}
+	err = backlight.Update(ambient.Blend(brightness), phase)
+	if err != nil {
+		slog.Warn("error setting backlight", "err", err)
+	}
+}
@@ -0,0 +1,225 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+)
+
+// fakeBacklight creates a backlight device in classDir like the kernel does
+// in /sys/class/backlight
+func fakeBacklight(t *testing.T, classDir, name, kind string, max, brightness int) {
+	t.Helper()
+	dir := filepath.Join(classDir, name)
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	files := map[string]string{
+		"type":           kind,
+		"max_brightness": strconv.Itoa(max),
+		"brightness":     strconv.Itoa(brightness),
+	}
+	for file, content := range files {
+		if err := os.WriteFile(filepath.Join(dir, file), []byte(content+"\n"), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func readBacklight(t *testing.T, classDir, name string) int {
+	t.Helper()
+	value, err := readSysfsInt(filepath.Join(classDir, name, "brightness"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return value
+}
+
+func writeBacklight(t *testing.T, classDir, name string, value int) {
+	t.Helper()
+	if err := os.WriteFile(filepath.Join(classDir, name, "brightness"), []byte(strconv.Itoa(value)), 0o644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestFindBacklight(t *testing.T) {
+	classDir := t.TempDir()
+	if _, err := FindBacklight(classDir); err == nil {
+		t.Error("Expected error for no backlight")
+	}
+	fakeBacklight(t, classDir, "intel_backlight", "raw", 96000, 48000)
+	if name, _ := FindBacklight(classDir); name != "intel_backlight" {
+		t.Errorf("Got %q instead of intel_backlight", name)
+	}
+	fakeBacklight(t, classDir, "acpi_video0", "firmware", 100, 50)
+	if name, _ := FindBacklight(classDir); name != "acpi_video0" {
+		t.Errorf("Got %q instead of acpi_video0", name)
+	}
+}
+
+type BacklightValueTestCase struct {
+	brightness float64
+	expected   int
+}
+
+func TestBacklightValue(t *testing.T) {
+	b := &Backlight{max: 255, day: 100, night: 40}
+	tests := map[string]BacklightValueTestCase{
+		"day":   {1.0, 255},
+		"night": {0.0, 102},
+		"half":  {0.5, 179},
+	}
+	for label, test := range tests {
+		t.Run(label, func(t *testing.T) {
+			if got := b.Value(test.brightness); got != test.expected {
+				t.Errorf("Got %d instead of %d", got, test.expected)
+			}
+		})
+	}
+	t.Run("never off", func(t *testing.T) {
+		b := &Backlight{max: 10, day: 100, night: 1}
+		if got := b.Value(0.0); got != 1 {
+			t.Errorf("Got %d instead of 1", got)
+		}
+	})
+}
+
+func openTestBacklight(t *testing.T, respectManual bool) (*Backlight, string) {
+	t.Helper()
+	classDir := t.TempDir()
+	fakeBacklight(t, classDir, "intel_backlight", "raw", 1000, 700)
+	s := BacklightSettings{Mode: "sysfs", Day: 100, Night: 30, RespectManual: respectManual}
+	b, err := OpenBacklight(s, classDir, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b, classDir
+}
+
+func TestOpenBacklightNone(t *testing.T) {
+	for _, mode := range []string{"", "none"} {
+		b, err := OpenBacklight(BacklightSettings{Mode: mode}, t.TempDir(), "")
+		if b != nil || err != nil {
+			t.Errorf("Got %v, %v for %q", b, err, mode)
+		}
+	}
+	// a nil backlight does nothing
+	b := (*Backlight)(nil)
+	b.ManualChange()
+	if err := b.Update(1.0, PhaseDay); err != nil {
+		t.Errorf("Got error %v", err)
+	}
+}
+
+func TestBacklightUpdate(t *testing.T) {
+	b, classDir := openTestBacklight(t, false)
+	steps := []struct {
+		brightness float64
+		phase      Phase
+		expected   int
+	}{
+		{1.0, PhaseDay, 1000},
+		{0.5, PhaseSunset, 650},
+		{0.0, PhaseNight, 300},
+	}
+	for _, step := range steps {
+		if err := b.Update(step.brightness, step.phase); err != nil {
+			t.Fatal(err)
+		}
+		if got := readBacklight(t, classDir, "intel_backlight"); got != step.expected {
+			t.Errorf("Got %d instead of %d in %s", got, step.expected, step.phase)
+		}
+	}
+	// without respectManual, changes by the user are overwritten
+	writeBacklight(t, classDir, "intel_backlight", 800)
+	b.Update(0.0, PhaseNight)
+	if got := readBacklight(t, classDir, "intel_backlight"); got != 300 {
+		t.Errorf("Got %d instead of 300", got)
+	}
+}
+
+func TestBacklightRespectManual(t *testing.T) {
+	b, classDir := openTestBacklight(t, true)
+	b.Update(0.5, PhaseSunset)
+	if got := readBacklight(t, classDir, "intel_backlight"); got != 650 {
+		t.Fatalf("Got %d instead of 650", got)
+	}
+	writeBacklight(t, classDir, "intel_backlight", 900)
+	b.Update(0.4, PhaseSunset)
+	b.Update(0.2, PhaseSunset)
+	if got := readBacklight(t, classDir, "intel_backlight"); got != 900 {
+		t.Errorf("Got %d instead of the value set by the user", got)
+	}
+	b.Update(0.0, PhaseNight)
+	if got := readBacklight(t, classDir, "intel_backlight"); got != 300 {
+		t.Errorf("Got %d instead of 300 in the next phase", got)
+	}
+	// brightness keys hold the backlight even before it changed
+	b.ManualChange()
+	writeBacklight(t, classDir, "intel_backlight", 300)
+	b.Update(1.0, PhaseNight)
+	if got := readBacklight(t, classDir, "intel_backlight"); got != 300 {
+		t.Errorf("Got %d instead of 300 after a brightness key", got)
+	}
+	b.Update(1.0, PhaseSunrise)
+	if got := readBacklight(t, classDir, "intel_backlight"); got != 1000 {
+		t.Errorf("Got %d instead of 1000 in the next phase", got)
+	}
+}
+
+func TestBacklightFlags(t *testing.T) {
+	t.Setenv("XDG_CONFIG_HOME", t.TempDir())
+	_, _, err := GetFlags("foo", []string{"-backlight", "ddc"})
+	if err == nil {
+		t.Error("Expected error for unknown backlight mode")
+	}
+	_, _, err = GetFlags("foo", []string{"-backlightNight", "0"})
+	if err == nil {
+		t.Error("Expected error for backlight off at night")
+	}
+}
+
+// fakeLogindSessions answers SetBrightness calls on the bus at address by
+// writing to the backlight in classDir, like logind does
+func fakeLogindSessions(t *testing.T, address, classDir string) {
+	t.Helper()
+	conn := fakeLogind(t, address)
+	go func() {
+		for {
+			m, err := conn.next()
+			if err != nil {
+				return
+			}
+			if m.kind != dbusMethodCall || m.member != "SetBrightness" {
+				continue
+			}
+			args := m.Args()
+			subsystem, name, value := args.String(), args.String(), args.Uint32()
+			reply := dbusMessage{kind: dbusMethodReturn, replySerial: m.serial, destination: m.sender}
+			if m.path != logindSessionPath || subsystem != "backlight" || args.err != nil {
+				reply = dbusMessage{kind: dbusError, replySerial: m.serial, destination: m.sender, errorName: "org.freedesktop.DBus.Error.InvalidArgs"}
+			} else {
+				os.WriteFile(filepath.Join(classDir, name, "brightness"), []byte(strconv.Itoa(int(value))), 0o644)
+			}
+			conn.send(reply)
+		}
+	}()
+}
+
+func TestBacklightLogind(t *testing.T) {
+	address := startDbusDaemon(t)
+	classDir := t.TempDir()
+	fakeBacklight(t, classDir, "acpi_video0", "firmware", 100, 100)
+	fakeLogindSessions(t, address, classDir)
+	b, err := OpenBacklight(BacklightSettings{Mode: "logind", Day: 100, Night: 40}, classDir, address)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := b.Update(0.0, PhaseNight); err != nil {
+		t.Fatal(err)
+	}
+	if got := readBacklight(t, classDir, "acpi_video0"); got != 40 {
+		t.Errorf("Got %d instead of 40", got)
+	}
+}
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"cmp"
 	"fmt"
 	"log/slog"
 	"strconv"
@@ -10,22 +11,48 @@ import (
 	"github.com/nathan-osman/go-sunrise"
 )
 
+// Transitions places the sunrise and sunset transitions. The sunrise
+// transition starts SunriseOffset after sunrise and takes SunriseDuration,
+// the sunset transition takes SunsetDuration and ends SunsetOffset after
+// sunset. Offsets may be negative.
+type Transitions struct {
+	SunriseDuration time.Duration
+	SunsetDuration  time.Duration
+	SunriseOffset   time.Duration
+	SunsetOffset    time.Duration
+}
+
+// UniformTransitions returns transitions of the same duration, starting at
+// sunrise and ending at sunset.
+func UniformTransitions(duration time.Duration) Transitions {
+	return Transitions{SunriseDuration: duration, SunsetDuration: duration}
+}
+
+// Bounds returns start and end of both transitions for the given sunrise and
+// sunset.
+func (t Transitions) Bounds(sunrise, sunset time.Time) (riseStart, riseEnd, setStart, setEnd time.Time) {
+	riseStart = sunrise.Add(t.SunriseOffset)
+	setEnd = sunset.Add(t.SunsetOffset)
+	return riseStart, riseStart.Add(t.SunriseDuration), setEnd.Add(-t.SunsetDuration), setEnd
+}
+
 // BrightnessLevel returns the brightness based on the time given
 // ranging from 0.0 to 1.0.
 // sunrise and sunset times need to be supplied.
-func BrightnessLevel(when, sunrise, sunset time.Time, transitionDuration time.Duration) float64 {
+func BrightnessLevel(when, sunrise, sunset time.Time, transitions Transitions) float64 {
+	riseStart, riseEnd, setStart, setEnd := transitions.Bounds(sunrise, sunset)
 	// Night
-	if when.Before(sunrise) || when == sunrise || when.After(sunset) || when == sunset {
+	if !when.After(riseStart) || !when.Before(setEnd) {
 		slog.Debug("it is night")
 		return 0.0
 	}
 	// Sunrise
-	if when.Before(sunrise.Add(transitionDuration)) {
-		return roundFloat3(TimeRatio(when, sunrise.Add(transitionDuration), transitionDuration))
+	if when.Before(riseEnd) {
+		return roundFloat3(TimeRatio(when, riseEnd, transitions.SunriseDuration))
 	}
 	// Sunset
-	if when.After(sunset.Add(-transitionDuration)) && when.Before(sunset) {
-		return roundFloat3(1.0 - TimeRatio(when, sunset, transitionDuration))
+	if when.After(setStart) {
+		return roundFloat3(1.0 - TimeRatio(when, setEnd, transitions.SunsetDuration))
 	}
 	// Day
 	return 1.0
@@ -69,43 +96,151 @@ func isBetween(val, low, high int) error {
 }
 
 // GetLocalBrightness returns the current brightness at given location
-func GetLocalBrightness(when time.Time, latitude, longitude float64, transitionDuration time.Duration) float64 {
-	rise, set := sunrise.SunriseSunset(latitude, longitude, when.Year(), when.Month(), when.Day())
-	slog.Debug("calculated sun times", "sunrise", rise, "sunset", set, "lat", latitude, "lon", longitude)
-	return BrightnessLevel(when, rise.Local(), set.Local(), transitionDuration)
+func GetLocalBrightness(when time.Time, latitude, longitude float64, transitions Transitions) float64 {
+	rise, set := LocalTimes(when, latitude, longitude)
+	return BrightnessLevel(when, rise, set, transitions)
 }
 
-// GetScheduledBrightness returns the current brightness based on hard schedule
-// wakeup and bedtime values will be parsed and date-completed.
-func GetScheduledBrightness(when time.Time, wakeup, bedtime string, transitionDuration time.Duration) (float64, error) {
-	wakeupHour, wakeupMinute, err := ParseHourMinute(wakeup)
+// ScheduledTimes returns the wakeup and bedtime pair when belongs to.
+// While the bedtime transition of the previous day has not ended, that is
+// the pair starting the previous day. Once the wakeup transition of the next
+// day has started, e. g. before midnight with a negative sunrise offset, it
+// is the pair of the next day.
+func ScheduledTimes(when time.Time, schedule *Schedule, transitions Transitions) (rise, set time.Time, err error) {
+	prevRise, prevSet, err := schedule.Times(time.Date(when.Year(), when.Month(), when.Day()-1, 12, 0, 0, 0, when.Location()))
 	if err != nil {
-		return 0.0, err
+		return
 	}
-	bedtimeHour, bedtimeMinute, err := ParseHourMinute(bedtime)
+	if when.Before(prevSet.Add(transitions.SunsetOffset)) {
+		rise, set = prevRise, prevSet
+	} else {
+		rise, set, err = schedule.Times(time.Date(when.Year(), when.Month(), when.Day()+1, 12, 0, 0, 0, when.Location()))
+		if err == nil && when.Before(rise.Add(transitions.SunriseOffset)) {
+			rise, set, err = schedule.Times(when)
+		}
+	}
+	slog.Debug("scheduled wakeup/bedtime", "rise", rise, "set", set)
+	return
+}
+
+// GetScheduledBrightness returns the current brightness based on hard schedule
+func GetScheduledBrightness(when time.Time, schedule *Schedule, transitions Transitions) (float64, error) {
+	rise, set, err := ScheduledTimes(when, schedule, transitions)
 	if err != nil {
 		return 0.0, err
 	}
-	rise := time.Date(when.Year(), when.Month(), when.Day(), wakeupHour, wakeupMinute, 0, 0, when.Location())
-	set := time.Date(when.Year(), when.Month(), when.Day(), bedtimeHour, bedtimeMinute, 0, 0, when.Location())
-	slog.Debug("scheduled wakeup/bedtime", "rise", rise, "set", set)
-	return BrightnessLevel(when, rise, set, transitionDuration), nil
+	return BrightnessLevel(when, rise, set, transitions), nil
+}
+
+// LocalTimes returns sunrise and sunset at the given location on the day of
+// when.
+func LocalTimes(when time.Time, latitude, longitude float64) (rise, set time.Time) {
+	rise, set = sunrise.SunriseSunset(latitude, longitude, when.Year(), when.Month(), when.Day())
+	slog.Debug("calculated sun times", "sunrise", rise, "sunset", set, "lat", latitude, "lon", longitude)
+	return rise.In(when.Location()), set.In(when.Location())
+}
+
+// GetTimes returns the times the transitions are based on: wakeup and bedtime
+// of the fixed schedule or sunrise and sunset at the location, depending on
+// the source. Sunrise and sunset are zero during polar day or night.
+func GetTimes(source Source, cflags Config, when time.Time) (rise, set time.Time, err error) {
+	if source.Kind == "schedule" {
+		return ScheduledTimes(when, source.Schedule, cflags.Transitions())
+	}
+	rise, set = LocalTimes(when, cflags.Latitude, cflags.Longitude)
+	return
 }
 
-// GetBrightness returns the brightness based on either location or fixed schedule,
-// depending on which flags are present in cflags.
+// GetBrightness returns the brightness based on either location, sun elevation
+// or fixed schedule, depending on which flags are present in cflags.
 func GetBrightness(cflags Config, when time.Time) (brightness float64, err error) {
-	if cflags.Wakeup != "" {
-		// Parameter -wakeup was supplied. User wants fixed times
-		brightness, err = GetScheduledBrightness(when, cflags.Wakeup, cflags.Bedtime, cflags.TransitionDuration)
+	source, err := GetSource(cflags, when)
+	if err != nil {
+		return 0.0, err
+	}
+	switch source.Kind {
+	case "schedule":
+		// Parameter -wakeup or a schedule was supplied. User wants fixed times
+		brightness, err = GetScheduledBrightness(when, source.Schedule, cflags.Transitions())
 		slog.Debug("scheduled brightness", "brightness", brightness)
-	} else {
-		brightness = GetLocalBrightness(when, cflags.Latitude, cflags.Longitude, cflags.TransitionDuration)
+	case "elevation":
+		brightness = GetElevationBrightness(when, cflags.Latitude, cflags.Longitude, cflags.ElevationNight, cflags.ElevationDay)
+		slog.Debug("elevation brightness", "brightness", brightness)
+	default:
+		brightness = GetLocalBrightness(when, cflags.Latitude, cflags.Longitude, cflags.Transitions())
 		slog.Debug("local brightness", "brightness", brightness)
 	}
 	return
 }
 
+// GetValues returns the brightness and the temperature and gamma values
+// scaled from it for the given time, or interpolated between keyframes if
+// there are any. This is what gets applied to the output, unless overridden.
+func GetValues(cflags Config, when time.Time) (brightness float64, temperature, gamma int, err error) {
+	if len(cflags.Keyframes) > 0 {
+		dayTimes, err := keyframeDayTimes(cflags, when)
+		if err != nil {
+			return 0.0, 0, 0, err
+		}
+		brightness, temperature, gamma, ok, err := KeyframeValues(cflags.Keyframes, when, dayTimes)
+		if ok || err != nil {
+			slog.Debug("keyframe values", "temperature", temperature, "gamma", gamma)
+			return brightness, temperature, gamma, err
+		}
+		slog.Debug("no keyframes around, using day and night values", "when", when)
+	}
+	brightness, err = GetBrightness(cflags, when)
+	curve := CurveLinear
+	if err == nil && brightness > 0.0 && brightness < 1.0 {
+		curve, brightness, err = easeTransition(cflags, when, brightness)
+	}
+	temperature, gamma = ScaleValues(cflags, brightness, curve)
+	return
+}
+
+// keyframeDayTimes returns the function keyframes use to look up sunrise
+// and sunset, from the source in use at when.
+func keyframeDayTimes(cflags Config, when time.Time) (func(time.Time) (time.Time, time.Time, error), error) {
+	source, err := GetSource(cflags, when)
+	if err != nil {
+		return nil, err
+	}
+	return func(day time.Time) (time.Time, time.Time, error) {
+		return GetTimes(source, cflags, day)
+	}, nil
+}
+
+// ScaleValues scales temperature and gamma from the brightness level. With
+// CurveMired, the temperature is scaled linear in mired.
+func ScaleValues(cflags Config, brightness float64, curve Curve) (temperature, gamma int) {
+	temperature = ScaleBrightness(brightness, cflags.NightTemp, cflags.DayTemp)
+	if curve == CurveMired {
+		temperature = ScaleMired(brightness, cflags.NightTemp, cflags.DayTemp)
+	}
+	gamma = ScaleBrightness(brightness, cflags.NightGamma, cflags.DayGamma)
+	return
+}
+
+// easeTransition applies the curve of the transition in progress at when to
+// brightness and returns the curve and the new brightness.
+func easeTransition(cflags Config, when time.Time, brightness float64) (Curve, float64, error) {
+	source, err := GetSource(cflags, when)
+	if err != nil {
+		return CurveLinear, brightness, err
+	}
+	phase, err := GetPhase(source, cflags, when)
+	if err != nil {
+		return CurveLinear, brightness, err
+	}
+	curve := Curve(cmp.Or(cflags.SunriseCurve, string(DefaultCurve)))
+	if phase == PhaseSunset {
+		curve = Curve(cmp.Or(cflags.SunsetCurve, string(DefaultCurve)))
+	}
+	eased := EaseBrightness(brightness, curve, phase)
+	slog.Debug("eased brightness", "curve", curve, "phase", phase, "brightness", eased)
+	return curve, eased, nil
+}
+
 // ScaleBrightness scales the given brightness value to min/max
 // Use this for calculating temperature and gamma values from the brightness level
 func ScaleBrightness(brightness float64, min, max int) int {
@@ -47,7 +47,7 @@ func TestBrightnessLevel(t *testing.T) {
 	for label, test := range tests {
 		t.Run(label, func(t *testing.T) {
 			rise, set := sunrise.SunriseSunset(DefaultLatitude, DefaultLongitude, test.t.Year(), test.t.Month(), test.t.Day())
-			if result := BrightnessLevel(test.t, rise, set, DefaultTransitionDuration); result != test.expected {
+			if result := BrightnessLevel(test.t, rise, set, UniformTransitions(DefaultTransitionDuration)); result != test.expected {
 				// Additional logging to make it easier to spot rounding issues
 				t.Log(result)
 				t.Log(test.expected)
@@ -70,13 +70,118 @@ func TestGetLocalBrightness(t *testing.T) {
 	}
 	for label, test := range tests {
 		t.Run(label, func(t *testing.T) {
-			if result := GetLocalBrightness(test.t, DefaultLatitude, DefaultLongitude, DefaultTransitionDuration); result != test.expected {
+			if result := GetLocalBrightness(test.t, DefaultLatitude, DefaultLongitude, UniformTransitions(DefaultTransitionDuration)); result != test.expected {
 				t.Errorf("Brightness level %f not equal to expected %f", result, test.expected)
 			}
 		})
 	}
 }
 
+func TestBrightnessLevelTransitions(t *testing.T) {
+	rise := time.Date(2025, time.April, 15, 7, 0, 0, 0, time.Local)
+	set := time.Date(2025, time.April, 15, 21, 0, 0, 0, time.Local)
+	// Finish cooling 20 minutes before sunrise, start warming 30 minutes
+	// before sunset and end 30 minutes after it
+	transitions := Transitions{
+		SunriseDuration: 30 * time.Minute,
+		SunsetDuration:  time.Hour,
+		SunriseOffset:   -50 * time.Minute,
+		SunsetOffset:    30 * time.Minute,
+	}
+	tests := map[string]BrightnessLevelTestCase{
+		"Before sunrise transition": {time.Date(2025, time.April, 15, 6, 0, 0, 0, time.Local), 0.0},
+		"During sunrise transition": {time.Date(2025, time.April, 15, 6, 25, 0, 0, time.Local), 0.5},
+		"End of sunrise transition": {time.Date(2025, time.April, 15, 6, 40, 0, 0, time.Local), 1.0},
+		"Sunrise":                   {rise, 1.0},
+		"Before sunset transition":  {time.Date(2025, time.April, 15, 20, 30, 0, 0, time.Local), 1.0},
+		"Sunset":                    {set, 0.5},
+		"During sunset transition":  {time.Date(2025, time.April, 15, 21, 15, 0, 0, time.Local), 0.25},
+		"End of sunset transition":  {time.Date(2025, time.April, 15, 21, 30, 0, 0, time.Local), 0.0},
+	}
+	for label, test := range tests {
+		t.Run(label, func(t *testing.T) {
+			if result := BrightnessLevel(test.t, rise, set, transitions); result != test.expected {
+				t.Errorf("Brightness level %f not equal to expected %f", result, test.expected)
+			}
+			if phase := PhaseAt(test.t, rise, set, transitions); (phase == PhaseSunrise || phase == PhaseSunset) != (test.expected > 0.0 && test.expected < 1.0) {
+				t.Errorf("Got phase %s for brightness %f", phase, test.expected)
+			}
+		})
+	}
+}
+
+type TransitionFlagsTestCase struct {
+	args     []string
+	t        time.Time
+	expected float64
+}
+
+func TestGetBrightnessTransitionFlags(t *testing.T) {
+	rise, set := LocalTimes(time.Date(2025, time.April, 15, 12, 0, 0, 0, time.Local), DefaultLatitude, DefaultLongitude)
+	tests := map[string]TransitionFlagsTestCase{
+		"location, sunrise offset": {
+			[]string{"-sunriseOffset", "-1h"},
+			rise.Add(-30 * time.Minute),
+			0.5,
+		},
+		"location, sunset duration": {
+			[]string{"-sunsetDuration", "2h", "-sunriseDuration", "10m"},
+			set.Add(-30 * time.Minute),
+			0.25,
+		},
+		"location, sunrise duration": {
+			[]string{"-sunsetDuration", "2h", "-sunriseDuration", "10m"},
+			rise.Add(5 * time.Minute),
+			0.5,
+		},
+		"schedule, sunset offset": {
+			[]string{"-fixedWakeup", "7:00", "-fixedBedtime", "22:00", "-sunsetOffset", "30m", "-sunsetDuration", "30m"},
+			time.Date(2025, time.April, 15, 22, 15, 0, 0, time.Local),
+			0.5,
+		},
+		"schedule, sunset transition before midnight": {
+			[]string{"-fixedWakeup", "7:00", "-fixedBedtime", "23:30", "-sunsetOffset", "1h"},
+			time.Date(2025, time.April, 15, 23, 45, 0, 0, time.Local),
+			0.75,
+		},
+		"schedule, sunset transition after midnight": {
+			[]string{"-fixedWakeup", "7:00", "-fixedBedtime", "23:30", "-sunsetOffset", "1h"},
+			time.Date(2025, time.April, 16, 0, 15, 0, 0, time.Local),
+			0.25,
+		},
+		"schedule, sunrise transition before midnight": {
+			[]string{"-fixedWakeup", "0:30", "-fixedBedtime", "22:00", "-sunriseOffset", "-1h"},
+			time.Date(2025, time.April, 15, 23, 45, 0, 0, time.Local),
+			0.25,
+		},
+		"schedule, sunrise transition after midnight": {
+			[]string{"-fixedWakeup", "0:30", "-fixedBedtime", "22:00", "-sunriseOffset", "-1h"},
+			time.Date(2025, time.April, 16, 0, 15, 0, 0, time.Local),
+			0.75,
+		},
+		"schedule, default duration": {
+			[]string{"-fixedWakeup", "7:00", "-fixedBedtime", "22:00", "-sunriseOffset", "-30m", "-transitionDuration", "2h"},
+			time.Date(2025, time.April, 15, 7, 30, 0, 0, time.Local),
+			0.5,
+		},
+	}
+	for label, test := range tests {
+		t.Run(label, func(t *testing.T) {
+			t.Setenv("XDG_CONFIG_HOME", t.TempDir())
+			cflags, _, err := GetFlags("foo", test.args)
+			if err != nil {
+				t.Fatalf("Got error %v", err)
+			}
+			if result, _ := GetBrightness(cflags, test.t); result != test.expected {
+				t.Errorf("Brightness level %f not equal to expected %f", result, test.expected)
+			}
+			if s, _ := GetStatus(cflags, test.t); (s.Phase == PhaseSunrise || s.Phase == PhaseSunset) != (test.expected > 0.0 && test.expected < 1.0) {
+				t.Errorf("Got phase %s for brightness %f", s.Phase, test.expected)
+			}
+		})
+	}
+}
+
 type ScaleBrightnessTestcase struct {
 	brightness float64
 	min        int
@@ -224,6 +329,83 @@ func TestGetScheduledBrightness(t *testing.T) {
 			0.0,
 			nil,
 		},
+		"Wrapped, after wakeup": {
+			time.Date(2025, time.April, 15, 12, 0, 0, 0, time.Local),
+			"9:00",
+			"1:30",
+			1.0,
+			nil,
+		},
+		"Wrapped, before midnight": {
+			time.Date(2025, time.April, 15, 23, 30, 0, 0, time.Local),
+			"9:00",
+			"1:30",
+			1.0,
+			nil,
+		},
+		"Wrapped, after midnight, in the middle of bedtime": {
+			time.Date(2025, time.April, 16, 1, 0, 0, 0, time.Local),
+			"9:00",
+			"1:30",
+			0.5,
+			nil,
+		},
+		"Wrapped, after bedtime": {
+			time.Date(2025, time.April, 16, 1, 45, 0, 0, time.Local),
+			"9:00",
+			"1:30",
+			0.0,
+			nil,
+		},
+		"Wrapped, before wakeup": {
+			time.Date(2025, time.April, 16, 8, 59, 0, 0, time.Local),
+			"9:00",
+			"1:30",
+			0.0,
+			nil,
+		},
+		"Wrapped, bedtime transition straddles midnight, before": {
+			time.Date(2025, time.April, 15, 23, 45, 0, 0, time.Local),
+			"9:00",
+			"0:30",
+			0.75,
+			nil,
+		},
+		"Wrapped, bedtime transition straddles midnight, after": {
+			time.Date(2025, time.April, 16, 0, 15, 0, 0, time.Local),
+			"9:00",
+			"0:30",
+			0.25,
+			nil,
+		},
+		"Wrapped, bedtime at midnight": {
+			time.Date(2025, time.April, 15, 23, 30, 0, 0, time.Local),
+			"9:00",
+			"0:00",
+			0.5,
+			nil,
+		},
+		"Wrapped, wakeup transition straddles midnight": {
+			time.Date(2025, time.April, 16, 0, 15, 0, 0, time.Local),
+			"23:30",
+			"15:00",
+			0.75,
+			nil,
+		},
+		"Wrapped, wakeup transition straddles midnight, before": {
+			time.Date(2025, time.April, 15, 23, 45, 0, 0, time.Local),
+			"23:30",
+			"15:00",
+			0.25,
+			nil,
+		},
+		"Wrapped, across the end of the month": {
+			time.Date(2025, time.May, 1, 1, 0, 0, 0, time.Local),
+			"9:00",
+			"1:30",
+			0.5,
+			nil,
+		},
 		"After bedtime with bedtime parsing error": {
 			time.Date(2025, time.April, 16, 23, 15, 0, 0, time.Local),
 			"6:30",
@@ -255,7 +437,7 @@ func TestGetScheduledBrightness(t *testing.T) {
 	}
 	for label, test := range tests {
 		t.Run(label, func(t *testing.T) {
-			result, err := GetScheduledBrightness(test.t, test.wakeup, test.bedtime, time.Hour)
+			result, err := GetScheduledBrightness(test.t, DailySchedule(test.wakeup, test.bedtime), UniformTransitions(time.Hour))
 			if result != test.expected {
 				// Additional logging to make it easier to spot rounding issues
 				t.Log(result)
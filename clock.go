@@ -1,44 +1,174 @@
 package main
 
 import (
+	"context"
 	"log/slog"
 	"os"
 	"os/signal"
 	"time"
 )
 
-const (
-	acpiLidOpenEvent = "button/lid LID open"
-	acpiListenCmd    = "acpi_listen"
-)
+// Clock tells the time. The loop asks a Clock instead of calling time.Now,
+// so the preview can run through a day faster.
+type Clock interface {
+	Now() time.Time
+	// Until returns the real time until the clock shows t
+	Until(t time.Time) time.Duration
+}
+
+// SystemClock is the real time
+type SystemClock struct{}
+
+func (SystemClock) Now() time.Time {
+	return time.Now()
+}
+
+func (SystemClock) Until(t time.Time) time.Duration {
+	return time.Until(t)
+}
+
+// ScaledClock starts at a given time and runs factor times faster than its
+// base clock.
+type ScaledClock struct {
+	base      Clock
+	baseStart time.Time
+	start     time.Time
+	factor    float64
+}
+
+// NewScaledClock returns a clock showing start now and running factor
+// times faster than base from now on.
+func NewScaledClock(base Clock, start time.Time, factor float64) *ScaledClock {
+	return &ScaledClock{base: base, baseStart: base.Now(), start: start, factor: factor}
+}
+
+func (c *ScaledClock) Now() time.Time {
+	elapsed := c.base.Now().Sub(c.baseStart)
+	return c.start.Add(time.Duration(float64(elapsed) * c.factor))
+}
+
+func (c *ScaledClock) Until(t time.Time) time.Duration {
+	return time.Duration(float64(t.Sub(c.Now())) / c.factor)
+}
 
-// repeatUntilInterrupt runs the given function every interval.
-// It will return whenever one of the signals in interruptSignals is received.
-func repeatUntilInterrupt(callback func(), interval time.Duration, interruptSignals ...os.Signal) {
+// wallClockJumpThreshold is the difference between wall clock and monotonic
+// clock above which the wall clock is considered to have jumped
+const wallClockJumpThreshold = 5 * time.Second
+
+// wallClockJump returns how far the wall clock moved differently from the
+// monotonic clock between last and now, both taken from time.Now. This
+// happens when the time is set or the machine was suspended, since the
+// monotonic clock stops during suspend. It returns 0 for small differences.
+func wallClockJump(last, now time.Time) time.Duration {
+	jump := now.Round(0).Sub(last.Round(0)) - now.Sub(last)
+	if jump.Abs() < wallClockJumpThreshold {
+		return 0
+	}
+	return jump
+}
+
+// sleepUntil returns how long to sleep until clock shows target, at most
+// maxSleep
+func sleepUntil(clock Clock, target time.Time, maxSleep time.Duration) time.Duration {
+	return max(0, min(clock.Until(target), maxSleep))
+}
+
+// loopEvents wake up the loop besides its timer. Nil channels never
+// receive.
+type loopEvents struct {
+	acpi <-chan AcpiEvent
+	// acpiEvent is called for every ACPI event, if not nil
+	acpiEvent func(AcpiEvent)
+	resume    <-chan struct{}
+	// done stops the loop when closed
+	done <-chan struct{}
+}
+
+// systemLoopEvents listens for ACPI events and resume from suspend until
+// ctx is done
+func systemLoopEvents(ctx context.Context) loopEvents {
+	events := loopEvents{acpi: NewAcpiListener(DefaultAcpiSource).Listen(ctx)}
+	resume, err := LogindResumeEvent(dbusSystemBusAddress())
+	if err != nil {
+		slog.Warn("resume from suspend is only noticed by the wall clock, logind is not available", "error", err)
+	}
+	events.resume = resume
+	return events
+}
+
+// repeatUntilInterrupt runs the given callback whenever clock reaches the
+// time returned by next. next is asked again after callback ran, and after every
+// other wakeup in case the target moved closer.
+// Every ACPI event is passed to events.acpiEvent. callback is run after it
+// when the lid was opened or the AC adapter was plugged or unplugged.
+// Timers run on the monotonic clock, so the wall clock is checked at least
+// every maxSleep and callback is run immediately if it jumped. This also
+// catches resume from suspend if logind can not tell.
+// It will return whenever one of the signals in interruptSignals is received
+// or events.done is closed.
+func repeatUntilInterrupt(clock Clock, callback func(), next func(now time.Time) time.Time, maxSleep time.Duration, events loopEvents, interruptSignals ...os.Signal) {
 	slog.Info("running continuously")
-	slog.Debug("loop timing", "interval", DefaultLoopInterval)
-	ticker := time.NewTicker(interval)
 	sigc := make(chan os.Signal, 1)
 	signal.Notify(sigc, interruptSignals...)
+	defer signal.Stop(sigc)
 	quit := make(chan bool)
-	acpiEvent, acpiErr := AcpiEvent(acpiListenCmd, acpiLidOpenEvent)
-	if acpiErr != nil {
-		slog.Warn("ACPI event listener could not be started", "error", acpiErr)
-	}
+	last := time.Now()
+	target := next(clock.Now())
+	slog.Debug("loop timing", "next", target)
+	timer := time.NewTimer(sleepUntil(clock, target, maxSleep))
 	for {
+		called := false
 		select {
-		case <-ticker.C:
-			callback()
-		case <-acpiEvent:
-			slog.Info("acpi event received")
+		case <-timer.C:
+			now := time.Now()
+			if jump := wallClockJump(last, now); jump != 0 {
+				slog.Info("wall clock jumped", "by", jump)
+				callback()
+				called = true
+			} else if !clock.Now().Before(target) {
+				callback()
+				called = true
+			}
+		case e, ok := <-events.acpi:
+			if !ok {
+				events.acpi = nil
+				continue
+			}
+			if events.acpiEvent != nil {
+				events.acpiEvent(e)
+			}
+			switch e.Kind {
+			case AcpiLidOpen, AcpiACPlugged, AcpiACUnplugged:
+				slog.Info("acpi event received", "event", e.Kind)
+				callback()
+				called = true
+			default:
+				continue
+			}
+		case <-events.resume:
+			slog.Info("resumed from suspend")
 			callback()
+			called = true
 		case sig := <-sigc:
 			slog.Debug("received signal", "signal", sig)
 			go func() { quit <- true }()
+			continue
 		case <-quit:
-			ticker.Stop()
+			timer.Stop()
 			slog.Debug("quit")
 			return
+		case <-events.done:
+			timer.Stop()
+			slog.Debug("done")
+			return
+		}
+		last = time.Now()
+		// Keep the target until it is reached, otherwise wakeups for
+		// checking the wall clock would push it further away each time
+		if t := next(clock.Now()); called || t.Before(target) {
+			target = t
 		}
+		slog.Debug("loop timing", "next", target)
+		timer.Reset(sleepUntil(clock, target, maxSleep))
 	}
 }
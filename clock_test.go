@@ -27,9 +27,12 @@ func TestRepeatUntilInterrupt(t *testing.T) {
 				syscall.Kill(syscall.Getpid(), syscall.SIGINT)
 			})
 
-			repeatUntilInterrupt(func() {
+			interval := time.Duration(test.interval) * time.Millisecond
+			repeatUntilInterrupt(SystemClock{}, func() {
 				called = append(called, "called")
-			}, time.Duration(test.interval)*time.Millisecond, syscall.SIGINT)
+			}, func(now time.Time) time.Time {
+				return now.Add(interval)
+			}, MaxLoopInterval, loopEvents{}, syscall.SIGINT)
 
 			expectedCalls := test.totalRuntime / test.interval
 			if len(called) != expectedCalls {
@@ -39,3 +42,125 @@ func TestRepeatUntilInterrupt(t *testing.T) {
 		})
 	}
 }
+
+// The target must be reached even if the loop wakes up earlier to check
+// the wall clock, as it does during a whole day or night
+func TestRepeatUntilInterruptMaxSleep(t *testing.T) {
+	var called int
+	time.AfterFunc(170*time.Millisecond, func() {
+		syscall.Kill(syscall.Getpid(), syscall.SIGINT)
+	})
+	repeatUntilInterrupt(SystemClock{}, func() {
+		called++
+	}, func(now time.Time) time.Time {
+		return now.Add(50 * time.Millisecond)
+	}, 20*time.Millisecond, loopEvents{}, syscall.SIGINT)
+	// called after about 60ms and 120ms
+	if called < 2 {
+		t.Errorf("callback was called %d times instead of at least 2", called)
+	}
+}
+
+func TestRepeatUntilInterruptEvents(t *testing.T) {
+	acpi := make(chan AcpiEvent)
+	resume := make(chan struct{})
+	var called, acpiEvents int
+	go func() {
+		acpi <- AcpiEvent{Kind: AcpiBrightnessUp}
+		acpi <- AcpiEvent{Kind: AcpiLidOpen}
+		resume <- struct{}{}
+		close(acpi)
+		time.Sleep(20 * time.Millisecond)
+		syscall.Kill(syscall.Getpid(), syscall.SIGINT)
+	}()
+	repeatUntilInterrupt(SystemClock{}, func() {
+		called++
+	}, func(now time.Time) time.Time {
+		return now.Add(time.Hour)
+	}, MaxLoopInterval, loopEvents{
+		acpi:      acpi,
+		acpiEvent: func(AcpiEvent) { acpiEvents++ },
+		resume:    resume,
+	}, syscall.SIGINT)
+	if called != 2 || acpiEvents != 2 {
+		t.Errorf("Got %d calls and %d ACPI events instead of 2 and 2", called, acpiEvents)
+	}
+}
+
+// The loop sleeps in real time until the scaled clock reaches the target,
+// and stops when done is closed
+func TestRepeatUntilInterruptScaledClock(t *testing.T) {
+	start := time.Date(2025, time.April, 15, 0, 0, 0, 0, time.UTC)
+	// One hour in 3.6 seconds
+	clock := NewScaledClock(SystemClock{}, start, 1000)
+	done := make(chan struct{})
+	time.AfterFunc(70*time.Millisecond, func() { close(done) })
+	var called []time.Time
+	repeatUntilInterrupt(clock, func() {
+		called = append(called, clock.Now())
+	}, func(now time.Time) time.Time {
+		return now.Add(30 * time.Second)
+	}, MaxLoopInterval, loopEvents{done: done}, syscall.SIGINT)
+	if len(called) != 2 {
+		t.Fatalf("callback was called %d times instead of 2", len(called))
+	}
+	if first := called[0].Sub(start); first < 30*time.Second || first > 45*time.Second {
+		t.Errorf("Got first call after %v instead of 30s", first)
+	}
+}
+
+// fakeClock shows a time that only changes when set
+type fakeClock struct {
+	now time.Time
+}
+
+func (c *fakeClock) Now() time.Time {
+	return c.now
+}
+
+func (c *fakeClock) Until(t time.Time) time.Duration {
+	return t.Sub(c.now)
+}
+
+func TestScaledClock(t *testing.T) {
+	base := &fakeClock{time.Date(2025, time.April, 15, 12, 0, 0, 0, time.UTC)}
+	start := time.Date(2025, time.December, 21, 0, 0, 0, 0, time.UTC)
+	// One day in 20 seconds
+	clock := NewScaledClock(base, start, 4320)
+	if now := clock.Now(); !now.Equal(start) {
+		t.Errorf("Got %v instead of %v", now, start)
+	}
+	base.now = base.now.Add(5 * time.Second)
+	if now, expected := clock.Now(), start.Add(6*time.Hour); !now.Equal(expected) {
+		t.Errorf("Got %v instead of %v", now, expected)
+	}
+	base.now = base.now.Add(15 * time.Second)
+	if now, expected := clock.Now(), start.Add(24*time.Hour); !now.Equal(expected) {
+		t.Errorf("Got %v instead of %v", now, expected)
+	}
+}
+
+func TestSleepUntil(t *testing.T) {
+	now := time.Now()
+	clock := &fakeClock{now}
+	tests := map[time.Duration]time.Duration{
+		10 * time.Second: 10 * time.Second,
+		8 * time.Hour:    MaxLoopInterval,
+		-time.Minute:     0,
+	}
+	for until, expected := range tests {
+		if sleep := sleepUntil(clock, now.Add(until), MaxLoopInterval); sleep != expected {
+			t.Errorf("Got %v instead of %v for %v", sleep, expected, until)
+		}
+	}
+}
+
+func TestWallClockJump(t *testing.T) {
+	last := time.Now()
+	if jump := wallClockJump(last, last.Add(time.Hour)); jump != 0 {
+		t.Errorf("Got jump %v without one", jump)
+	}
+	if jump := wallClockJump(last, time.Now()); jump != 0 {
+		t.Errorf("Got jump %v without one", jump)
+	}
+}
@@ -0,0 +1,207 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"unsafe"
+)
+
+const (
+	configFileName = "config.toml"
+)
+
+// DefaultConfigFile returns $XDG_CONFIG_HOME/nerdshade/config.toml,
+// falling back to ~/.config if $XDG_CONFIG_HOME is not set.
+func DefaultConfigFile() string {
+	configHome := os.Getenv("XDG_CONFIG_HOME")
+	if configHome == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return ""
+		}
+		configHome = filepath.Join(home, ".config")
+	}
+	return filepath.Join(configHome, "nerdshade", configFileName)
+}
+
+// configSections are the sections allowed in the config file. They are
+// interpreted by GetFlags.
+var configSections = map[string]bool{
+	"schedule":  true,
+	"exception": true,
+	"keyframe":  true,
+	"ac":        true,
+	"battery":   true,
+	"monitor":   true,
+}
+
+// flagValidators check flag values beyond what the flag package does
+var flagValidators = map[string]func(string) error{
+	"fixedWakeup":    validateHourMinute,
+	"fixedBedtime":   validateHourMinute,
+	"backend":        validateBackend,
+	"polarSchedule":  validateSchedule,
+	"sunriseCurve":   validateCurve,
+	"sunsetCurve":    validateCurve,
+	"loopInterval":   validateLoopInterval,
+	"power":          validatePower,
+	"backlight":      validateBacklight,
+	"backlightDay":   validateBacklightPercent,
+	"backlightNight": validateBacklightPercent,
+	"ambientPolicy":  validateAmbientPolicy,
+	"ambientWeight":  validateAmbientWeight,
+	"ddcMin":         validateDDCPercent,
+	"ddcMax":         validateDDCPercent,
+	"ddcContrastMin": validateDDCPercent,
+	"ddcContrastMax": validateDDCPercent,
+	"ddcInterval":    validateLoopInterval,
+}
+
+func validateHourMinute(value string) error {
+	if value == "" {
+		return nil
+	}
+	_, _, err := ParseHourMinute(value)
+	return err
+}
+
+func validateBackend(value string) error {
+	if _, ok := outputBackends[value]; !ok {
+		return fmt.Errorf("Unknown backend %q", value)
+	}
+	return nil
+}
+
+// ValidateFlags runs flagValidators on all flags set on the command line
+func ValidateFlags(flags *flag.FlagSet) (err error) {
+	flags.Visit(func(f *flag.Flag) {
+		if validate, ok := flagValidators[f.Name]; ok && err == nil {
+			if verr := validate(f.Value.String()); verr != nil {
+				err = fmt.Errorf("-%s: %w", f.Name, verr)
+			}
+		}
+	})
+	return
+}
+
+// LoadConfigFile reads the config file at path and sets all flags found in
+// it, unless they were already given on the command line.
+// If path is empty, DefaultConfigFile is used.
+// Keys in the config file are named exactly like the flags.
+// If the file does not exist, it is only an error if required is true.
+func LoadConfigFile(flags *flag.FlagSet, path string, required bool) (*tomlDoc, error) {
+	if path == "" {
+		path = DefaultConfigFile()
+	}
+	f, err := os.Open(path)
+	if errors.Is(err, fs.ErrNotExist) && !required {
+		slog.Debug("no config file", "path", path)
+		return &tomlDoc{file: path, root: &tomlTable{}}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	doc, err := parseToml(f, path)
+	if err != nil {
+		return nil, err
+	}
+	onCommandLine := map[string]bool{}
+	flags.Visit(func(f *flag.Flag) {
+		onCommandLine[f.Name] = true
+	})
+	for _, t := range doc.tables {
+		if !configSections[t.name] {
+			return nil, &ConfigError{path, t.line, "", fmt.Errorf("unknown section [%s]", t.name)}
+		}
+	}
+	for _, v := range doc.root.values {
+		fail := func(err error) (*tomlDoc, error) {
+			return nil, &ConfigError{path, v.line, v.key, err}
+		}
+		if flags.Lookup(v.key) == nil || v.key == "config" {
+			return fail(errors.New("unknown setting"))
+		}
+		if _, isArray := v.value.([]any); isArray {
+			return fail(errors.New("arrays are not allowed here"))
+		}
+		if onCommandLine[v.key] {
+			slog.Debug("config setting overridden by command line", "key", v.key)
+			continue
+		}
+		value := tomlString(v.value)
+		if err := flags.Set(v.key, value); err != nil {
+			return fail(fmt.Errorf("invalid value %q", value))
+		}
+		if validate, ok := flagValidators[v.key]; ok {
+			if err := validate(value); err != nil {
+				return fail(err)
+			}
+		}
+	}
+	slog.Debug("loaded config file", "path", path)
+	return doc, nil
+}
+
+// WatchConfigFile returns a channel that receives whenever the config file
+// at path was changed or SIGHUP was received.
+// The directory containing the file is watched, so editors replacing the
+// file and creating it later are both noticed.
+func WatchConfigFile(path string) <-chan struct{} {
+	changes := make(chan struct{}, 1)
+	notify := func() {
+		select {
+		case changes <- struct{}{}:
+		default:
+		}
+	}
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	go func() {
+		for range hup {
+			slog.Debug("received SIGHUP")
+			notify()
+		}
+	}()
+	fd, err := syscall.InotifyInit1(syscall.IN_CLOEXEC)
+	if err != nil {
+		slog.Warn("config file can not be watched, use SIGHUP to reload", "error", err)
+		return changes
+	}
+	_, err = syscall.InotifyAddWatch(fd, filepath.Dir(path), syscall.IN_CLOSE_WRITE|syscall.IN_MOVED_TO|syscall.IN_CREATE)
+	if err != nil {
+		syscall.Close(fd)
+		slog.Debug("config file can not be watched, use SIGHUP to reload", "error", err)
+		return changes
+	}
+	go func() {
+		defer syscall.Close(fd)
+		buf := make([]byte, 4096)
+		for {
+			n, err := syscall.Read(fd, buf)
+			if err != nil {
+				slog.Warn("stopped watching config file", "error", err)
+				return
+			}
+			for offset := 0; offset+syscall.SizeofInotifyEvent <= n; {
+				event := (*syscall.InotifyEvent)(unsafe.Pointer(&buf[offset]))
+				nameStart := offset + syscall.SizeofInotifyEvent
+				name := string(buf[nameStart : nameStart+int(event.Len)])
+				offset = nameStart + int(event.Len)
+				if strings.TrimRight(name, "\x00") == filepath.Base(path) {
+					slog.Debug("config file changed", "path", path)
+					notify()
+				}
+			}
+		}
+	}()
+	return changes
+}
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// writeConfig writes content to a config file in a temporary directory and
+// makes it the default config file.
+func writeConfig(t *testing.T, content string) string {
+	t.Helper()
+	configHome := t.TempDir()
+	t.Setenv("XDG_CONFIG_HOME", configHome)
+	path := filepath.Join(configHome, "nerdshade", configFileName)
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestDefaultConfigFile(t *testing.T) {
+	t.Setenv("XDG_CONFIG_HOME", "/tmp/config")
+	if path := DefaultConfigFile(); path != "/tmp/config/nerdshade/config.toml" {
+		t.Errorf("Got %s", path)
+	}
+}
+
+func TestGetFlagsWithConfigFile(t *testing.T) {
+	writeConfig(t, `
+tempNight = 3500
+gammaNight = 80
+latitude = 69.65
+transitionDuration = "45m"
+fixedWakeup = "7:00"
+fixedBedtime = "23:00"
+`)
+	t.Run("values from file", func(t *testing.T) {
+		c, _, err := GetFlags("foo", []string{})
+		if err != nil {
+			t.Fatalf("Got error %v", err)
+		}
+		if c.NightTemp != 3500 || c.NightGamma != 80 || c.Latitude != 69.65 || c.TransitionDuration != 45*time.Minute || c.Wakeup != "7:00" {
+			t.Errorf("Config file was not applied: %+v", c)
+		}
+		if c.DayTemp != DefaultDayTemp {
+			t.Errorf("Default was not kept: %d", c.DayTemp)
+		}
+	})
+	t.Run("flags override file", func(t *testing.T) {
+		c, _, err := GetFlags("foo", []string{"-tempNight", "3000"})
+		if err != nil {
+			t.Fatalf("Got error %v", err)
+		}
+		if c.NightTemp != 3000 || c.NightGamma != 80 {
+			t.Errorf("Command line did not override config file: %+v", c)
+		}
+	})
+}
+
+type ConfigFileErrorTestCase struct {
+	content  string
+	expected string
+}
+
+func TestGetFlagsConfigFileErrors(t *testing.T) {
+	tests := map[string]ConfigFileErrorTestCase{
+		"unknown key": {
+			"tempNight = 3500\ntempnight = 3000",
+			"config.toml:2: tempnight: unknown setting",
+		},
+		"wrong type": {
+			"\ntempNight = \"warm\"",
+			"config.toml:2: tempNight: invalid value \"warm\"",
+		},
+		"invalid time": {
+			"fixedWakeup = \"25:00\"\nfixedBedtime = \"22:00\"",
+			"config.toml:1: fixedWakeup: Value (25) must be >=0 and <=23",
+		},
+		"unknown backend": {
+			"backend = \"foo\"",
+			"config.toml:1: backend: Unknown backend \"foo\"",
+		},
+		"unknown section": {
+			"\n\n[foo]",
+			"config.toml:3: unknown section [foo]",
+		},
+		"extra bracket": {
+			"[ac]]",
+			"config.toml:1: invalid table header \"[ac]]\"",
+		},
+		"missing bracket": {
+			"\n[[keyframe]",
+			"config.toml:2: invalid table header \"[[keyframe]\"",
+		},
+		"bracket in name": {
+			"[[keyframe]]]",
+			"config.toml:1: invalid table header \"[[keyframe]]]\"",
+		},
+	}
+	for label, test := range tests {
+		t.Run(label, func(t *testing.T) {
+			path := writeConfig(t, test.content)
+			_, _, err := GetFlags("foo", []string{})
+			if err == nil || err.Error() != filepath.Dir(path)+"/"+test.expected {
+				t.Errorf("Got error %v instead of %s", err, test.expected)
+			}
+		})
+	}
+}
+
+func TestGetFlagsExplicitConfigFile(t *testing.T) {
+	t.Run("missing", func(t *testing.T) {
+		_, _, err := GetFlags("foo", []string{"-config", filepath.Join(t.TempDir(), "missing.toml")})
+		if err == nil {
+			t.Errorf("Expected error, got nil")
+		}
+	})
+	t.Run("present", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "other.toml")
+		os.WriteFile(path, []byte("tempDay = 6000"), 0o644)
+		c, _, err := GetFlags("foo", []string{"-config", path})
+		if err != nil || c.DayTemp != 6000 {
+			t.Errorf("Got %v, %d", err, c.DayTemp)
+		}
+	})
+}
+
+func TestValidateFlags(t *testing.T) {
+	_, _, err := GetFlags("foo", []string{"-fixedWakeup", "6:00", "-fixedBedtime", "22:61"})
+	if err == nil || err.Error() != "-fixedBedtime: Value (61) must be >=0 and <=59" {
+		t.Errorf("Got error %v", err)
+	}
+}
+
+func TestWatchConfigFile(t *testing.T) {
+	path := writeConfig(t, "tempNight = 3500")
+	changes := WatchConfigFile(path)
+	os.WriteFile(path, []byte("tempNight = 3000"), 0o644)
+	select {
+	case <-changes:
+	case <-time.After(2 * time.Second):
+		t.Errorf("Change of config file was not noticed")
+	}
+}
@@ -0,0 +1,305 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	controlSocketName = "nerdshade.sock"
+	controlTimeout    = time.Second * 5
+)
+
+// ControlSocketPath returns the path of the control socket a running
+// nerdshade instance listens on: $XDG_RUNTIME_DIR/nerdshade.sock
+func ControlSocketPath() (string, error) {
+	runtimeDir := os.Getenv("XDG_RUNTIME_DIR")
+	if runtimeDir == "" {
+		return "", errors.New("XDG_RUNTIME_DIR not set")
+	}
+	return filepath.Join(runtimeDir, controlSocketName), nil
+}
+
+// Override replaces the scheduled values until it expires.
+type Override struct {
+	// Pause applies neutral values, as if nerdshade was not running
+	Pause bool
+	// Temperature to apply instead of the scheduled one, if not paused
+	Temperature int
+	// Gamma to apply instead of the scheduled one. If 0, the scheduled
+	// gamma is kept.
+	Gamma int
+	// Until is the time the override expires. The zero value means never.
+	Until time.Time
+}
+
+// Values returns the temperature and gamma to apply instead of the scheduled
+// temperature and gamma.
+func (o *Override) Values(temperature, gamma int) (int, int) {
+	if o.Pause {
+		return NeutralTemp, NeutralGamma
+	}
+	if o.Gamma != 0 {
+		gamma = o.Gamma
+	}
+	return o.Temperature, gamma
+}
+
+func (o *Override) String() string {
+	var s string
+	if o.Pause {
+		s = "paused"
+	} else {
+		s = fmt.Sprintf("temperature set to %dK", o.Temperature)
+		if o.Gamma != 0 {
+			s += fmt.Sprintf(", gamma set to %d%%", o.Gamma)
+		}
+	}
+	if !o.Until.IsZero() {
+		s += " until " + o.Until.Format(time.DateTime)
+	}
+	return s
+}
+
+// Control holds the state changed through the control socket
+type Control struct {
+	mu       sync.Mutex
+	override *Override
+	expiry   *time.Timer
+	// changed is called whenever the override was changed or expired
+	changed func()
+}
+
+// NewControl returns a Control calling changed whenever the values to apply
+// might have changed.
+func NewControl(changed func()) *Control {
+	return &Control{changed: changed}
+}
+
+// Override returns the override in effect at the given time, or nil.
+// It is safe to call on a nil Control.
+func (c *Control) Override(when time.Time) *Override {
+	if c == nil {
+		return nil
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.override != nil && !c.override.Until.IsZero() && !when.Before(c.override.Until) {
+		slog.Info("override expired", "override", c.override)
+		c.override = nil
+	}
+	return c.override
+}
+
+// setOverride replaces the current override. o may be nil to resume the
+// schedule.
+func (c *Control) setOverride(o *Override, now time.Time) {
+	c.mu.Lock()
+	c.override = o
+	if c.expiry != nil {
+		c.expiry.Stop()
+		c.expiry = nil
+	}
+	if o != nil && !o.Until.IsZero() {
+		c.expiry = time.AfterFunc(o.Until.Sub(now), c.changed)
+	}
+	c.mu.Unlock()
+	slog.Info("override changed", "override", o)
+	c.changed()
+}
+
+// Handle executes a single request and returns the reply. Requests are:
+//
+//	pause [duration]
+//	resume
+//	toggle [duration]
+//	set temperature gamma [duration]
+//	status
+//
+// toggle resumes if paused and pauses otherwise.
+// A gamma of 0 keeps the scheduled gamma. Without duration, the override
+// lasts until resume is requested.
+func (c *Control) Handle(request string, now time.Time) string {
+	fields := strings.Fields(request)
+	if len(fields) == 0 {
+		return "error: empty request"
+	}
+	until := func(i int) (time.Time, error) {
+		if len(fields) <= i {
+			return time.Time{}, nil
+		}
+		d, err := time.ParseDuration(fields[i])
+		if err != nil || d <= 0 {
+			return time.Time{}, fmt.Errorf("invalid duration %q", fields[i])
+		}
+		return now.Add(d), nil
+	}
+	switch fields[0] {
+	case "pause":
+		t, err := until(1)
+		if err != nil || len(fields) > 2 {
+			return fmt.Sprintf("error: usage: pause [duration] (%v)", err)
+		}
+		c.setOverride(&Override{Pause: true, Until: t}, now)
+	case "resume":
+		c.setOverride(nil, now)
+	case "toggle":
+		t, err := until(1)
+		if err != nil || len(fields) > 2 {
+			return fmt.Sprintf("error: usage: toggle [duration] (%v)", err)
+		}
+		if o := c.Override(now); o != nil && o.Pause {
+			c.setOverride(nil, now)
+		} else {
+			c.setOverride(&Override{Pause: true, Until: t}, now)
+		}
+	case "set":
+		if len(fields) < 3 || len(fields) > 4 {
+			return "error: usage: set temperature gamma [duration]"
+		}
+		temperature, err := strconv.Atoi(fields[1])
+		if err == nil {
+			err = isBetween(temperature, 1000, 20000)
+		}
+		if err != nil {
+			return fmt.Sprintf("error: temperature: %v", err)
+		}
+		gamma, err := strconv.Atoi(fields[2])
+		if err == nil {
+			err = isBetween(gamma, 0, 100)
+		}
+		if err != nil {
+			return fmt.Sprintf("error: gamma: %v", err)
+		}
+		t, err := until(3)
+		if err != nil {
+			return fmt.Sprintf("error: %v", err)
+		}
+		c.setOverride(&Override{Temperature: temperature, Gamma: gamma, Until: t}, now)
+	case "status":
+		if o := c.Override(now); o != nil {
+			return o.String()
+		}
+		return "following schedule"
+	default:
+		return fmt.Sprintf("error: unknown command %q", fields[0])
+	}
+	return "ok"
+}
+
+// ListenControl creates the control socket at path. A stale socket left
+// behind by a crashed instance is removed, but if another instance is
+// still listening an error is returned.
+func ListenControl(path string) (net.Listener, error) {
+	if conn, err := net.Dial("unix", path); err == nil {
+		conn.Close()
+		return nil, fmt.Errorf("%s is in use, is nerdshade already running?", path)
+	}
+	os.Remove(path)
+	return net.Listen("unix", path)
+}
+
+// Serve handles requests on l until l is closed. Every connection carries
+// exactly one request line and gets one reply line.
+func (c *Control) Serve(l net.Listener) {
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			if !errors.Is(err, net.ErrClosed) {
+				slog.Warn("control socket failed", "error", err)
+			}
+			return
+		}
+		go func() {
+			defer conn.Close()
+			conn.SetDeadline(time.Now().Add(controlTimeout))
+			request, err := bufio.NewReader(conn).ReadString('\n')
+			if err != nil && err != io.EOF {
+				slog.Debug("control request failed", "error", err)
+				return
+			}
+			slog.Debug("control request", "request", strings.TrimSpace(request))
+			fmt.Fprintln(conn, c.Handle(request, time.Now()))
+		}()
+	}
+}
+
+// SendControl sends a request to the control socket at path and returns
+// the reply.
+func SendControl(path, request string) (string, error) {
+	conn, err := net.DialTimeout("unix", path, controlTimeout)
+	if err != nil {
+		return "", fmt.Errorf("%w (is nerdshade -loop running?)", err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(controlTimeout))
+	_, err = fmt.Fprintln(conn, request)
+	if err != nil {
+		return "", err
+	}
+	reply, err := io.ReadAll(conn)
+	if err != nil {
+		return "", err
+	}
+	reply = []byte(strings.TrimSpace(string(reply)))
+	if msg, isErr := strings.CutPrefix(string(reply), "error: "); isErr {
+		return "", errors.New(msg)
+	}
+	return string(reply), nil
+}
+
+// CtlRequest turns the arguments of the "ctl" subcommand into a control
+// request, e. g.
+//
+//	set --temp 3500 --for 2h  ->  "set 3500 0 2h0m0s"
+func CtlRequest(progname string, args []string) (string, string, error) {
+	var out strings.Builder
+	if len(args) == 0 {
+		return "", "", fmt.Errorf("Usage: %s ctl pause|resume|toggle|set|status [options]", progname)
+	}
+	flags := flag.NewFlagSet(progname+" ctl "+args[0], flag.ContinueOnError)
+	flags.SetOutput(&out)
+	var temperature, gamma int
+	var dur time.Duration
+	switch args[0] {
+	case "pause", "toggle":
+		flags.DurationVar(&dur, "for", 0, "Pause for this long, e. g. \"1h\" (default: until resumed)")
+	case "set":
+		flags.IntVar(&temperature, "temp", 0, "Color temperature to set (required)")
+		flags.IntVar(&gamma, "gamma", 0, "Gamma to set (default: keep scheduled gamma)")
+		flags.DurationVar(&dur, "for", 0, "Keep values for this long, e. g. \"2h\" (default: until resumed)")
+	case "resume", "status":
+	default:
+		return "", "", fmt.Errorf("Unknown ctl command %q", args[0])
+	}
+	err := flags.Parse(args[1:])
+	if err != nil {
+		return "", out.String(), err
+	}
+	if flags.NArg() > 0 {
+		return "", "", fmt.Errorf("Unexpected argument %q", flags.Arg(0))
+	}
+	request := args[0]
+	switch args[0] {
+	case "set":
+		if temperature == 0 {
+			return "", "", errors.New("--temp is required")
+		}
+		request = fmt.Sprintf("set %d %d", temperature, gamma)
+	}
+	if dur > 0 {
+		request += " " + dur.String()
+	}
+	return request, out.String(), nil
+}
@@ -0,0 +1,241 @@
+package main
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+type ControlHandleTestCase struct {
+	request  string
+	reply    string
+	override *Override
+}
+
+func TestControlHandle(t *testing.T) {
+	now := time.Date(2025, time.April, 15, 17, 0, 0, 0, time.Local)
+	tests := map[string]ControlHandleTestCase{
+		"pause": {
+			"pause",
+			"ok",
+			&Override{Pause: true},
+		},
+		"pause for an hour": {
+			"pause 1h\n",
+			"ok",
+			&Override{Pause: true, Until: now.Add(time.Hour)},
+		},
+		"set temperature": {
+			"set 3500 0 2h",
+			"ok",
+			&Override{Temperature: 3500, Until: now.Add(2 * time.Hour)},
+		},
+		"set temperature and gamma": {
+			"set 3500 80",
+			"ok",
+			&Override{Temperature: 3500, Gamma: 80},
+		},
+		"set temperature too low": {
+			"set 500 0",
+			"error: temperature: Value (500) must be >=1000 and <=20000",
+			nil,
+		},
+		"set without gamma": {
+			"set 3500",
+			"error: usage: set temperature gamma [duration]",
+			nil,
+		},
+		"bad duration": {
+			"set 3500 0 soon",
+			"error: invalid duration \"soon\"",
+			nil,
+		},
+		"resume": {
+			"resume",
+			"ok",
+			nil,
+		},
+		"unknown": {
+			"foo",
+			"error: unknown command \"foo\"",
+			nil,
+		},
+	}
+	for label, test := range tests {
+		t.Run(label, func(t *testing.T) {
+			changed := 0
+			c := NewControl(func() { changed++ })
+			reply := c.Handle(test.request, now)
+			if reply != test.reply {
+				t.Errorf("Got reply %q instead of %q", reply, test.reply)
+			}
+			o := c.Override(now)
+			if (o == nil) != (test.override == nil) || (o != nil && *o != *test.override) {
+				t.Errorf("Got override %v instead of %v", o, test.override)
+			}
+			if reply == "ok" && changed != 1 {
+				t.Errorf("Change callback was called %d times", changed)
+			}
+		})
+	}
+}
+
+func TestControlToggle(t *testing.T) {
+	now := time.Date(2025, time.April, 15, 17, 0, 0, 0, time.Local)
+	c := NewControl(func() {})
+	c.Handle("set 3500 0", now)
+	c.Handle("toggle 1h", now)
+	if o := c.Override(now); o == nil || !o.Pause || !o.Until.Equal(now.Add(time.Hour)) {
+		t.Errorf("Toggle did not pause, got %v", o)
+	}
+	c.Handle("toggle", now)
+	if o := c.Override(now); o != nil {
+		t.Errorf("Toggle did not resume, got %v", o)
+	}
+}
+
+func TestControlOverrideExpires(t *testing.T) {
+	now := time.Date(2025, time.April, 15, 17, 0, 0, 0, time.Local)
+	c := NewControl(func() {})
+	c.Handle("pause 30m", now)
+	if c.Override(now.Add(29*time.Minute)) == nil {
+		t.Errorf("Override expired too early")
+	}
+	if c.Override(now.Add(30*time.Minute)) != nil {
+		t.Errorf("Override did not expire")
+	}
+	if status := c.Handle("status", now); status != "following schedule" {
+		t.Errorf("Got status %q", status)
+	}
+	var nilControl *Control
+	if nilControl.Override(now) != nil {
+		t.Errorf("nil Control returned an override")
+	}
+}
+
+func TestControlExpiryCallsChanged(t *testing.T) {
+	changed := make(chan bool, 2)
+	c := NewControl(func() { changed <- true })
+	c.Handle("pause 50ms", time.Now())
+	<-changed
+	select {
+	case <-changed:
+	case <-time.After(time.Second):
+		t.Errorf("Change callback was not called on expiry")
+	}
+}
+
+func TestOverrideValues(t *testing.T) {
+	tests := map[string]struct {
+		override    Override
+		temperature int
+		gamma       int
+	}{
+		"pause":      {Override{Pause: true}, NeutralTemp, NeutralGamma},
+		"keep gamma": {Override{Temperature: 3000}, 3000, 95},
+		"set both":   {Override{Temperature: 3000, Gamma: 70}, 3000, 70},
+	}
+	for label, test := range tests {
+		t.Run(label, func(t *testing.T) {
+			temperature, gamma := test.override.Values(4500, 95)
+			if temperature != test.temperature || gamma != test.gamma {
+				t.Errorf("Got %d/%d instead of %d/%d", temperature, gamma, test.temperature, test.gamma)
+			}
+		})
+	}
+}
+
+func TestControlSocket(t *testing.T) {
+	path := filepath.Join(t.TempDir(), controlSocketName)
+	l, err := ListenControl(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	c := NewControl(func() {})
+	go c.Serve(l)
+	t.Run("second instance", func(t *testing.T) {
+		if _, err := ListenControl(path); err == nil {
+			t.Errorf("Expected error, got nil")
+		}
+	})
+	t.Run("requests", func(t *testing.T) {
+		if reply, err := SendControl(path, "set 4000 0 1h"); reply != "ok" || err != nil {
+			t.Errorf("Got %q, %v", reply, err)
+		}
+		if reply, _ := SendControl(path, "status"); reply[:26] != "temperature set to 4000K u" {
+			t.Errorf("Got status %q", reply)
+		}
+		if _, err := SendControl(path, "foo"); err == nil || err.Error() != "unknown command \"foo\"" {
+			t.Errorf("Got error %v", err)
+		}
+	})
+}
+
+func TestListenControlStaleSocket(t *testing.T) {
+	path := filepath.Join(t.TempDir(), controlSocketName)
+	l, _ := net.Listen("unix", path)
+	// Simulate a crash, leaving the socket file behind
+	l.(*net.UnixListener).SetUnlinkOnClose(false)
+	l.Close()
+	if _, err := os.Stat(path); err != nil {
+		t.Fatal("Socket file is missing")
+	}
+	l, err := ListenControl(path)
+	if err != nil {
+		t.Fatalf("Got error %v", err)
+	}
+	l.Close()
+}
+
+type CtlRequestTestCase struct {
+	args     []string
+	expected string
+	err      bool
+}
+
+func TestCtlRequest(t *testing.T) {
+	tests := map[string]CtlRequestTestCase{
+		"pause":          {[]string{"pause"}, "pause", false},
+		"pause for":      {[]string{"pause", "--for", "1h"}, "pause 1h0m0s", false},
+		"resume":         {[]string{"resume"}, "resume", false},
+		"status":         {[]string{"status"}, "status", false},
+		"set":            {[]string{"set", "--temp", "3500", "--for", "2h"}, "set 3500 0 2h0m0s", false},
+		"set with gamma": {[]string{"set", "-temp", "3500", "-gamma", "90"}, "set 3500 90", false},
+		"set no temp":    {[]string{"set", "--gamma", "90"}, "", true},
+		"unknown":        {[]string{"foo"}, "", true},
+		"no command":     {[]string{}, "", true},
+		"extra argument": {[]string{"resume", "now"}, "", true},
+	}
+	for label, test := range tests {
+		t.Run(label, func(t *testing.T) {
+			request, _, err := CtlRequest("nerdshade", test.args)
+			if (err != nil) != test.err {
+				t.Errorf("Error expected? (%v) but got %v", test.err, err)
+			}
+			if request != test.expected {
+				t.Errorf("Got request %q instead of %q", request, test.expected)
+			}
+		})
+	}
+}
+
+func TestGetAndSetBrightnessOverride(t *testing.T) {
+	cflags := Config{
+		DayTemp:            DefaultDayTemp,
+		NightTemp:          DefaultNightTemp,
+		DayGamma:           DefaultDayGamma,
+		NightGamma:         DefaultNightGamma,
+		Latitude:           DefaultLatitude,
+		Longitude:          DefaultLongitude,
+		TransitionDuration: DefaultTransitionDuration,
+	}
+	out, _ := NewNoneOutput(cflags)
+	night := time.Date(2025, time.April, 15, 23, 0, 0, 0, time.Local)
+	GetAndSetBrightness(cflags, out, night, &Override{Temperature: 3000}, nil)
+	if temperature, gamma, _ := out.Current(); temperature != 3000 || gamma != DefaultNightGamma {
+		t.Errorf("Got %d/%d instead of 3000/%d", temperature, gamma, DefaultNightGamma)
+	}
+}
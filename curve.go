@@ -0,0 +1,79 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"strings"
+)
+
+// Curve shapes the sunrise or sunset transition
+type Curve string
+
+const (
+	CurveLinear     Curve = "linear"
+	CurveSmoothstep Curve = "smoothstep"
+	CurveSine       Curve = "sine"
+	// CurveExponential starts slowly and speeds up towards the end
+	CurveExponential Curve = "exponential"
+	// CurveMired is linear in time, but interpolates the temperature in
+	// mired (1000000/K) instead of Kelvin. Steps then look alike along the
+	// whole range, while steps in Kelvin are very noticeable near the warm
+	// end and invisible near the cool end.
+	CurveMired Curve = "mired"
+
+	DefaultCurve = CurveLinear
+	// exponentialSteepness is the base 2 exponent at the end of
+	// CurveExponential
+	exponentialSteepness = 5.0
+)
+
+// Curves lists all curves in the order they are documented
+var Curves = []Curve{CurveLinear, CurveSmoothstep, CurveSine, CurveExponential, CurveMired}
+
+func curveNames() string {
+	names := make([]string, len(Curves))
+	for i, c := range Curves {
+		names[i] = string(c)
+	}
+	return strings.Join(names, ", ")
+}
+
+func validateCurve(value string) error {
+	for _, c := range Curves {
+		if Curve(value) == c {
+			return nil
+		}
+	}
+	return fmt.Errorf("Unknown curve %q", value)
+}
+
+// Ease maps the progress x of a transition, from 0.0 to 1.0, to the
+// progress of brightness.
+func (c Curve) Ease(x float64) float64 {
+	switch c {
+	case CurveSmoothstep:
+		return x * x * (3 - 2*x)
+	case CurveSine:
+		return (1 - math.Cos(math.Pi*x)) / 2
+	case CurveExponential:
+		return (math.Exp2(exponentialSteepness*x) - 1) / (math.Exp2(exponentialSteepness) - 1)
+	}
+	return x
+}
+
+// EaseBrightness applies the curve to the brightness during a transition.
+// Brightness falls during sunset, so the curve is applied to 1 - brightness
+// then.
+func EaseBrightness(brightness float64, curve Curve, phase Phase) float64 {
+	if phase == PhaseSunset {
+		return roundFloat3(1 - curve.Ease(1-brightness))
+	}
+	return roundFloat3(curve.Ease(brightness))
+}
+
+// ScaleMired scales the given brightness value to the temperatures min/max
+// like ScaleBrightness, but linear in mired.
+func ScaleMired(brightness float64, min, max int) int {
+	minMired, maxMired := 1e6/float64(min), 1e6/float64(max)
+	return int(math.Round(1e6 / (minMired + (maxMired-minMired)*brightness)))
+}
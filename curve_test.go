@@ -0,0 +1,109 @@
+package main
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+type EaseTestCase struct {
+	curve    Curve
+	x        float64
+	expected float64
+}
+
+func TestEase(t *testing.T) {
+	tests := map[string]EaseTestCase{
+		"linear":            {CurveLinear, 0.25, 0.25},
+		"mired is linear":   {CurveMired, 0.25, 0.25},
+		"smoothstep":        {CurveSmoothstep, 0.25, 0.15625},
+		"smoothstep middle": {CurveSmoothstep, 0.5, 0.5},
+		"sine":              {CurveSine, 0.25, 0.14645},
+		"sine middle":       {CurveSine, 0.5, 0.5},
+		"exponential":       {CurveExponential, 0.5, 0.15022},
+		"exponential start": {CurveExponential, 0.0, 0.0},
+		"exponential end":   {CurveExponential, 1.0, 1.0},
+	}
+	for label, test := range tests {
+		t.Run(label, func(t *testing.T) {
+			if result := test.curve.Ease(test.x); math.Abs(result-test.expected) > 0.00001 {
+				t.Errorf("Got %f instead of %f", result, test.expected)
+			}
+		})
+	}
+}
+
+func TestEaseBrightness(t *testing.T) {
+	// Exponential starts slowly, so brightness stays high at the start of
+	// sunset and low at the start of sunrise
+	if result := EaseBrightness(0.75, CurveExponential, PhaseSunset); result != 0.956 {
+		t.Errorf("Got %f during sunset", result)
+	}
+	if result := EaseBrightness(0.75, CurveExponential, PhaseSunrise); result != 0.402 {
+		t.Errorf("Got %f during sunrise", result)
+	}
+}
+
+func TestScaleMired(t *testing.T) {
+	tests := map[float64]int{0.0: 4000, 0.5: 4952, 1.0: 6500}
+	for brightness, expected := range tests {
+		if result := ScaleMired(brightness, 4000, 6500); result != expected {
+			t.Errorf("Got %d instead of %d for %f", result, expected, brightness)
+		}
+	}
+}
+
+type CurveValuesTestCase struct {
+	t                   time.Time
+	sunriseCurve        string
+	sunsetCurve         string
+	expectedBrightness  float64
+	expectedTemperature int
+	expectedGamma       int
+}
+
+func TestGetValuesCurves(t *testing.T) {
+	tests := map[string]CurveValuesTestCase{
+		"linear sunset": {
+			time.Date(2025, time.April, 15, 21, 30, 0, 0, time.Local), "", "", 0.5, 5250, 95,
+		},
+		"mired sunset": {
+			time.Date(2025, time.April, 15, 21, 30, 0, 0, time.Local), "linear", "mired", 0.5, 4952, 95,
+		},
+		"smoothstep sunset": {
+			time.Date(2025, time.April, 15, 21, 45, 0, 0, time.Local), "linear", "smoothstep", 0.156, 4390, 91,
+		},
+		"exponential sunrise": {
+			time.Date(2025, time.April, 15, 7, 30, 0, 0, time.Local), "exponential", "linear", 0.15, 4375, 91,
+		},
+		"sunset curve not used during sunrise": {
+			time.Date(2025, time.April, 15, 7, 30, 0, 0, time.Local), "linear", "exponential", 0.5, 5250, 95,
+		},
+		"day": {
+			time.Date(2025, time.April, 15, 12, 0, 0, 0, time.Local), "mired", "mired", 1.0, 6500, 100,
+		},
+	}
+	for label, test := range tests {
+		t.Run(label, func(t *testing.T) {
+			cflags := statusTestConfig()
+			cflags.Wakeup = "7:00"
+			cflags.Bedtime = "22:00"
+			cflags.SunriseCurve = test.sunriseCurve
+			cflags.SunsetCurve = test.sunsetCurve
+			brightness, temperature, gamma, err := GetValues(cflags, test.t)
+			if err != nil {
+				t.Fatalf("Got error %v", err)
+			}
+			if brightness != test.expectedBrightness || temperature != test.expectedTemperature || gamma != test.expectedGamma {
+				t.Errorf("Got %.3f %d %d instead of %.3f %d %d", brightness, temperature, gamma, test.expectedBrightness, test.expectedTemperature, test.expectedGamma)
+			}
+		})
+	}
+}
+
+func TestCurveFlags(t *testing.T) {
+	_, _, err := GetFlags("foo", []string{"-sunsetCurve", "cubic"})
+	if err == nil || err.Error() != "-sunsetCurve: Unknown curve \"cubic\"" {
+		t.Errorf("Got error %v", err)
+	}
+}
@@ -0,0 +1,488 @@
+package main
+
+import (
+	"bufio"
+	"cmp"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// This is a minimal implementation of the D-Bus wire protocol, just enough
+// to call simple methods and receive signals with basic arguments.
+// See https://dbus.freedesktop.org/doc/dbus-specification.html
+
+const (
+	dbusSystemBusDefaultAddress = "unix:path=/var/run/dbus/system_bus_socket"
+	// dbusDialTimeout limits connecting, authentication and Hello, so a
+	// hanging bus does not block startup
+	dbusDialTimeout = time.Second * 2
+
+	// message types
+	dbusMethodCall   = 1
+	dbusMethodReturn = 2
+	dbusError        = 3
+	dbusSignal       = 4
+
+	// header fields
+	dbusFieldPath        = 1
+	dbusFieldInterface   = 2
+	dbusFieldMember      = 3
+	dbusFieldErrorName   = 4
+	dbusFieldReplySerial = 5
+	dbusFieldDestination = 6
+	dbusFieldSender      = 7
+	dbusFieldSignature   = 8
+
+	dbusHeaderSize = 16
+	// dbusMaxMessageSize is the limit the reference implementation uses
+	dbusMaxMessageSize = 1 << 27
+)
+
+// dbusObjectPath marks a string argument as object path
+type dbusObjectPath string
+
+// dbusMessage is a single method call, reply, error or signal. body holds
+// the still encoded arguments.
+type dbusMessage struct {
+	kind        byte
+	serial      uint32
+	replySerial uint32
+	path        string
+	iface       string
+	member      string
+	errorName   string
+	destination string
+	sender      string
+	signature   string
+	order       binary.ByteOrder
+	body        []byte
+}
+
+// Args returns a decoder for the message arguments
+func (m dbusMessage) Args() *dbusArgs {
+	return &dbusArgs{data: m.body, order: m.order}
+}
+
+// dbusSystemBusAddress returns $DBUS_SYSTEM_BUS_ADDRESS, or the well-known
+// address of the system bus if it is not set.
+func dbusSystemBusAddress() string {
+	if address := os.Getenv("DBUS_SYSTEM_BUS_ADDRESS"); address != "" {
+		return address
+	}
+	return dbusSystemBusDefaultAddress
+}
+
+// dbusSocketPath returns the unix socket path of the first usable address in
+// a server address list like "unix:path=/run/dbus/system_bus_socket".
+// Abstract sockets are returned with a leading "@".
+func dbusSocketPath(address string) (string, error) {
+	for _, entry := range strings.Split(address, ";") {
+		transport, params, _ := strings.Cut(entry, ":")
+		if transport != "unix" {
+			continue
+		}
+		for _, param := range strings.Split(params, ",") {
+			key, value, _ := strings.Cut(param, "=")
+			value, err := url.PathUnescape(value)
+			if err != nil {
+				return "", fmt.Errorf("Invalid D-Bus address %q", address)
+			}
+			switch key {
+			case "path":
+				return value, nil
+			case "abstract":
+				return "@" + value, nil
+			}
+		}
+	}
+	return "", fmt.Errorf("No usable D-Bus address in %q", address)
+}
+
+// dbusEncoder appends values to buf, aligned relative to the start of buf
+type dbusEncoder struct {
+	buf []byte
+}
+
+func (e *dbusEncoder) align(n int) {
+	for len(e.buf)%n != 0 {
+		e.buf = append(e.buf, 0)
+	}
+}
+
+func (e *dbusEncoder) uint32(v uint32) {
+	e.align(4)
+	e.buf = binary.LittleEndian.AppendUint32(e.buf, v)
+}
+
+func (e *dbusEncoder) string(s string) {
+	e.uint32(uint32(len(s)))
+	e.buf = append(e.buf, s...)
+	e.buf = append(e.buf, 0)
+}
+
+func (e *dbusEncoder) signature(s string) {
+	e.buf = append(e.buf, byte(len(s)))
+	e.buf = append(e.buf, s...)
+	e.buf = append(e.buf, 0)
+}
+
+// dbusSignatureOf returns the type code of a supported argument type
+func dbusSignatureOf(v any) string {
+	switch v.(type) {
+	case string:
+		return "s"
+	case dbusObjectPath:
+		return "o"
+	case uint32:
+		return "u"
+	case bool:
+		return "b"
+	}
+	panic(fmt.Sprintf("unsupported D-Bus argument type %T", v))
+}
+
+// value encodes string, dbusObjectPath, uint32 or bool
+func (e *dbusEncoder) value(v any) {
+	switch v := v.(type) {
+	case string:
+		e.string(v)
+	case dbusObjectPath:
+		e.string(string(v))
+	case uint32:
+		e.uint32(v)
+	case bool:
+		if v {
+			e.uint32(1)
+		} else {
+			e.uint32(0)
+		}
+	default:
+		panic(fmt.Sprintf("unsupported D-Bus argument type %T", v))
+	}
+}
+
+// dbusMarshal encodes a message with the given arguments. Header fields
+// that are empty are left out.
+func dbusMarshal(m dbusMessage, args ...any) []byte {
+	var body dbusEncoder
+	var signature string
+	for _, arg := range args {
+		signature += dbusSignatureOf(arg)
+		body.value(arg)
+	}
+	e := dbusEncoder{buf: []byte{'l', m.kind, 0, 1}}
+	e.uint32(uint32(len(body.buf)))
+	e.uint32(m.serial)
+	var fields dbusEncoder
+	field := func(code byte, v any) {
+		fields.align(8)
+		fields.buf = append(fields.buf, code)
+		fields.signature(dbusSignatureOf(v))
+		fields.value(v)
+	}
+	stringFields := []struct {
+		code  byte
+		value string
+	}{
+		{dbusFieldInterface, m.iface},
+		{dbusFieldMember, m.member},
+		{dbusFieldErrorName, m.errorName},
+		{dbusFieldDestination, m.destination},
+	}
+	if m.path != "" {
+		field(dbusFieldPath, dbusObjectPath(m.path))
+	}
+	for _, f := range stringFields {
+		if f.value != "" {
+			field(f.code, f.value)
+		}
+	}
+	if m.replySerial != 0 {
+		field(dbusFieldReplySerial, m.replySerial)
+	}
+	if signature != "" {
+		fields.align(8)
+		fields.buf = append(fields.buf, dbusFieldSignature)
+		fields.signature("g")
+		fields.signature(signature)
+	}
+	// the fields start at offset 16, so their alignment is the same as
+	// within the message
+	e.uint32(uint32(len(fields.buf)))
+	e.buf = append(e.buf, fields.buf...)
+	e.align(8)
+	return append(e.buf, body.buf...)
+}
+
+// dbusArgs decodes values in order. After the first error all further calls
+// return zero values and the error is kept.
+type dbusArgs struct {
+	data  []byte
+	pos   int
+	order binary.ByteOrder
+	err   error
+}
+
+func (a *dbusArgs) take(n int) []byte {
+	if a.err != nil {
+		return nil
+	}
+	if a.pos+n > len(a.data) {
+		a.err = errors.New("D-Bus message too short")
+		return nil
+	}
+	b := a.data[a.pos : a.pos+n]
+	a.pos += n
+	return b
+}
+
+func (a *dbusArgs) align(n int) {
+	if pad := (n - a.pos%n) % n; pad > 0 {
+		a.take(pad)
+	}
+}
+
+func (a *dbusArgs) Byte() byte {
+	if b := a.take(1); b != nil {
+		return b[0]
+	}
+	return 0
+}
+
+func (a *dbusArgs) Uint32() uint32 {
+	a.align(4)
+	if b := a.take(4); b != nil {
+		return a.order.Uint32(b)
+	}
+	return 0
+}
+
+func (a *dbusArgs) Bool() bool {
+	return a.Uint32() != 0
+}
+
+func (a *dbusArgs) String() string {
+	size := int(a.Uint32())
+	if b := a.take(size + 1); b != nil {
+		return string(b[:size])
+	}
+	return ""
+}
+
+func (a *dbusArgs) Signature() string {
+	size := int(a.Byte())
+	if b := a.take(size + 1); b != nil {
+		return string(b[:size])
+	}
+	return ""
+}
+
+// Variant decodes a variant holding a string, object path, signature,
+// uint32, bool or byte
+func (a *dbusArgs) Variant() any {
+	switch signature := a.Signature(); signature {
+	case "s", "o":
+		return a.String()
+	case "g":
+		return a.Signature()
+	case "u":
+		return a.Uint32()
+	case "b":
+		return a.Bool()
+	case "y":
+		return a.Byte()
+	default:
+		if a.err == nil {
+			a.err = fmt.Errorf("unsupported D-Bus variant type %q", signature)
+		}
+		return nil
+	}
+}
+
+// dbusUnmarshal decodes a complete message as read by dbusConn.next
+func dbusUnmarshal(data []byte) (m dbusMessage, err error) {
+	if len(data) < dbusHeaderSize {
+		return m, errors.New("D-Bus message too short")
+	}
+	switch data[0] {
+	case 'l':
+		m.order = binary.LittleEndian
+	case 'B':
+		m.order = binary.BigEndian
+	default:
+		return m, fmt.Errorf("invalid D-Bus endianness %q", data[0])
+	}
+	m.kind = data[1]
+	a := &dbusArgs{data: data, pos: 4, order: m.order}
+	bodySize := int(a.Uint32())
+	m.serial = a.Uint32()
+	fieldsEnd := int(a.Uint32()) + a.pos
+	for a.err == nil && a.pos < fieldsEnd {
+		a.align(8)
+		code := a.Byte()
+		value := a.Variant()
+		s, _ := value.(string)
+		switch code {
+		case dbusFieldPath:
+			m.path = s
+		case dbusFieldInterface:
+			m.iface = s
+		case dbusFieldMember:
+			m.member = s
+		case dbusFieldErrorName:
+			m.errorName = s
+		case dbusFieldReplySerial:
+			m.replySerial, _ = value.(uint32)
+		case dbusFieldDestination:
+			m.destination = s
+		case dbusFieldSender:
+			m.sender = s
+		case dbusFieldSignature:
+			m.signature = s
+		}
+	}
+	a.align(8)
+	m.body = a.take(bodySize)
+	return m, a.err
+}
+
+// dbusConn is a connection to a message bus
+type dbusConn struct {
+	conn   net.Conn
+	r      *bufio.Reader
+	serial uint32
+	// name is the unique name the bus assigned to the connection
+	name string
+}
+
+// dbusDial connects to the bus at address, authenticates as the current
+// user and registers with the bus.
+func dbusDial(address string) (*dbusConn, error) {
+	path, err := dbusSocketPath(address)
+	if err != nil {
+		return nil, err
+	}
+	conn, err := net.DialTimeout("unix", path, dbusDialTimeout)
+	if err != nil {
+		return nil, err
+	}
+	c := &dbusConn{conn: conn, r: bufio.NewReader(conn)}
+	err = conn.SetDeadline(time.Now().Add(dbusDialTimeout))
+	if err == nil {
+		err = c.auth()
+	}
+	if err == nil {
+		var reply dbusMessage
+		reply, err = c.call("org.freedesktop.DBus", "/org/freedesktop/DBus", "org.freedesktop.DBus", "Hello")
+		args := reply.Args()
+		c.name = args.String()
+		err = cmp.Or(err, args.err)
+	}
+	if err == nil {
+		// signals are waited for without a deadline
+		err = conn.SetDeadline(time.Time{})
+	}
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return c, nil
+}
+
+// auth runs the EXTERNAL authentication, where the bus checks the uid sent
+// against the credentials of the socket peer.
+func (c *dbusConn) auth() error {
+	uid := fmt.Sprintf("%x", strconv.Itoa(os.Getuid()))
+	_, err := fmt.Fprintf(c.conn, "\x00AUTH EXTERNAL %s\r\n", uid)
+	if err != nil {
+		return err
+	}
+	line, err := c.r.ReadString('\n')
+	if err != nil {
+		return err
+	}
+	if !strings.HasPrefix(line, "OK ") {
+		return fmt.Errorf("D-Bus authentication failed: %s", strings.TrimSpace(line))
+	}
+	_, err = io.WriteString(c.conn, "BEGIN\r\n")
+	return err
+}
+
+func (c *dbusConn) Close() error {
+	return c.conn.Close()
+}
+
+// send writes a message with the next serial and returns that serial
+func (c *dbusConn) send(m dbusMessage, args ...any) (uint32, error) {
+	c.serial++
+	m.serial = c.serial
+	_, err := c.conn.Write(dbusMarshal(m, args...))
+	return m.serial, err
+}
+
+// next reads the next message
+func (c *dbusConn) next() (dbusMessage, error) {
+	header := make([]byte, dbusHeaderSize)
+	_, err := io.ReadFull(c.r, header)
+	if err != nil {
+		return dbusMessage{}, err
+	}
+	order := binary.ByteOrder(binary.LittleEndian)
+	if header[0] == 'B' {
+		order = binary.BigEndian
+	}
+	bodySize := int(order.Uint32(header[4:]))
+	fieldsSize := int(order.Uint32(header[12:]))
+	size := (dbusHeaderSize+fieldsSize+7)&^7 + bodySize
+	if size > dbusMaxMessageSize {
+		return dbusMessage{}, fmt.Errorf("D-Bus message too large (%d bytes)", size)
+	}
+	data := make([]byte, size)
+	copy(data, header)
+	_, err = io.ReadFull(c.r, data[dbusHeaderSize:])
+	if err != nil {
+		return dbusMessage{}, err
+	}
+	return dbusUnmarshal(data)
+}
+
+// call calls a method and waits for the reply. Other messages arriving in
+// the meantime are dropped. Error replies are turned into errors.
+func (c *dbusConn) call(destination, path, iface, member string, args ...any) (dbusMessage, error) {
+	serial, err := c.send(dbusMessage{kind: dbusMethodCall, destination: destination, path: path, iface: iface, member: member}, args...)
+	if err != nil {
+		return dbusMessage{}, err
+	}
+	for {
+		m, err := c.next()
+		if err != nil {
+			return m, err
+		}
+		if m.replySerial != serial {
+			continue
+		}
+		if m.kind == dbusError {
+			msg := ""
+			if strings.HasPrefix(m.signature, "s") {
+				msg = m.Args().String()
+			}
+			return m, fmt.Errorf("%s: %s: %s", member, m.errorName, msg)
+		}
+		return m, nil
+	}
+}
+
+// addMatch asks the bus to deliver messages matching rule, e. g.
+// "type='signal',interface='org.freedesktop.login1.Manager'"
+func (c *dbusConn) addMatch(rule string) error {
+	_, err := c.call("org.freedesktop.DBus", "/org/freedesktop/DBus", "org.freedesktop.DBus", "AddMatch", rule)
+	return err
+}
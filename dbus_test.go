@@ -0,0 +1,174 @@
+package main
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// startDbusDaemon runs a private message bus in a temporary directory and
+// returns its address. The test is skipped if dbus-daemon is not installed.
+func startDbusDaemon(t *testing.T) string {
+	t.Helper()
+	daemon, err := exec.LookPath("dbus-daemon")
+	if err != nil {
+		t.Skip("dbus-daemon not installed")
+	}
+	dir := t.TempDir()
+	config := filepath.Join(dir, "bus.conf")
+	err = os.WriteFile(config, []byte(`<busconfig>
+  <type>session</type>
+  <listen>unix:path=`+filepath.Join(dir, "bus")+`</listen>
+  <auth>EXTERNAL</auth>
+  <policy context="default">
+    <allow send_destination="*" eavesdrop="true"/>
+    <allow eavesdrop="true"/>
+    <allow own="*"/>
+  </policy>
+</busconfig>
+`), 0o600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cmd := exec.Command(daemon, "--config-file", config, "--nofork", "--print-address")
+	out, err := cmd.StdoutPipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = cmd.Start()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		cmd.Process.Kill()
+		cmd.Wait()
+	})
+	address, err := bufio.NewReader(out).ReadString('\n')
+	if err != nil {
+		t.Fatal(err)
+	}
+	return strings.TrimSpace(address)
+}
+
+type DbusSocketPathTestCase struct {
+	address string
+	path    string
+	err     bool
+}
+
+func TestDbusSocketPath(t *testing.T) {
+	tests := map[string]DbusSocketPathTestCase{
+		"default":  {dbusSystemBusDefaultAddress, "/var/run/dbus/system_bus_socket", false},
+		"guid":     {"unix:path=/tmp/dbus-abc,guid=0123", "/tmp/dbus-abc", false},
+		"abstract": {"unix:abstract=/tmp/dbus-abc", "@/tmp/dbus-abc", false},
+		"escaped":  {"unix:path=/tmp/my%20bus", "/tmp/my bus", false},
+		"list":     {"tcp:host=localhost,port=1234;unix:path=/run/bus", "/run/bus", false},
+		"tcp only": {"tcp:host=localhost,port=1234", "", true},
+		"empty":    {"", "", true},
+	}
+	for label, test := range tests {
+		t.Run(label, func(t *testing.T) {
+			path, err := dbusSocketPath(test.address)
+			if (err != nil) != test.err {
+				t.Fatalf("Got error %v instead of error %v", err, test.err)
+			}
+			if path != test.path {
+				t.Errorf("Got %q instead of %q", path, test.path)
+			}
+		})
+	}
+}
+
+func TestDbusMarshal(t *testing.T) {
+	sent := dbusMessage{
+		kind:        dbusSignal,
+		serial:      7,
+		path:        logindPath,
+		iface:       logindInterface,
+		member:      logindSleep,
+		destination: ":1.42",
+	}
+	data := dbusMarshal(sent, "text", dbusObjectPath("/a/b"), uint32(12345), true)
+	m, err := dbusUnmarshal(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.kind != sent.kind || m.serial != sent.serial || m.path != sent.path || m.iface != sent.iface ||
+		m.member != sent.member || m.destination != sent.destination || m.signature != "soub" {
+		t.Errorf("Got %+v instead of %+v", m, sent)
+	}
+	args := m.Args()
+	s, path, u, b := args.String(), args.String(), args.Uint32(), args.Bool()
+	if args.err != nil {
+		t.Fatal(args.err)
+	}
+	if s != "text" || path != "/a/b" || u != 12345 || !b {
+		t.Errorf("Got arguments %q %q %d %v", s, path, u, b)
+	}
+	if args.Uint32(); args.err == nil {
+		t.Error("Reading past the end did not fail")
+	}
+}
+
+func TestDbusUnmarshalTruncated(t *testing.T) {
+	data := dbusMarshal(dbusMessage{kind: dbusMethodCall, serial: 1, member: "Hello"})
+	for _, size := range []int{0, 10, len(data) - 1} {
+		if _, err := dbusUnmarshal(data[:size]); err == nil {
+			t.Errorf("Message truncated to %d bytes did not fail", size)
+		}
+	}
+}
+
+func TestDbusCall(t *testing.T) {
+	address := startDbusDaemon(t)
+	conn, err := dbusDial(address)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	if !strings.HasPrefix(conn.name, ":") {
+		t.Errorf("Got unique name %q", conn.name)
+	}
+	reply, err := conn.call("org.freedesktop.DBus", "/org/freedesktop/DBus", "org.freedesktop.DBus", "GetNameOwner", conn.name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if owner := reply.Args().String(); owner != conn.name {
+		t.Errorf("Got owner %q instead of %q", owner, conn.name)
+	}
+	_, err = conn.call("org.freedesktop.DBus", "/org/freedesktop/DBus", "org.freedesktop.DBus", "NoSuchMethod")
+	if err == nil || !strings.Contains(err.Error(), "org.freedesktop.DBus.Error.UnknownMethod") {
+		t.Errorf("Got %v instead of UnknownMethod error", err)
+	}
+}
+
+func TestDbusDialTimeout(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "bus")
+	l, err := net.Listen("unix", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	go func() {
+		// accept, but never answer the authentication
+		conn, err := l.Accept()
+		if err == nil {
+			defer conn.Close()
+			io.Copy(io.Discard, conn)
+		}
+	}()
+	start := time.Now()
+	_, err = dbusDial("unix:path=" + path)
+	if err == nil {
+		t.Fatal("Dial to a silent bus did not fail")
+	}
+	if elapsed := time.Since(start); elapsed > dbusDialTimeout+time.Second {
+		t.Errorf("Got timeout after %v instead of %v", elapsed, dbusDialTimeout)
+	}
+}
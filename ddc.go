@@ -0,0 +1,481 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"math"
+	"os"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// This is a minimal implementation of DDC/CI, just enough to get and set
+// VCP features of monitors over the I2C bus of their video connector. See
+// the VESA DDC/CI and MCCS standards.
+
+const (
+	drmClassDir = "/sys/class/drm"
+	// ddcAddress is the I2C slave address monitors answer DDC/CI on
+	ddcAddress = 0x37
+	// i2cSlave is the ioctl setting the slave address of /dev/i2c-N
+	i2cSlave = 0x0703
+	// ddcHostAddress is the source address of requests, ddcReplyAddress is
+	// the one checksums of replies start with
+	ddcHostAddress  = 0x51
+	ddcReplyAddress = 0x50
+	// ddcDisplayAddress is the destination address of requests, which is
+	// ddcAddress shifted for writing
+	ddcDisplayAddress = ddcAddress << 1
+	ddcGetVCP         = 0x01
+	ddcGetVCPReply    = 0x02
+	ddcSetVCP         = 0x03
+	// vcpBrightness and vcpContrast are the VCP feature codes for
+	// luminance and contrast
+	vcpBrightness = 0x10
+	vcpContrast   = 0x12
+	// ddcReplyDelay is the time monitors need before a reply can be read
+	ddcReplyDelay      = 40 * time.Millisecond
+	DefaultDDCMin      = 30
+	DefaultDDCMax      = 100
+	DefaultDDCInterval = time.Minute
+)
+
+// ddcInternalConnectors are connector types of built-in panels, which are
+// dimmed with -backlight instead
+var ddcInternalConnectors = []string{"eDP", "LVDS", "DSI"}
+
+func validateDDCPercent(value string) error {
+	percent, err := strconv.Atoi(value)
+	if err != nil {
+		return err
+	}
+	return isBetween(percent, 0, 100)
+}
+
+// DDCMonitorConfig holds the settings for one monitor from a [[monitor]]
+// section of the config file. Device is the I2C device or the connector,
+// e. g. "/dev/i2c-4" or "DP-1". The contrast is not changed if ContrastMax
+// is 0.
+type DDCMonitorConfig struct {
+	Device      string
+	Min         int
+	Max         int
+	ContrastMin int
+	ContrastMax int
+}
+
+// DDCMonitorsFromConfig reads the [[monitor]] sections of the config file,
+// e. g.
+//
+//	[[monitor]]
+//	device = "DP-1"
+//	min = 10
+//	max = 80
+//	contrastMin = 40
+//	contrastMax = 70
+//
+// All but device are optional and default to the values of defaults.
+func DDCMonitorsFromConfig(doc *tomlDoc, defaults DDCMonitorConfig) ([]DDCMonitorConfig, error) {
+	var monitors []DDCMonitorConfig
+	for _, t := range doc.Tables("monitor") {
+		m := defaults
+		for _, v := range t.values {
+			fail := func(err error) ([]DDCMonitorConfig, error) {
+				return nil, &ConfigError{doc.file, v.line, v.key, err}
+			}
+			switch v.key {
+			case "device":
+				s, ok := v.value.(string)
+				if !ok {
+					return fail(errors.New("needs to be a string"))
+				}
+				m.Device = s
+			case "min", "max", "contrastMin", "contrastMax":
+				i, ok := v.value.(int64)
+				if !ok {
+					return fail(errors.New("needs to be an integer"))
+				}
+				if err := isBetween(int(i), 0, 100); err != nil {
+					return fail(err)
+				}
+				switch v.key {
+				case "min":
+					m.Min = int(i)
+				case "max":
+					m.Max = int(i)
+				case "contrastMin":
+					m.ContrastMin = int(i)
+				case "contrastMax":
+					m.ContrastMax = int(i)
+				}
+			default:
+				return fail(errors.New("unknown setting"))
+			}
+		}
+		if m.Device == "" {
+			return nil, &ConfigError{doc.file, t.line, "", errors.New("[[monitor]] needs device")}
+		}
+		if m.Min > m.Max {
+			return nil, &ConfigError{doc.file, t.line, "", fmt.Errorf("[[monitor]] min (%d) is higher than max (%d)", m.Min, m.Max)}
+		}
+		if m.ContrastMin > m.ContrastMax {
+			return nil, &ConfigError{doc.file, t.line, "", fmt.Errorf("[[monitor]] contrastMin (%d) is higher than contrastMax (%d)", m.ContrastMin, m.ContrastMax)}
+		}
+		monitors = append(monitors, m)
+	}
+	return monitors, nil
+}
+
+// ddcBus is the I2C bus of a video connector
+type ddcBus struct {
+	// connector is the name of the connector without the card, e. g. "DP-1"
+	connector string
+	path      string
+}
+
+// FindDDCBuses returns the I2C buses of the connected external monitors,
+// found in drmDir (usually /sys/class/drm).
+func FindDDCBuses(drmDir string) ([]ddcBus, error) {
+	entries, err := os.ReadDir(drmDir)
+	if err != nil {
+		return nil, err
+	}
+	var buses []ddcBus
+	for _, e := range entries {
+		// connectors are called like card1-DP-1
+		_, connector, ok := strings.Cut(e.Name(), "-")
+		if !ok || !strings.HasPrefix(e.Name(), "card") {
+			continue
+		}
+		kind, _, _ := strings.Cut(connector, "-")
+		if slices.Contains(ddcInternalConnectors, kind) {
+			continue
+		}
+		status, _ := os.ReadFile(filepath.Join(drmDir, e.Name(), "status"))
+		if strings.TrimSpace(string(status)) != "connected" {
+			continue
+		}
+		link, err := os.Readlink(filepath.Join(drmDir, e.Name(), "ddc"))
+		if err != nil {
+			continue
+		}
+		buses = append(buses, ddcBus{connector, filepath.Join("/dev", filepath.Base(link))})
+	}
+	return buses, nil
+}
+
+// openI2C opens an I2C device for talking to the monitor on it
+func openI2C(path string) (io.ReadWriteCloser, error) {
+	f, err := os.OpenFile(path, os.O_RDWR, 0)
+	if err != nil {
+		return nil, err
+	}
+	_, _, errno := syscall.Syscall(syscall.SYS_IOCTL, f.Fd(), i2cSlave, ddcAddress)
+	if errno != 0 {
+		f.Close()
+		return nil, fmt.Errorf("Setting I2C address on %s: %w", path, errno)
+	}
+	return f, nil
+}
+
+// ddcChecksum returns the XOR of start and all bytes in b
+func ddcChecksum(start byte, b []byte) byte {
+	for _, c := range b {
+		start ^= c
+	}
+	return start
+}
+
+// ddcRequest builds a request with the given payload
+func ddcRequest(payload ...byte) []byte {
+	b := append([]byte{ddcHostAddress, 0x80 | byte(len(payload))}, payload...)
+	return append(b, ddcChecksum(ddcDisplayAddress, b))
+}
+
+// ddcSetVCPFeature sets a VCP feature of the monitor
+func ddcSetVCPFeature(rw io.ReadWriter, code byte, value uint16) error {
+	_, err := rw.Write(ddcRequest(ddcSetVCP, code, byte(value>>8), byte(value)))
+	return err
+}
+
+// ddcGetVCPFeature returns the current and maximum value of a VCP feature.
+// It waits delay for the monitor to prepare the reply.
+func ddcGetVCPFeature(rw io.ReadWriter, code byte, delay time.Duration) (current, maximum uint16, err error) {
+	if _, err := rw.Write(ddcRequest(ddcGetVCP, code)); err != nil {
+		return 0, 0, err
+	}
+	time.Sleep(delay)
+	reply := make([]byte, 11)
+	if _, err := io.ReadFull(rw, reply); err != nil {
+		return 0, 0, err
+	}
+	switch {
+	case ddcChecksum(ddcReplyAddress, reply[:10]) != reply[10]:
+		return 0, 0, errors.New("DDC reply has wrong checksum")
+	case reply[1] != 0x88 || reply[2] != ddcGetVCPReply || reply[4] != code:
+		return 0, 0, fmt.Errorf("Unexpected DDC reply % x", reply)
+	case reply[3] != 0:
+		return 0, 0, fmt.Errorf("VCP feature %#02x is not supported", code)
+	}
+	return uint16(reply[8])<<8 | uint16(reply[9]), uint16(reply[6])<<8 | uint16(reply[7]), nil
+}
+
+// ddcFeature is a VCP feature of a monitor that follows the brightness
+// level, between min and max percent of maxValue
+type ddcFeature struct {
+	name     string
+	code     byte
+	min      int
+	max      int
+	maxValue int
+	// last is the value last written or read from the monitor
+	last int
+}
+
+// ddcMonitor is one monitor controlled by DDC
+type ddcMonitor struct {
+	name     string
+	path     string
+	features []*ddcFeature
+	written  time.Time
+	// pending tells that a change was held back by rate limiting
+	pending bool
+}
+
+// DDC sets the brightness and optionally the contrast of external monitors
+// over DDC/CI according to the brightness level, between their min and max
+// percentage. Writing is slow and wears the EEPROM of some monitors, so
+// each monitor is changed at most once per interval and only if a value
+// changes.
+type DDC struct {
+	mu       sync.Mutex
+	open     func(path string) (io.ReadWriteCloser, error)
+	delay    time.Duration
+	interval time.Duration
+	monitors []*ddcMonitor
+}
+
+// DDCSettings are the flags of the monitors controlled over DDC/CI and
+// their [[monitor]] sections from the config file
+type DDCSettings struct {
+	// Devices is "auto", a list of I2C devices or connectors, or "" for
+	// none
+	Devices string
+	Min     int
+	Max     int
+	// ContrastMin and ContrastMax are the contrast range, the contrast is
+	// not changed if ContrastMax is 0
+	ContrastMin int
+	ContrastMax int
+	Interval    time.Duration
+	Monitors    []DDCMonitorConfig
+}
+
+// Equal tells whether s and o are the same settings. Because of Monitors,
+// DDCSettings can not be compared with ==.
+func (s DDCSettings) Equal(o DDCSettings) bool {
+	return reflect.DeepEqual(s, o)
+}
+
+// Defaults returns the settings for monitors without [[monitor]] section
+func (s DDCSettings) Defaults() DDCMonitorConfig {
+	return DDCMonitorConfig{Min: s.Min, Max: s.Max, ContrastMin: s.ContrastMin, ContrastMax: s.ContrastMax}
+}
+
+// NewDDC returns the monitors selected in s, or nil if DDC is not used.
+func NewDDC(s DDCSettings) (*DDC, error) {
+	return OpenDDC(s, drmClassDir, openI2C, ddcReplyDelay)
+}
+
+// OpenDDC is NewDDC looking for connectors in drmDir and opening I2C
+// devices with open. delay is the time to wait for replies of monitors.
+// Monitors that do not answer are left out with a warning.
+func OpenDDC(s DDCSettings, drmDir string, open func(string) (io.ReadWriteCloser, error), delay time.Duration) (*DDC, error) {
+	if s.Devices == "" {
+		return nil, nil
+	}
+	buses, err := FindDDCBuses(drmDir)
+	if err != nil && s.Devices == "auto" {
+		return nil, err
+	}
+	var selected []ddcBus
+	if s.Devices == "auto" {
+		selected = buses
+	} else {
+		for _, device := range strings.Split(s.Devices, ",") {
+			device = strings.TrimSpace(device)
+			i := slices.IndexFunc(buses, func(b ddcBus) bool { return b.connector == device || b.path == device })
+			switch {
+			case i >= 0:
+				selected = append(selected, buses[i])
+			case strings.HasPrefix(device, "/"):
+				selected = append(selected, ddcBus{path: device})
+			default:
+				return nil, fmt.Errorf("No connected monitor %s", device)
+			}
+		}
+	}
+	d := &DDC{open: open, delay: delay, interval: s.Interval}
+	for _, bus := range selected {
+		m := &ddcMonitor{name: bus.path, path: bus.path}
+		if bus.connector != "" {
+			m.name = bus.connector
+		}
+		c := s.Defaults()
+		for _, mc := range s.Monitors {
+			if mc.Device == bus.connector || mc.Device == bus.path {
+				c = mc
+			}
+		}
+		brightness, err := d.feature(m, "brightness", vcpBrightness, c.Min, c.Max)
+		if err != nil {
+			slog.Warn("monitor does not support DDC/CI brightness", "monitor", m.name, "error", err)
+			continue
+		}
+		m.features = append(m.features, brightness)
+		if c.ContrastMax > 0 {
+			contrast, err := d.feature(m, "contrast", vcpContrast, c.ContrastMin, c.ContrastMax)
+			if err != nil {
+				slog.Warn("monitor does not support DDC/CI contrast", "monitor", m.name, "error", err)
+			} else {
+				m.features = append(m.features, contrast)
+			}
+		}
+		d.monitors = append(d.monitors, m)
+	}
+	if len(d.monitors) == 0 {
+		return nil, errors.New("No monitor supports DDC/CI brightness")
+	}
+	return d, nil
+}
+
+// feature reads the current and maximum value of a VCP feature of the
+// monitor
+func (d *DDC) feature(m *ddcMonitor, name string, code byte, min, max int) (*ddcFeature, error) {
+	current, maximum, err := d.get(m, code)
+	if err != nil {
+		return nil, err
+	}
+	if maximum == 0 {
+		return nil, fmt.Errorf("No %s levels", name)
+	}
+	slog.Debug("DDC monitor", "monitor", m.name, "path", m.path, name, current, "max", maximum)
+	return &ddcFeature{name: name, code: code, min: min, max: max, maxValue: int(maximum), last: int(current)}, nil
+}
+
+func (d *DDC) get(m *ddcMonitor, code byte) (current, maximum uint16, err error) {
+	rw, err := d.open(m.path)
+	if err != nil {
+		return 0, 0, err
+	}
+	defer rw.Close()
+	return ddcGetVCPFeature(rw, code, d.delay)
+}
+
+func (d *DDC) set(m *ddcMonitor, code byte, value int) error {
+	rw, err := d.open(m.path)
+	if err != nil {
+		return err
+	}
+	defer rw.Close()
+	return ddcSetVCPFeature(rw, code, uint16(value))
+}
+
+// Value returns the VCP value of the feature for the brightness level
+func (f *ddcFeature) Value(brightness float64) int {
+	percent := ScaleBrightness(brightness, f.min, f.max)
+	return int(math.Round(float64(percent) * float64(f.maxValue) / 100))
+}
+
+// changed tells whether any feature of the monitor needs a different value
+// for the brightness level
+func (m *ddcMonitor) changed(brightness float64) bool {
+	return slices.ContainsFunc(m.features, func(f *ddcFeature) bool {
+		return f.Value(brightness) != f.last
+	})
+}
+
+// Update sets the monitors for the brightness level. Changes to monitors
+// written less than the interval ago are held back, see NextWrite.
+// It is safe to call on a nil DDC.
+func (d *DDC) Update(brightness float64, now time.Time) error {
+	if d == nil {
+		return nil
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	var errs []error
+	for _, m := range d.monitors {
+		if !m.changed(brightness) {
+			m.pending = false
+			continue
+		}
+		if !m.written.IsZero() && now.Sub(m.written) < d.interval {
+			m.pending = true
+			continue
+		}
+		m.pending = false
+		// count failed attempts too, not to hammer a monitor that does
+		// not listen
+		m.written = now
+		for _, f := range m.features {
+			value := f.Value(brightness)
+			if value == f.last {
+				continue
+			}
+			slog.Debug("setting monitor "+f.name, "monitor", m.name, "value", value, "max", f.maxValue)
+			if err := d.set(m, f.code, value); err != nil {
+				errs = append(errs, fmt.Errorf("%s: %w", m.name, err))
+				continue
+			}
+			f.last = value
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// NextWrite returns when changes held back by Update can be written, or
+// the zero time if there are none.
+// It is safe to call on a nil DDC.
+func (d *DDC) NextWrite() time.Time {
+	if d == nil {
+		return time.Time{}
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	var next time.Time
+	for _, m := range d.monitors {
+		if !m.pending {
+			continue
+		}
+		if t := m.written.Add(d.interval); next.IsZero() || t.Before(next) {
+			next = t
+		}
+	}
+	return next
+}
+
+// SetDDC updates the monitors for the given time, if there are any. If
+// ambient is not nil, the brightness is blended with the ambient light.
+func SetDDC(cflags Config, ddc *DDC, ambient *AmbientLight, when time.Time) {
+	if ddc == nil {
+		return
+	}
+	brightness, _, err := BacklightLevel(cflags, when)
+	if err != nil {
+		slog.Warn("error getting monitor brightness level", "err", err)
+		return
+	}
+	err = ddc.Update(ambient.Blend(brightness), when)
+	if err != nil {
+		slog.Warn("error setting monitor brightness", "err", err)
+	}
+}
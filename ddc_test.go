@@ -0,0 +1,361 @@
+package main
+
+import (
+	"bytes"
+	"cmp"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// fakeDDCMonitor answers DDC/CI requests like a monitor on an I2C bus
+type fakeDDCMonitor struct {
+	brightness uint16
+	contrast   uint16
+	max        uint16
+	// writes counts the changes of brightness and contrast
+	writes int
+	reply  bytes.Buffer
+	broken bool
+	// noContrast makes the monitor not support the contrast feature
+	noContrast bool
+}
+
+func (m *fakeDDCMonitor) Write(p []byte) (int, error) {
+	if len(p) < 3 || p[0] != ddcHostAddress || int(p[1]&0x7f) != len(p)-3 || ddcChecksum(ddcDisplayAddress, p[:len(p)-1]) != p[len(p)-1] {
+		return 0, errors.New("garbled request")
+	}
+	switch payload := p[2 : len(p)-1]; payload[0] {
+	case ddcGetVCP:
+		result, value := byte(0), m.brightness
+		switch {
+		case payload[1] == vcpContrast && !m.noContrast:
+			value = m.contrast
+		case payload[1] != vcpBrightness:
+			result = 1
+		}
+		reply := []byte{ddcDisplayAddress, 0x88, ddcGetVCPReply, result, payload[1], 0,
+			byte(m.max >> 8), byte(m.max), byte(value >> 8), byte(value)}
+		reply = append(reply, ddcChecksum(ddcReplyAddress, reply))
+		if m.broken {
+			reply[9]++
+		}
+		m.reply.Reset()
+		m.reply.Write(reply)
+	case ddcSetVCP:
+		value := uint16(payload[2])<<8 | uint16(payload[3])
+		switch payload[1] {
+		case vcpBrightness:
+			m.brightness = value
+		case vcpContrast:
+			m.contrast = value
+		}
+		m.writes++
+	}
+	return len(p), nil
+}
+
+func (m *fakeDDCMonitor) Read(p []byte) (int, error) {
+	return m.reply.Read(p)
+}
+
+func (m *fakeDDCMonitor) Close() error {
+	return nil
+}
+
+// fakeI2C returns an open function for the monitors by path
+func fakeI2C(monitors map[string]*fakeDDCMonitor) func(string) (io.ReadWriteCloser, error) {
+	return func(path string) (io.ReadWriteCloser, error) {
+		m, ok := monitors[path]
+		if !ok {
+			return nil, os.ErrNotExist
+		}
+		return m, nil
+	}
+}
+
+// fakeConnector creates a connector in drmDir like the kernel does in
+// /sys/class/drm, with the I2C adapter named i2c
+func fakeConnector(t *testing.T, drmDir, name, status, i2c string) {
+	t.Helper()
+	dir := filepath.Join(drmDir, name)
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "status"), []byte(status+"\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if i2c == "" {
+		return
+	}
+	if err := os.Symlink(filepath.Join("..", "..", i2c), filepath.Join(dir, "ddc")); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestDDCGetVCPFeature(t *testing.T) {
+	m := &fakeDDCMonitor{brightness: 70, max: 100}
+	current, maximum, err := ddcGetVCPFeature(m, vcpBrightness, 0)
+	if current != 70 || maximum != 100 || err != nil {
+		t.Errorf("Got %d/%d, %v instead of 70/100", current, maximum, err)
+	}
+	m.noContrast = true
+	if _, _, err := ddcGetVCPFeature(m, vcpContrast, 0); err == nil {
+		t.Error("Expected error for unsupported feature")
+	}
+	m.broken = true
+	if _, _, err := ddcGetVCPFeature(m, vcpBrightness, 0); err == nil {
+		t.Error("Expected error for wrong checksum")
+	}
+}
+
+func TestDDCSetVCPFeature(t *testing.T) {
+	m := &fakeDDCMonitor{brightness: 70, max: 1000}
+	if err := ddcSetVCPFeature(m, vcpBrightness, 300); err != nil {
+		t.Fatal(err)
+	}
+	if m.brightness != 300 {
+		t.Errorf("Got %d instead of 300", m.brightness)
+	}
+}
+
+func TestFindDDCBuses(t *testing.T) {
+	drmDir := t.TempDir()
+	fakeConnector(t, drmDir, "card1-eDP-1", "connected", "i2c-2")
+	fakeConnector(t, drmDir, "card1-DP-1", "connected", "i2c-4")
+	fakeConnector(t, drmDir, "card1-DP-2", "disconnected", "i2c-5")
+	fakeConnector(t, drmDir, "card1-HDMI-A-1", "connected", "i2c-6")
+	fakeConnector(t, drmDir, "card1-DP-3", "connected", "")
+	buses, err := FindDDCBuses(drmDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []ddcBus{{"DP-1", "/dev/i2c-4"}, {"HDMI-A-1", "/dev/i2c-6"}}
+	if len(buses) != len(expected) || buses[0] != expected[0] || buses[1] != expected[1] {
+		t.Errorf("Got %v instead of %v", buses, expected)
+	}
+}
+
+func openTestDDC(t *testing.T, s DDCSettings) (*DDC, map[string]*fakeDDCMonitor) {
+	t.Helper()
+	drmDir := t.TempDir()
+	fakeConnector(t, drmDir, "card1-DP-1", "connected", "i2c-4")
+	fakeConnector(t, drmDir, "card1-HDMI-A-1", "connected", "i2c-6")
+	monitors := map[string]*fakeDDCMonitor{
+		"/dev/i2c-4": {brightness: 100, contrast: 50, max: 100},
+		"/dev/i2c-6": {brightness: 50, max: 255, noContrast: true},
+	}
+	s.Min = cmp.Or(s.Min, DefaultDDCMin)
+	s.Max = cmp.Or(s.Max, DefaultDDCMax)
+	d, err := OpenDDC(s, drmDir, fakeI2C(monitors), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return d, monitors
+}
+
+func TestOpenDDC(t *testing.T) {
+	if d, err := OpenDDC(DDCSettings{}, t.TempDir(), fakeI2C(nil), 0); d != nil || err != nil {
+		t.Errorf("Got %v, %v without -ddc", d, err)
+	}
+	if _, err := OpenDDC(DDCSettings{Devices: "auto"}, t.TempDir(), fakeI2C(nil), 0); err == nil {
+		t.Error("Expected error without monitors")
+	}
+	if _, err := OpenDDC(DDCSettings{Devices: "DP-9"}, t.TempDir(), fakeI2C(nil), 0); err == nil {
+		t.Error("Expected error for unknown connector")
+	}
+	d, _ := openTestDDC(t, DDCSettings{Devices: "auto"})
+	if len(d.monitors) != 2 || d.monitors[0].name != "DP-1" || d.monitors[1].features[0].maxValue != 255 {
+		t.Errorf("Got %v instead of DP-1 and HDMI-A-1", d.monitors)
+	}
+	d, _ = openTestDDC(t, DDCSettings{Devices: "/dev/i2c-6, DP-1"})
+	if len(d.monitors) != 2 || d.monitors[0].name != "HDMI-A-1" || d.monitors[1].name != "DP-1" {
+		t.Errorf("Got %v instead of HDMI-A-1 and DP-1", d.monitors)
+	}
+}
+
+func TestDDCUpdate(t *testing.T) {
+	s := DDCSettings{
+		Devices:  "auto",
+		Min:      20,
+		Max:      100,
+		Interval: time.Minute,
+		Monitors: []DDCMonitorConfig{{Device: "HDMI-A-1", Min: 0, Max: 80}},
+	}
+	d, monitors := openTestDDC(t, s)
+	dp, hdmi := monitors["/dev/i2c-4"], monitors["/dev/i2c-6"]
+	start := time.Date(2025, time.April, 15, 20, 0, 0, 0, time.Local)
+
+	if err := d.Update(0.5, start); err != nil {
+		t.Fatal(err)
+	}
+	if dp.brightness != 60 || hdmi.brightness != 102 {
+		t.Errorf("Got %d/%d instead of 60/102", dp.brightness, hdmi.brightness)
+	}
+	if next := d.NextWrite(); !next.IsZero() {
+		t.Errorf("Got %s instead of no pending write", next)
+	}
+
+	// changes within the interval are held back
+	d.Update(0.4, start.Add(30*time.Second))
+	if dp.brightness != 60 || dp.writes != 1 {
+		t.Errorf("Got %d after %d writes instead of 60 after 1", dp.brightness, dp.writes)
+	}
+	if next := d.NextWrite(); !next.Equal(start.Add(time.Minute)) {
+		t.Errorf("Got %s instead of %s", next, start.Add(time.Minute))
+	}
+	d.Update(0.0, start.Add(time.Minute))
+	if dp.brightness != 20 || hdmi.brightness != 0 {
+		t.Errorf("Got %d/%d instead of 20/0", dp.brightness, hdmi.brightness)
+	}
+
+	// unchanged values are not written again
+	d.Update(0.0, start.Add(time.Hour))
+	if dp.writes != 2 || hdmi.writes != 2 {
+		t.Errorf("Got %d/%d writes instead of 2/2", dp.writes, hdmi.writes)
+	}
+	if next := d.NextWrite(); !next.IsZero() {
+		t.Errorf("Got %s instead of no pending write", next)
+	}
+
+	// a nil DDC does nothing
+	d = nil
+	if err := d.Update(1.0, start); err != nil || !d.NextWrite().IsZero() {
+		t.Errorf("Got error %v", err)
+	}
+}
+
+func TestDDCUpdateContrast(t *testing.T) {
+	s := DDCSettings{
+		Devices:     "auto",
+		Min:         20,
+		Max:         100,
+		ContrastMin: 40,
+		ContrastMax: 80,
+		Interval:    time.Minute,
+	}
+	d, monitors := openTestDDC(t, s)
+	dp, hdmi := monitors["/dev/i2c-4"], monitors["/dev/i2c-6"]
+	if len(d.monitors[0].features) != 2 || len(d.monitors[1].features) != 1 {
+		t.Errorf("Got %d/%d features instead of 2/1", len(d.monitors[0].features), len(d.monitors[1].features))
+	}
+	start := time.Date(2025, time.April, 15, 20, 0, 0, 0, time.Local)
+
+	d.Update(0.5, start)
+	if dp.brightness != 60 || dp.contrast != 60 || hdmi.brightness != 153 {
+		t.Errorf("Got %d/%d/%d instead of 60/60/153", dp.brightness, dp.contrast, hdmi.brightness)
+	}
+
+	// both features of a monitor are rate limited together
+	d.Update(0.0, start.Add(30*time.Second))
+	if dp.contrast != 60 || dp.writes != 2 {
+		t.Errorf("Got %d after %d writes instead of 60 after 2", dp.contrast, dp.writes)
+	}
+	d.Update(0.0, start.Add(time.Minute))
+	if dp.brightness != 20 || dp.contrast != 40 {
+		t.Errorf("Got %d/%d instead of 20/40", dp.brightness, dp.contrast)
+	}
+
+	// without -ddcContrastMax the contrast is left alone
+	d, monitors = openTestDDC(t, DDCSettings{Devices: "auto", Interval: time.Minute})
+	d.Update(0.0, start)
+	if dp := monitors["/dev/i2c-4"]; dp.contrast != 50 {
+		t.Errorf("Got contrast %d instead of unchanged 50", dp.contrast)
+	}
+}
+
+func TestDDCMonitorsFromConfig(t *testing.T) {
+	writeConfig(t, `
+ddcMin = 10
+ddcContrastMax = 60
+
+[[monitor]]
+device = "DP-1"
+max = 80
+contrastMin = 30
+
+[[monitor]]
+device = "/dev/i2c-6"
+min = 0
+`)
+	c, _, err := GetFlags("foo", []string{})
+	if err != nil {
+		t.Fatalf("Got error %v", err)
+	}
+	expected := []DDCMonitorConfig{{"DP-1", 10, 80, 30, 60}, {"/dev/i2c-6", 0, DefaultDDCMax, 0, 60}}
+	if len(c.DDC.Monitors) != len(expected) || c.DDC.Monitors[0] != expected[0] || c.DDC.Monitors[1] != expected[1] {
+		t.Errorf("Got %v instead of %v", c.DDC.Monitors, expected)
+	}
+}
+
+func TestDDCMonitorsFromConfigErrors(t *testing.T) {
+	tests := map[string]ConfigFileErrorTestCase{
+		"no device": {
+			"[[monitor]]\nmin = 10",
+			"config.toml:1: [[monitor]] needs device",
+		},
+		"out of range": {
+			"[[monitor]]\ndevice = \"DP-1\"\nmax = 120",
+			"config.toml:3: max: Value (120) must be >=0 and <=100",
+		},
+		"min above max": {
+			"[[monitor]]\ndevice = \"DP-1\"\nmin = 90\nmax = 80",
+			"config.toml:1: [[monitor]] min (90) is higher than max (80)",
+		},
+		"contrast min above max": {
+			"[[monitor]]\ndevice = \"DP-1\"\ncontrastMin = 60\ncontrastMax = 50",
+			"config.toml:1: [[monitor]] contrastMin (60) is higher than contrastMax (50)",
+		},
+		"unknown key": {
+			"[[monitor]]\ndevice = \"DP-1\"\ncontrast = 50",
+			"config.toml:3: contrast: unknown setting",
+		},
+	}
+	for label, test := range tests {
+		t.Run(label, func(t *testing.T) {
+			path := writeConfig(t, test.content)
+			_, _, err := GetFlags("foo", []string{})
+			if err == nil || err.Error() != filepath.Dir(path)+"/"+test.expected {
+				t.Errorf("Got error %v instead of %s", err, test.expected)
+			}
+		})
+	}
+}
+
+func TestDDCSettingsEqual(t *testing.T) {
+	old := DDCSettings{Devices: "auto", Min: 30, Max: 100, Monitors: []DDCMonitorConfig{{"DP-1", 10, 80, 0, 0}}}
+	updated := old
+	updated.Monitors = []DDCMonitorConfig{{"DP-1", 10, 80, 0, 0}}
+	if !old.Equal(updated) {
+		t.Error("Got change for the same settings")
+	}
+	updated.Monitors = []DDCMonitorConfig{{"DP-1", 20, 80, 0, 0}}
+	if old.Equal(updated) {
+		t.Error("Got no change for a different [[monitor]]")
+	}
+	updated = old
+	updated.ContrastMax = 80
+	if old.Equal(updated) {
+		t.Error("Got no change for a different contrast")
+	}
+}
+
+func TestDDCFlags(t *testing.T) {
+	t.Setenv("XDG_CONFIG_HOME", t.TempDir())
+	tests := map[string][]string{
+		"percent":  {"-ddcMax", "101"},
+		"min":      {"-ddcMin", "90", "-ddcMax", "80"},
+		"interval": {"-ddcInterval", "0s"},
+		"contrast": {"-ddcContrastMin", "50"},
+	}
+	for label, args := range tests {
+		t.Run(label, func(t *testing.T) {
+			if _, _, err := GetFlags("foo", args); err == nil {
+				t.Errorf("Expected error for %v", args)
+			}
+		})
+	}
+}
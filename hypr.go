@@ -2,9 +2,13 @@ package main
 
 import (
 	"bytes"
+	"errors"
 	"fmt"
 	"log/slog"
 	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
 	"time"
 )
 
@@ -27,41 +31,137 @@ func Shellout(command string) (string, string, error) {
 func Hyprctl(cmd, subcmd string, val int) error {
 	// Unfortunately hyprctl will not write an error to stderr nor return != 0 if
 	// supplied with wrong arguments. In the hope this will change we still
-	// check properly.
+	// check properly, but also check the reply like HyprsunsetSocket does.
 	slog.Debug("running hyprctl", subcmd, val)
 	stdout, stderr, err := Shellout(fmt.Sprintf("%s hyprsunset %s %d", cmd, subcmd, val))
 	if stderr != "" {
 		slog.Warn("hyprctl", "subcmd", subcmd, "stderr", stderr)
 	}
 	slog.Debug("hyprctl", "subcmd", subcmd, "stdout", stdout)
-	return err
+	if err != nil {
+		return err
+	}
+	if reply := strings.TrimSpace(stdout); reply != "ok" {
+		return fmt.Errorf("hyprsunset %s %d: %s", subcmd, val, reply)
+	}
+	return nil
+}
+
+// hyprsunsetTarget decides how to reach hyprsunset. If a hyprctl command was
+// configured it is used, otherwise the hyprsunset socket is contacted
+// directly. If the socket location cannot be determined, the default hyprctl
+// command is used as a fallback.
+// Exactly one of the returned values is non-empty.
+func hyprsunsetTarget(cflags Config) (hyprctl, socket string) {
+	if cflags.HyprctlCmd != "" {
+		return cflags.HyprctlCmd, ""
+	}
+	dir, err := HyprSocketDir()
+	if err != nil {
+		slog.Debug("falling back to hyprctl", "reason", err)
+		return HyprctlCmd, ""
+	}
+	return "", filepath.Join(dir, hyprsunsetSocketName)
+}
+
+// Hyprsunset sets either temperature or gamma in a running Hyprland session.
+func Hyprsunset(cflags Config, subcmd string, val int) error {
+	hyprctl, socket := hyprsunsetTarget(cflags)
+	if hyprctl != "" {
+		return Hyprctl(hyprctl, subcmd, val)
+	}
+	return HyprsunsetSocket(socket, subcmd, val)
+}
+
+// GetHyprsunset queries a running Hyprland session for either the current
+// temperature or gamma.
+func GetHyprsunset(cflags Config, subcmd string) (int, error) {
+	var reply string
+	var err error
+	hyprctl, socket := hyprsunsetTarget(cflags)
+	if hyprctl != "" {
+		reply, _, err = Shellout(fmt.Sprintf("%s hyprsunset %s", hyprctl, subcmd))
+	} else {
+		reply, err = HyprRequest(socket, subcmd)
+	}
+	if err != nil {
+		return 0, err
+	}
+	val, err := strconv.Atoi(strings.TrimSpace(reply))
+	if err != nil {
+		return 0, fmt.Errorf("hyprsunset %s: unexpected reply %q", subcmd, reply)
+	}
+	return val, nil
 }
 
 // SetHyprsunset contacts a running Hyprland session to set temperature
 func SetHyprsunsetTemperature(cflags Config, temperature int) error {
-	return Hyprctl(cflags.HyprctlCmd, "temperature", temperature)
+	return Hyprsunset(cflags, "temperature", temperature)
 }
 
 // SetHyprsunset contacts a running Hyprland session to set gamma
 func SetHyprsunsetGamma(cflags Config, gamma int) error {
-	return Hyprctl(cflags.HyprctlCmd, "gamma", gamma)
+	return Hyprsunset(cflags, "gamma", gamma)
+}
+
+// HyprsunsetOutput is the output backend for hyprsunset
+type HyprsunsetOutput struct {
+	cflags Config
+}
+
+func NewHyprsunsetOutput(cflags Config) (Output, error) {
+	return &HyprsunsetOutput{cflags}, nil
+}
+
+func (o *HyprsunsetOutput) Name() string {
+	return "hyprsunset"
+}
+
+func (o *HyprsunsetOutput) Apply(temperature, gamma int) error {
+	var errs []error
+	if err := SetHyprsunsetTemperature(o.cflags, temperature); err != nil {
+		errs = append(errs, fmt.Errorf("setting temperature: %w", err))
+	}
+	if err := SetHyprsunsetGamma(o.cflags, gamma); err != nil {
+		errs = append(errs, fmt.Errorf("setting gamma: %w", err))
+	}
+	return errors.Join(errs...)
+}
+
+func (o *HyprsunsetOutput) Current() (temperature, gamma int, err error) {
+	temperature, err = GetHyprsunset(o.cflags, "temperature")
+	if err != nil {
+		return
+	}
+	gamma, err = GetHyprsunset(o.cflags, "gamma")
+	return
+}
+
+func (o *HyprsunsetOutput) Capabilities() Capabilities {
+	return Capabilities{Temperature: true, Gamma: true, ReadBack: true}
+}
+
+func (o *HyprsunsetOutput) Close() error {
+	return nil
 }
 
 // GetAndSetBrightness gets the brightness, gets scaled values for temperature
-// and gamma and sets those in hyprland.
-func GetAndSetBrightness(cflags Config, when time.Time) {
-	brightness, err := GetBrightness(cflags, when)
+// and gamma and applies those to the given output.
+// If ambient is not nil, the brightness is blended with the ambient light.
+// If override is not nil, it takes precedence over the scaled values.
+func GetAndSetBrightness(cflags Config, out Output, when time.Time, override *Override, ambient *AmbientLight) {
+	brightness, newTemperature, newGamma, err := GetValues(cflags, when)
 	if err != nil {
 		slog.Warn("error getting brightness", "err", err)
+	} else {
+		_, newTemperature, newGamma = ambient.Values(cflags, when, brightness, newTemperature, newGamma)
 	}
-	newTemperature := ScaleBrightness(brightness, cflags.NightTemp, cflags.DayTemp)
-	newGamma := ScaleBrightness(brightness, cflags.NightGamma, cflags.DayGamma)
-	err = SetHyprsunsetTemperature(cflags, newTemperature)
-	if err != nil {
-		slog.Warn("error setting temperature", "err", err)
+	if override != nil {
+		newTemperature, newGamma = override.Values(newTemperature, newGamma)
+		slog.Debug("override active", "override", override)
 	}
-	err = SetHyprsunsetGamma(cflags, newGamma)
+	err = out.Apply(newTemperature, newGamma)
 	if err != nil {
-		slog.Warn("error setting gamma", "err", err)
+		slog.Warn("error applying values", "backend", out.Name(), "err", err)
 	}
 }
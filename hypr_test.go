@@ -41,21 +41,21 @@ func TestHyprctl(t *testing.T) {
 			"gamma",
 			101,
 			"subcmd=gamma stdout=\"Invalid gamma value (should be in range 0-100%)\\n\"",
-			"",
+			"hyprsunset gamma 101: Invalid gamma value (should be in range 0-100%)",
 		},
 		"wrong sub command": {
 			MockHyprctl,
 			"foo",
 			123,
 			"subcmd=foo stdout=\"invalid command\\n\"",
-			"",
+			"hyprsunset foo 123: invalid command",
 		},
 		"empty sub command": {
 			MockHyprctl,
 			"",
 			123,
 			"subcmd=\"\" stdout=\"invalid command\\n\"",
-			"",
+			"hyprsunset  123: invalid command",
 		},
 		"hyprctl not found": {
 			"./notexisting/binary",
@@ -115,8 +115,8 @@ func TestGetAndSetBrightness(t *testing.T) {
 			time.Date(2025, time.April, 16, 7, 25, 0, 0, time.Local),
 			[]string{
 				"level=WARN msg=hyprctl subcmd=temperature stderr=\"/bin/sh: ",
-				"level=WARN msg=\"error setting temperature\" err=\"exit status 127\"",
-				"level=WARN msg=\"error setting gamma\" err=\"exit status 127\"",
+				"level=WARN msg=\"error applying values\" backend=hyprsunset err=\"setting temperature: exit status 127",
+				"setting gamma: exit status 127",
 			},
 		},
 	}
@@ -139,7 +139,8 @@ func TestGetAndSetBrightness(t *testing.T) {
 			// Start with empty log for each test
 			logOutput.Reset()
 			cflags.HyprctlCmd = test.cmd
-			GetAndSetBrightness(cflags, test.when)
+			out, _ := NewHyprsunsetOutput(cflags)
+			GetAndSetBrightness(cflags, out, test.when, nil, nil)
 			got := logOutput.String()
 			for _, expected := range test.expected {
 				if !strings.Contains(got, expected) {
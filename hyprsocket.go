@@ -0,0 +1,69 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const (
+	hyprsunsetSocketName = ".hyprsunset.sock"
+	hyprSocketTimeout    = time.Second * 2
+)
+
+// HyprSocketDir returns the directory in which the running Hyprland instance
+// and its helpers (like hyprsunset) keep their IPC sockets:
+//
+//	$XDG_RUNTIME_DIR/hypr/$HYPRLAND_INSTANCE_SIGNATURE/
+func HyprSocketDir() (string, error) {
+	runtimeDir := os.Getenv("XDG_RUNTIME_DIR")
+	signature := os.Getenv("HYPRLAND_INSTANCE_SIGNATURE")
+	if runtimeDir == "" || signature == "" {
+		return "", errors.New("XDG_RUNTIME_DIR or HYPRLAND_INSTANCE_SIGNATURE not set, is Hyprland running?")
+	}
+	return filepath.Join(runtimeDir, "hypr", signature), nil
+}
+
+// HyprRequest writes request to the unix socket at socketPath and returns
+// the reply. The server is expected to close the connection after replying.
+func HyprRequest(socketPath, request string) (string, error) {
+	conn, err := net.DialTimeout("unix", socketPath, hyprSocketTimeout)
+	if err != nil {
+		return "", err
+	}
+	defer conn.Close()
+	err = conn.SetDeadline(time.Now().Add(hyprSocketTimeout))
+	if err != nil {
+		return "", err
+	}
+	_, err = conn.Write([]byte(request))
+	if err != nil {
+		return "", err
+	}
+	reply, err := io.ReadAll(conn)
+	return string(reply), err
+}
+
+// HyprsunsetSocket sets either temperature or gamma by talking to the
+// hyprsunset socket directly. This is the same socket "hyprctl hyprsunset"
+// forwards its arguments to.
+// Any reply other than "ok" is turned into an error, like in Hyprctl.
+func HyprsunsetSocket(socketPath, subcmd string, val int) error {
+	slog.Debug("requesting hyprsunset", subcmd, val)
+	reply, err := HyprRequest(socketPath, fmt.Sprintf("%s %d", subcmd, val))
+	if err != nil {
+		return err
+	}
+	reply = strings.TrimSpace(reply)
+	slog.Debug("hyprsunset", "subcmd", subcmd, "reply", reply)
+	if reply != "ok" {
+		return fmt.Errorf("hyprsunset %s %d: %s", subcmd, val, reply)
+	}
+	return nil
+}
@@ -0,0 +1,147 @@
+package main
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// fakeHyprsunset listens on a unix socket in dir and answers requests the
+// same way hyprsunset (and mock_hyprctl.sh) does. It returns the socket path.
+func fakeHyprsunset(t *testing.T, dir string) string {
+	t.Helper()
+	path := filepath.Join(dir, hyprsunsetSocketName)
+	l, err := net.Listen("unix", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			buf := make([]byte, 1024)
+			n, _ := conn.Read(buf)
+			conn.Write([]byte(fakeHyprsunsetReply(string(buf[:n]))))
+			conn.Close()
+		}
+	}()
+	return path
+}
+
+func fakeHyprsunsetReply(request string) string {
+	fields := strings.Fields(request)
+	if len(fields) == 0 {
+		return "invalid command"
+	}
+	switch fields[0] {
+	case "temperature":
+		if len(fields) == 1 {
+			return "6500"
+		}
+		return "ok"
+	case "gamma":
+		if len(fields) == 1 {
+			return "100"
+		}
+		if v, err := strconv.Atoi(fields[1]); err == nil && v >= 0 && v <= 100 {
+			return "ok"
+		}
+		return "Invalid gamma value (should be in range 0-100%)"
+	}
+	return "invalid command"
+}
+
+func TestHyprSocketDir(t *testing.T) {
+	t.Run("environment set", func(t *testing.T) {
+		t.Setenv("XDG_RUNTIME_DIR", "/run/user/1000")
+		t.Setenv("HYPRLAND_INSTANCE_SIGNATURE", "abc_123")
+		dir, err := HyprSocketDir()
+		if err != nil {
+			t.Errorf("Got error %v", err)
+		}
+		if dir != "/run/user/1000/hypr/abc_123" {
+			t.Errorf("Got %s", dir)
+		}
+	})
+	t.Run("signature missing", func(t *testing.T) {
+		t.Setenv("XDG_RUNTIME_DIR", "/run/user/1000")
+		t.Setenv("HYPRLAND_INSTANCE_SIGNATURE", "")
+		if _, err := HyprSocketDir(); err == nil {
+			t.Errorf("Expected error, got nil")
+		}
+	})
+}
+
+type TestHyprsunsetSocketCase struct {
+	key    string
+	value  int
+	errstr string
+}
+
+func TestHyprsunsetSocket(t *testing.T) {
+	tests := map[string]TestHyprsunsetSocketCase{
+		"set temperature": {
+			"temperature",
+			5000,
+			"",
+		},
+		"set gamma": {
+			"gamma",
+			95,
+			"",
+		},
+		"set gamma too high": {
+			"gamma",
+			101,
+			"hyprsunset gamma 101: Invalid gamma value (should be in range 0-100%)",
+		},
+		"wrong sub command": {
+			"foo",
+			123,
+			"hyprsunset foo 123: invalid command",
+		},
+	}
+	path := fakeHyprsunset(t, t.TempDir())
+	for label, test := range tests {
+		t.Run(label, func(t *testing.T) {
+			err := HyprsunsetSocket(path, test.key, test.value)
+			var errstr string
+			if err != nil {
+				errstr = err.Error()
+			}
+			if errstr != test.errstr {
+				t.Errorf("Call to hyprsunset did not return expected error string %q (got: %q)", test.errstr, errstr)
+			}
+		})
+	}
+	t.Run("no server", func(t *testing.T) {
+		err := HyprsunsetSocket(filepath.Join(t.TempDir(), hyprsunsetSocketName), "gamma", 90)
+		if err == nil {
+			t.Errorf("Expected error, got nil")
+		}
+	})
+}
+
+func TestHyprsunsetUsesSocket(t *testing.T) {
+	runtimeDir := t.TempDir()
+	t.Setenv("XDG_RUNTIME_DIR", runtimeDir)
+	t.Setenv("HYPRLAND_INSTANCE_SIGNATURE", "test")
+	dir := filepath.Join(runtimeDir, "hypr", "test")
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		t.Fatal(err)
+	}
+	fakeHyprsunset(t, dir)
+	cflags := Config{}
+	if err := SetHyprsunsetTemperature(cflags, 4000); err != nil {
+		t.Errorf("Got error %v", err)
+	}
+	if err := SetHyprsunsetGamma(cflags, 120); err == nil {
+		t.Errorf("Expected error for invalid gamma, got nil")
+	}
+}
@@ -0,0 +1,247 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"math"
+	"slices"
+	"strings"
+	"time"
+)
+
+// keyframeAnchors maps the names keyframe times can be relative to. wakeup
+// and bedtime read better with a fixed schedule, but mean the same.
+var keyframeAnchors = map[string]string{
+	"sunrise": "sunrise",
+	"sunset":  "sunset",
+	"wakeup":  "sunrise",
+	"bedtime": "sunset",
+}
+
+// KeyframeTime is either a clock time or an offset relative to sunrise or
+// sunset.
+type KeyframeTime struct {
+	// Anchor is "" for clock times, "sunrise" or "sunset"
+	Anchor string
+	// Offset is the time since midnight for clock times, otherwise the
+	// offset to Anchor
+	Offset time.Duration
+}
+
+// ParseKeyframeTime parses clock times like "22:00" and times relative to
+// sunrise or sunset like "sunset", "sunset-1h" or "wakeup+30m".
+func ParseKeyframeTime(s string) (KeyframeTime, error) {
+	s = strings.TrimSpace(s)
+	if hour, minute, err := ParseHourMinute(s); err == nil {
+		return KeyframeTime{Offset: time.Duration(hour)*time.Hour + time.Duration(minute)*time.Minute}, nil
+	}
+	name, offset := s, ""
+	if i := strings.IndexAny(s, "+-"); i >= 0 {
+		name, offset = strings.TrimSpace(s[:i]), strings.ReplaceAll(s[i:], " ", "")
+	}
+	anchor, ok := keyframeAnchors[name]
+	if !ok {
+		return KeyframeTime{}, fmt.Errorf("invalid time %q, needs to be \"HH:MM\" or relative to sunrise or sunset, e. g. \"sunset-1h\"", s)
+	}
+	k := KeyframeTime{Anchor: anchor}
+	if offset != "" {
+		d, err := time.ParseDuration(offset)
+		if err != nil {
+			return KeyframeTime{}, fmt.Errorf("invalid offset %q", offset)
+		}
+		k.Offset = d
+	}
+	return k, nil
+}
+
+// On returns the time on the day of day. rise and set are sunrise and
+// sunset of that day. ok is false if the time is relative to a zero rise or
+// set, e. g. during polar night.
+func (k KeyframeTime) On(day, rise, set time.Time) (t time.Time, ok bool) {
+	switch k.Anchor {
+	case "sunrise":
+		return rise.Add(k.Offset), !rise.IsZero()
+	case "sunset":
+		return set.Add(k.Offset), !set.IsZero()
+	}
+	return time.Date(day.Year(), day.Month(), day.Day(), 0, int(k.Offset.Minutes()), 0, 0, day.Location()), true
+}
+
+func (k KeyframeTime) String() string {
+	if k.Anchor == "" {
+		return fmt.Sprintf("%d:%02d", int(k.Offset.Hours()), int(k.Offset.Minutes())%60)
+	}
+	switch {
+	case k.Offset > 0:
+		return k.Anchor + "+" + k.Offset.String()
+	case k.Offset < 0:
+		return k.Anchor + k.Offset.String()
+	}
+	return k.Anchor
+}
+
+// Keyframe sets temperature and gamma at a point of the day. Values
+// between keyframes are interpolated.
+type Keyframe struct {
+	At          KeyframeTime
+	Temperature int
+	Gamma       int
+}
+
+// keyframePoint is a keyframe resolved to an actual time
+type keyframePoint struct {
+	t time.Time
+	Keyframe
+}
+
+// keyframePoints resolves the keyframes for the day before, the day of and
+// the day after when, sorted by time. dayTimes returns sunrise and sunset
+// for the day of its argument.
+func keyframePoints(keyframes []Keyframe, when time.Time, dayTimes func(time.Time) (time.Time, time.Time, error)) ([]keyframePoint, error) {
+	var points []keyframePoint
+	for offset := -1; offset <= 1; offset++ {
+		day := time.Date(when.Year(), when.Month(), when.Day()+offset, 12, 0, 0, 0, when.Location())
+		rise, set, err := dayTimes(day)
+		if err != nil {
+			return nil, err
+		}
+		for _, k := range keyframes {
+			if t, ok := k.At.On(day, rise, set); ok {
+				points = append(points, keyframePoint{t, k})
+			}
+		}
+	}
+	slices.SortStableFunc(points, func(a, b keyframePoint) int {
+		return a.t.Compare(b.t)
+	})
+	return points, nil
+}
+
+// KeyframeValues interpolates temperature and gamma at when between the
+// neighbouring keyframes. brightness is the temperature relative to the
+// lowest and highest keyframe temperature. ok is false if no keyframes
+// surround when, e. g. if they are all relative to sunset during polar day.
+func KeyframeValues(keyframes []Keyframe, when time.Time, dayTimes func(time.Time) (time.Time, time.Time, error)) (brightness float64, temperature, gamma int, ok bool, err error) {
+	points, err := keyframePoints(keyframes, when, dayTimes)
+	if err != nil {
+		return
+	}
+	i, _ := slices.BinarySearchFunc(points, when, func(p keyframePoint, t time.Time) int {
+		return p.t.Compare(t)
+	})
+	if i < len(points) && points[i].t.Equal(when) {
+		// exactly at a keyframe
+		temperature, gamma = points[i].Temperature, points[i].Gamma
+	} else {
+		if i == 0 || i == len(points) {
+			return
+		}
+		prev, next := points[i-1], points[i]
+		ratio := float64(when.Sub(prev.t)) / float64(next.t.Sub(prev.t))
+		temperature = interpolate(prev.Temperature, next.Temperature, ratio)
+		gamma = interpolate(prev.Gamma, next.Gamma, ratio)
+		slog.Debug("between keyframes", "prev", prev.At, "next", next.At, "ratio", ratio)
+	}
+	lowest, highest := keyframes[0].Temperature, keyframes[0].Temperature
+	for _, k := range keyframes {
+		lowest, highest = min(lowest, k.Temperature), max(highest, k.Temperature)
+	}
+	brightness = 1.0
+	if highest > lowest {
+		brightness = roundFloat3(float64(temperature-lowest) / float64(highest-lowest))
+	}
+	return brightness, temperature, gamma, true, nil
+}
+
+// NextKeyframeChange returns when the keyframe values change next after
+// when. Between keyframes with the same values this is the next keyframe,
+// otherwise the time temperature or gamma change by about one unit, but
+// not before minStep. ok is false if no keyframes surround when.
+func NextKeyframeChange(keyframes []Keyframe, when time.Time, minStep time.Duration, dayTimes func(time.Time) (time.Time, time.Time, error)) (next time.Time, ok bool, err error) {
+	points, err := keyframePoints(keyframes, when, dayTimes)
+	if err != nil {
+		return
+	}
+	i, found := slices.BinarySearchFunc(points, when, func(p keyframePoint, t time.Time) int {
+		return p.t.Compare(t)
+	})
+	for found && i < len(points) && !points[i].t.After(when) {
+		i++
+	}
+	if i == 0 || i == len(points) {
+		return
+	}
+	prev, following := points[i-1], points[i]
+	units := max(abs(following.Temperature-prev.Temperature), abs(following.Gamma-prev.Gamma))
+	if units == 0 {
+		return following.t, true, nil
+	}
+	step := max(following.t.Sub(prev.t)/time.Duration(units), minStep)
+	next = when.Add(step)
+	if next.After(following.t) {
+		next = following.t
+	}
+	return next, true, nil
+}
+
+func abs(i int) int {
+	if i < 0 {
+		return -i
+	}
+	return i
+}
+
+func interpolate(from, to int, ratio float64) int {
+	return int(math.Round(float64(from) + float64(to-from)*ratio))
+}
+
+// KeyframesFromConfig reads the [[keyframe]] sections of the config file.
+// gamma is optional and defaults to NeutralGamma.
+func KeyframesFromConfig(doc *tomlDoc) ([]Keyframe, error) {
+	var keyframes []Keyframe
+	for _, t := range doc.Tables("keyframe") {
+		k := Keyframe{Gamma: NeutralGamma}
+		hasAt, hasTemperature := false, false
+		for _, v := range t.values {
+			fail := func(err error) ([]Keyframe, error) {
+				return nil, &ConfigError{doc.file, v.line, v.key, err}
+			}
+			switch v.key {
+			case "at":
+				s, ok := v.value.(string)
+				if !ok {
+					return fail(errors.New("needs to be a string"))
+				}
+				at, err := ParseKeyframeTime(s)
+				if err != nil {
+					return fail(err)
+				}
+				k.At, hasAt = at, true
+			case "temperature", "gamma":
+				i, ok := v.value.(int64)
+				if !ok {
+					return fail(errors.New("needs to be an integer"))
+				}
+				var err error
+				if v.key == "temperature" {
+					k.Temperature, hasTemperature = int(i), true
+					err = isBetween(k.Temperature, 1000, 20000)
+				} else {
+					k.Gamma = int(i)
+					err = isBetween(k.Gamma, 0, 100)
+				}
+				if err != nil {
+					return fail(err)
+				}
+			default:
+				return fail(errors.New("unknown setting"))
+			}
+		}
+		if !hasAt || !hasTemperature {
+			return nil, &ConfigError{doc.file, t.line, "", errors.New("[[keyframe]] needs at and temperature")}
+		}
+		keyframes = append(keyframes, k)
+	}
+	return keyframes, nil
+}
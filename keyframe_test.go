@@ -0,0 +1,183 @@
+package main
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+type ParseKeyframeTimeTestCase struct {
+	input    string
+	expected KeyframeTime
+	isErr    bool
+}
+
+func TestParseKeyframeTime(t *testing.T) {
+	tests := map[string]ParseKeyframeTimeTestCase{
+		"clock time":     {"22:30", KeyframeTime{"", 22*time.Hour + 30*time.Minute}, false},
+		"sunset":         {"sunset", KeyframeTime{"sunset", 0}, false},
+		"before sunset":  {"sunset-1h", KeyframeTime{"sunset", -time.Hour}, false},
+		"after sunrise":  {"sunrise + 30m", KeyframeTime{"sunrise", 30 * time.Minute}, false},
+		"wakeup":         {"wakeup+1h30m", KeyframeTime{"sunrise", 90 * time.Minute}, false},
+		"bedtime":        {"bedtime-2h", KeyframeTime{"sunset", -2 * time.Hour}, false},
+		"unknown anchor": {"noon", KeyframeTime{}, true},
+		"invalid offset": {"sunset+1x", KeyframeTime{}, true},
+		"invalid time":   {"25:00", KeyframeTime{}, true},
+		"offset only":    {"+1h", KeyframeTime{}, true},
+	}
+	for label, test := range tests {
+		t.Run(label, func(t *testing.T) {
+			result, err := ParseKeyframeTime(test.input)
+			if (err != nil) != test.isErr {
+				t.Fatalf("Got error %v", err)
+			}
+			if result != test.expected {
+				t.Errorf("Got %v instead of %v", result, test.expected)
+			}
+		})
+	}
+}
+
+// testKeyframes winds down through the evening
+func testKeyframes() []Keyframe {
+	return []Keyframe{
+		{KeyframeTime{"", 12 * time.Hour}, 6500, 100},
+		{KeyframeTime{"sunset", 0}, 5000, 95},
+		{KeyframeTime{"", 22 * time.Hour}, 3400, 90},
+		{KeyframeTime{"", 1 * time.Hour}, 2700, 85},
+		{KeyframeTime{"sunrise", 0}, 2700, 85},
+		{KeyframeTime{"sunrise", time.Hour}, 6500, 100},
+	}
+}
+
+// testDayTimes has sunrise at 7:00 and sunset at 20:00
+func testDayTimes(day time.Time) (time.Time, time.Time, error) {
+	return time.Date(day.Year(), day.Month(), day.Day(), 7, 0, 0, 0, day.Location()),
+		time.Date(day.Year(), day.Month(), day.Day(), 20, 0, 0, 0, day.Location()), nil
+}
+
+type KeyframeValuesTestCase struct {
+	t                   time.Time
+	expectedBrightness  float64
+	expectedTemperature int
+	expectedGamma       int
+}
+
+func TestKeyframeValues(t *testing.T) {
+	tests := map[string]KeyframeValuesTestCase{
+		"noon":                  {time.Date(2025, time.April, 15, 12, 0, 0, 0, time.Local), 1.0, 6500, 100},
+		"afternoon":             {time.Date(2025, time.April, 15, 16, 0, 0, 0, time.Local), 0.803, 5750, 98},
+		"at sunset":             {time.Date(2025, time.April, 15, 20, 0, 0, 0, time.Local), 0.605, 5000, 95},
+		"evening":               {time.Date(2025, time.April, 15, 21, 0, 0, 0, time.Local), 0.395, 4200, 93},
+		"before midnight":       {time.Date(2025, time.April, 15, 23, 30, 0, 0, time.Local), 0.092, 3050, 88},
+		"after midnight":        {time.Date(2025, time.April, 16, 0, 30, 0, 0, time.Local), 0.031, 2817, 86},
+		"night":                 {time.Date(2025, time.April, 16, 4, 0, 0, 0, time.Local), 0.0, 2700, 85},
+		"during sunrise":        {time.Date(2025, time.April, 16, 7, 30, 0, 0, time.Local), 0.5, 4600, 93},
+		"between day keyframes": {time.Date(2025, time.April, 16, 10, 0, 0, 0, time.Local), 1.0, 6500, 100},
+	}
+	for label, test := range tests {
+		t.Run(label, func(t *testing.T) {
+			brightness, temperature, gamma, ok, err := KeyframeValues(testKeyframes(), test.t, testDayTimes)
+			if err != nil || !ok {
+				t.Fatalf("Got %v, %v", ok, err)
+			}
+			if brightness != test.expectedBrightness || temperature != test.expectedTemperature || gamma != test.expectedGamma {
+				t.Errorf("Got %.3f %d %d instead of %.3f %d %d", brightness, temperature, gamma, test.expectedBrightness, test.expectedTemperature, test.expectedGamma)
+			}
+		})
+	}
+}
+
+func TestKeyframeValuesWithoutSun(t *testing.T) {
+	noSun := func(time.Time) (time.Time, time.Time, error) {
+		return time.Time{}, time.Time{}, nil
+	}
+	when := time.Date(2025, time.June, 15, 23, 0, 0, 0, time.Local)
+	keyframes := []Keyframe{{KeyframeTime{"sunset", 0}, 4000, 100}}
+	if _, _, _, ok, _ := KeyframeValues(keyframes, when, noSun); ok {
+		t.Errorf("Expected no values with only sunset keyframes and no sunset")
+	}
+	keyframes = append(keyframes, Keyframe{KeyframeTime{"", 22 * time.Hour}, 3000, 90})
+	if _, temperature, _, ok, _ := KeyframeValues(keyframes, when, noSun); !ok || temperature != 3000 {
+		t.Errorf("Got %d, %v, expected clock time keyframes to be used", temperature, ok)
+	}
+	failing := func(time.Time) (time.Time, time.Time, error) {
+		return time.Time{}, time.Time{}, errors.New("fail")
+	}
+	if _, _, _, _, err := KeyframeValues(keyframes, when, failing); err == nil {
+		t.Errorf("Expected error, got nil")
+	}
+}
+
+func TestGetValuesKeyframes(t *testing.T) {
+	cflags := statusTestConfig()
+	cflags.Wakeup = "7:00"
+	cflags.Bedtime = "20:00"
+	cflags.Keyframes = testKeyframes()
+	brightness, temperature, gamma, err := GetValues(cflags, time.Date(2025, time.April, 15, 21, 0, 0, 0, time.Local))
+	if err != nil {
+		t.Fatalf("Got error %v", err)
+	}
+	if brightness != 0.395 || temperature != 4200 || gamma != 93 {
+		t.Errorf("Got %.3f %d %d", brightness, temperature, gamma)
+	}
+}
+
+func TestKeyframesFromConfig(t *testing.T) {
+	writeConfig(t, `
+[[keyframe]]
+at = "12:00"
+temperature = 6500
+
+[[keyframe]]
+at = "sunset-30m"
+temperature = 5000
+gamma = 95
+`)
+	c, _, err := GetFlags("foo", []string{})
+	if err != nil {
+		t.Fatalf("Got error %v", err)
+	}
+	expected := []Keyframe{
+		{KeyframeTime{"", 12 * time.Hour}, 6500, NeutralGamma},
+		{KeyframeTime{"sunset", -30 * time.Minute}, 5000, 95},
+	}
+	if len(c.Keyframes) != len(expected) || c.Keyframes[0] != expected[0] || c.Keyframes[1] != expected[1] {
+		t.Errorf("Got %v instead of %v", c.Keyframes, expected)
+	}
+}
+
+func TestKeyframesFromConfigErrors(t *testing.T) {
+	tests := map[string]ConfigFileErrorTestCase{
+		"missing temperature": {
+			"[[keyframe]]\nat = \"12:00\"",
+			"config.toml:1: [[keyframe]] needs at and temperature",
+		},
+		"invalid time": {
+			"[[keyframe]]\nat = \"noon\"\ntemperature = 6500",
+			"config.toml:2: at: invalid time \"noon\", needs to be \"HH:MM\" or relative to sunrise or sunset, e. g. \"sunset-1h\"",
+		},
+		"temperature out of range": {
+			"[[keyframe]]\nat = \"12:00\"\ntemperature = 65000",
+			"config.toml:3: temperature: Value (65000) must be >=1000 and <=20000",
+		},
+		"gamma not an integer": {
+			"[[keyframe]]\nat = \"12:00\"\ntemperature = 6500\ngamma = \"full\"",
+			"config.toml:4: gamma: needs to be an integer",
+		},
+		"unknown key": {
+			"[[keyframe]]\nat = \"12:00\"\ntemp = 6500",
+			"config.toml:3: temp: unknown setting",
+		},
+	}
+	for label, test := range tests {
+		t.Run(label, func(t *testing.T) {
+			path := writeConfig(t, test.content)
+			_, _, err := GetFlags("foo", []string{})
+			if err == nil || err.Error() != filepath.Dir(path)+"/"+test.expected {
+				t.Errorf("Got error %v instead of %s", err, test.expected)
+			}
+		})
+	}
+}
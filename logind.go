@@ -0,0 +1,54 @@
+package main
+
+import (
+	"log/slog"
+)
+
+const (
+	logindName      = "org.freedesktop.login1"
+	logindPath      = "/org/freedesktop/login1"
+	logindInterface = "org.freedesktop.login1.Manager"
+	logindSleep     = "PrepareForSleep"
+)
+
+// LogindResumeEvent returns a channel that receives whenever the machine
+// resumed from suspend or hibernation. It listens for the PrepareForSleep
+// signal logind sends on the bus at address (usually the system bus) before
+// going to sleep, with argument true, and after waking up, with false.
+// If the connection fails later, a warning is logged and the channel
+// receives nothing from then on.
+func LogindResumeEvent(address string) (<-chan struct{}, error) {
+	conn, err := dbusDial(address)
+	if err != nil {
+		return nil, err
+	}
+	err = conn.addMatch("type='signal',sender='" + logindName + "',path='" + logindPath +
+		"',interface='" + logindInterface + "',member='" + logindSleep + "'")
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	resumed := make(chan struct{}, 1)
+	go func() {
+		defer conn.Close()
+		for {
+			m, err := conn.next()
+			if err != nil {
+				slog.Warn("stopped listening for resume from suspend", "error", err)
+				return
+			}
+			if m.kind != dbusSignal || m.iface != logindInterface || m.member != logindSleep || m.signature != "b" {
+				continue
+			}
+			if m.Args().Bool() {
+				slog.Debug("preparing for sleep")
+				continue
+			}
+			select {
+			case resumed <- struct{}{}:
+			default:
+			}
+		}
+	}()
+	return resumed, nil
+}
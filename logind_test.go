@@ -0,0 +1,79 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+// fakeLogind connects to the bus at address and takes logind's name, so its
+// signals pass the sender check of LogindResumeEvent.
+func fakeLogind(t *testing.T, address string) *dbusConn {
+	t.Helper()
+	conn, err := dbusDial(address)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	_, err = conn.call("org.freedesktop.DBus", "/org/freedesktop/DBus", "org.freedesktop.DBus", "RequestName", logindName, uint32(0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return conn
+}
+
+func prepareForSleep(t *testing.T, conn *dbusConn, start bool) {
+	t.Helper()
+	_, err := conn.send(dbusMessage{kind: dbusSignal, path: logindPath, iface: logindInterface, member: logindSleep}, start)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestLogindResumeEvent(t *testing.T) {
+	address := startDbusDaemon(t)
+	resumed, err := LogindResumeEvent(address)
+	if err != nil {
+		t.Fatal(err)
+	}
+	logind := fakeLogind(t, address)
+
+	prepareForSleep(t, logind, true)
+	select {
+	case <-resumed:
+		t.Fatal("Got resume event when going to sleep")
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	prepareForSleep(t, logind, false)
+	select {
+	case <-resumed:
+	case <-time.After(5 * time.Second):
+		t.Fatal("No resume event received")
+	}
+}
+
+func TestLogindResumeEventOtherSender(t *testing.T) {
+	address := startDbusDaemon(t)
+	resumed, err := LogindResumeEvent(address)
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, err := dbusDial(address)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer other.Close()
+	prepareForSleep(t, other, false)
+	select {
+	case <-resumed:
+		t.Error("Got resume event from a sender other than logind")
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+func TestLogindResumeEventNoBus(t *testing.T) {
+	_, err := LogindResumeEvent("unix:path=" + t.TempDir() + "/missing")
+	if err == nil {
+		t.Error("Connecting to a missing bus did not fail")
+	}
+}
@@ -2,12 +2,17 @@ package main
 
 import (
 	"bytes"
+	"cmp"
+	"context"
 	"errors"
 	"flag"
 	"fmt"
 	"log/slog"
 	"math"
+	"net"
 	"os"
+	"strings"
+	"sync"
 	"syscall"
 	"time"
 )
@@ -28,7 +33,43 @@ type Config struct {
 	Loop               bool
 	Version            bool
 	HyprctlCmd         string
+	Backend            string
+	ConfigFile         string
+	Command            []string
+	Waybar             bool
+	Elevation          bool
+	ElevationNight     float64
+	ElevationDay       float64
+	PolarSchedule      string
+	Schedule           *Schedule
+	Keyframes          []Keyframe
+	SunriseCurve       string
+	SunsetCurve        string
 	TransitionDuration time.Duration
+	SunriseDuration    time.Duration
+	SunsetDuration     time.Duration
+	SunriseOffset      time.Duration
+	SunsetOffset       time.Duration
+	LoopInterval       time.Duration
+	// Power is "auto" or the PowerSource whose profile is always used
+	Power         string
+	PowerProfiles map[PowerSource]PowerProfile
+	// Backlight, Ambient and DDC are grouped, so the devices can be
+	// opened again when their settings change on reload
+	Backlight BacklightSettings
+	Ambient   AmbientSettings
+	DDC       DDCSettings
+}
+
+// Transitions returns the placement of the sunrise and sunset transitions.
+// Durations not given fall back to TransitionDuration.
+func (c Config) Transitions() Transitions {
+	return Transitions{
+		SunriseDuration: cmp.Or(c.SunriseDuration, c.TransitionDuration),
+		SunsetDuration:  cmp.Or(c.SunsetDuration, c.TransitionDuration),
+		SunriseOffset:   c.SunriseOffset,
+		SunsetOffset:    c.SunsetOffset,
+	}
 }
 
 const (
@@ -38,8 +79,13 @@ const (
 	DefaultDayTemp            = 6500
 	DefaultNightGamma         = 90
 	DefaultDayGamma           = 100
-	DefaultLoopInterval       = time.Second * 30
 	DefaultTransitionDuration = time.Hour
+	// MaxLoopInterval is the longest the loop sleeps without looking at
+	// the wall clock
+	MaxLoopInterval = time.Minute
+	// TransitionLoopInterval is the default time between updates during
+	// transitions
+	TransitionLoopInterval = time.Second * 10
 )
 
 // roundFloat rounds a float to the given precision
@@ -91,29 +137,400 @@ func GetFlags(progname string, args []string) (Config, string, error) {
 	flags.IntVar(&(c.DayGamma), "gammaDay", DefaultDayGamma, "Day gamma")
 	flags.Float64Var(&(c.Latitude), "latitude", DefaultLatitude, "Your location latitude")
 	flags.Float64Var(&(c.Longitude), "longitude", DefaultLongitude, "Your location longitude")
+	flags.BoolVar(&(c.Elevation), "elevation", false, "Follow the sun elevation at your location instead of using fixed duration transitions")
+	flags.Float64Var(&(c.ElevationNight), "elevationNight", DefaultElevationNight, "Sun elevation in degrees at or below which it is night (with -elevation)")
+	flags.Float64Var(&(c.ElevationDay), "elevationDay", DefaultElevationDay, "Sun elevation in degrees at or above which it is day (with -elevation)")
+	flags.StringVar(&(c.PolarSchedule), "polarSchedule", "", "Fixed schedule used during polar day and night, e. g. \"7:00-22:00\" (default: follow sun elevation)")
 	flags.StringVar(&(c.Wakeup), "fixedWakeup", "", "Wakeup time in 24-hour format, e. g. \"6:00\" (overrides location)")
 	flags.StringVar(&(c.Bedtime), "fixedBedtime", "", "Bedtime time in 24-hour format, e. g. \"22:30\" (overrides location)")
 	flags.BoolVar(&(c.Loop), "loop", false, "Run nerdshade continuously")
+	flags.BoolVar(&(c.Waybar), "waybar", false, "Run continuously and print status as waybar custom module JSON (implies -loop)")
 	flags.BoolVar(&(c.Version), "V", false, "Show program version")
-	flags.StringVar(&(c.HyprctlCmd), "hyperctl", HyprctlCmd, "Path to hyperctl program")
+	flags.StringVar(&(c.HyprctlCmd), "hyperctl", "", "Path to hyperctl program (default: talk to the hyprsunset socket directly)")
+	flags.StringVar(&(c.Backend), "backend", DefaultBackend, fmt.Sprintf("Output backend, one of: %s", strings.Join(BackendNames(), ", ")))
 	flags.DurationVar(&(c.TransitionDuration), "transitionDuration", DefaultTransitionDuration, "Duration of transition, e. g. \"45m\" or \"1h10m\"")
+	flags.DurationVar(&(c.SunriseDuration), "sunriseDuration", 0, "Duration of the sunrise transition (default: -transitionDuration)")
+	flags.DurationVar(&(c.SunsetDuration), "sunsetDuration", 0, "Duration of the sunset transition (default: -transitionDuration)")
+	flags.DurationVar(&(c.SunriseOffset), "sunriseOffset", 0, "Start the sunrise transition this long after sunrise, negative for before, e. g. \"-30m\"")
+	flags.DurationVar(&(c.SunsetOffset), "sunsetOffset", 0, "End the sunset transition this long after sunset, negative for before, e. g. \"30m\"")
+	flags.StringVar(&(c.SunriseCurve), "sunriseCurve", string(DefaultCurve), fmt.Sprintf("Curve of the sunrise transition, one of: %s", curveNames()))
+	flags.StringVar(&(c.SunsetCurve), "sunsetCurve", string(DefaultCurve), fmt.Sprintf("Curve of the sunset transition, one of: %s", curveNames()))
+	flags.DurationVar(&(c.LoopInterval), "loopInterval", TransitionLoopInterval, "Time between updates during transitions in loop mode")
+	flags.StringVar(&(c.Power), "power", PowerAuto, "Power profile to use, one of: auto, ac, battery (auto follows the AC adapter)")
+	flags.StringVar(&(c.Backlight.Mode), "backlight", "none", fmt.Sprintf("Backlight control, one of: %s", strings.Join(backlightModes, ", ")))
+	flags.StringVar(&(c.Backlight.Device), "backlightDevice", "", "Backlight device in /sys/class/backlight (default: detect)")
+	flags.IntVar(&(c.Backlight.Day), "backlightDay", DefaultBacklightDay, "Day backlight brightness in percent (with -backlight)")
+	flags.IntVar(&(c.Backlight.Night), "backlightNight", DefaultBacklightNight, "Night backlight brightness in percent (with -backlight)")
+	flags.BoolVar(&(c.Backlight.RespectManual), "backlightRespectManual", false, "Leave the backlight alone until the next phase after it was changed by someone else")
+	flags.StringVar(&(c.Ambient.Sensor), "ambientSensor", "", "Ambient light sensor directory in /sys/bus/iio/devices, or \"auto\" (default: no sensor)")
+	flags.Float64Var(&(c.Ambient.Scale), "ambientScale", 0, "Factor converting raw sensor readings to lux (default: from the sensor)")
+	flags.Float64Var(&(c.Ambient.LuxNight), "ambientLuxNight", DefaultAmbientLuxNight, "Ambient light in lux at or below which it counts as night")
+	flags.Float64Var(&(c.Ambient.LuxDay), "ambientLuxDay", DefaultAmbientLuxDay, "Ambient light in lux at or above which it counts as day")
+	flags.StringVar(&(c.Ambient.Policy), "ambientPolicy", DefaultAmbientPolicy, fmt.Sprintf("How to combine ambient light with the time of day, one of: %s", strings.Join(ambientPolicies, ", ")))
+	flags.Float64Var(&(c.Ambient.Weight), "ambientWeight", DefaultAmbientWeight, "Weight of ambient light between 0 and 1 (with -ambientPolicy weighted)")
+	flags.DurationVar(&(c.Ambient.Smoothing), "ambientSmoothing", DefaultAmbientSmoothing, "Time constant for smoothing ambient light readings")
+	flags.StringVar(&(c.DDC.Devices), "ddc", "", "Monitors to dim over DDC/CI, \"auto\" or a list of I2C devices or connectors, e. g. \"DP-1,/dev/i2c-5\" (default: none)")
+	flags.IntVar(&(c.DDC.Min), "ddcMin", DefaultDDCMin, "Night monitor brightness in percent (with -ddc)")
+	flags.IntVar(&(c.DDC.Max), "ddcMax", DefaultDDCMax, "Day monitor brightness in percent (with -ddc)")
+	flags.IntVar(&(c.DDC.ContrastMin), "ddcContrastMin", 0, "Night monitor contrast in percent (with -ddc and -ddcContrastMax)")
+	flags.IntVar(&(c.DDC.ContrastMax), "ddcContrastMax", 0, "Day monitor contrast in percent (with -ddc, default: contrast is not changed)")
+	flags.DurationVar(&(c.DDC.Interval), "ddcInterval", DefaultDDCInterval, "Minimum time between brightness changes of a monitor (with -ddc)")
+	flags.StringVar(&(c.ConfigFile), "config", "", "Path to config file (default \"$XDG_CONFIG_HOME/nerdshade/config.toml\")")
 	err := flags.Parse(args)
+	if err != nil {
+		return c, out.String(), err
+	}
+	err = ValidateFlags(flags)
+	if err != nil {
+		return c, out.String(), err
+	}
+	doc, err := LoadConfigFile(flags, c.ConfigFile, c.ConfigFile != "")
+	if err != nil {
+		return c, out.String(), err
+	}
 	if !BothOrNone(c.Wakeup, c.Bedtime) {
 		return c, out.String(), errors.New("Both, -fixedBedtime and -fixedWakeup need to be supplied")
 	}
+	c.Schedule, err = ScheduleFromConfig(doc, c.Wakeup, c.Bedtime)
+	if err != nil {
+		return c, out.String(), err
+	}
+	c.Keyframes, err = KeyframesFromConfig(doc)
+	if err != nil {
+		return c, out.String(), err
+	}
+	c.PowerProfiles, err = PowerProfilesFromConfig(doc)
+	if err != nil {
+		return c, out.String(), err
+	}
+	if c.DDC.Min > c.DDC.Max {
+		return c, out.String(), errors.New("-ddcMin must not be higher than -ddcMax")
+	}
+	if c.DDC.ContrastMin > c.DDC.ContrastMax {
+		return c, out.String(), errors.New("-ddcContrastMin must not be higher than -ddcContrastMax")
+	}
+	c.DDC.Monitors, err = DDCMonitorsFromConfig(doc, c.DDC.Defaults())
+	if err != nil {
+		return c, out.String(), err
+	}
+	if c.ElevationNight >= c.ElevationDay {
+		return c, out.String(), errors.New("-elevationNight needs to be lower than -elevationDay")
+	}
+	if c.Ambient.LuxNight <= 0 || c.Ambient.LuxNight >= c.Ambient.LuxDay {
+		return c, out.String(), errors.New("-ambientLuxNight needs to be positive and lower than -ambientLuxDay")
+	}
+	c.Command = flags.Args()
+	if c.Waybar {
+		c.Loop = true
+	}
+	// the compositor resets the gamma as soon as nerdshade disconnects
+	if c.Backend == "wlr" && !c.Loop && len(c.Command) == 0 {
+		return c, out.String(), errors.New("-backend wlr needs -loop")
+	}
 	return c, out.String(), err
 }
 
-func mainLoop(cflags Config) int {
-	slog.Debug("starting", "localtime", time.Now())
+func listenControl() (net.Listener, error) {
+	path, err := ControlSocketPath()
+	if err != nil {
+		return nil, err
+	}
+	slog.Debug("control socket", "path", path)
+	return ListenControl(path)
+}
+
+// runCtl sends a request to the control socket of a running instance
+func runCtl(progname string, args []string) int {
+	request, usage, err := CtlRequest(progname, args)
+	if err == flag.ErrHelp {
+		fmt.Println(usage)
+		return 0
+	}
+	if err != nil {
+		slog.Error("Error in ctl arguments", "error", err)
+		return 1
+	}
+	path, err := ControlSocketPath()
+	if err != nil {
+		slog.Error("Error finding control socket", "error", err)
+		return 1
+	}
+	reply, err := SendControl(path, request)
+	if err != nil {
+		slog.Error("Error from control socket", "error", err)
+		return 1
+	}
+	fmt.Println(reply)
+	return 0
+}
+
+// runCommand runs the subcommand given after the flags
+func runCommand(progname string, cflags Config) int {
+	cflags = cflags.WithPower(ReadPowerSource(powerSupplyDir))
+	switch cflags.Command[0] {
+	case "ctl":
+		return runCtl(progname, cflags.Command[1:])
+	case "status":
+		now := time.Now()
+		ambient, err := NewAmbientLight(cflags.Ambient)
+		if err == nil {
+			err = ambient.Sample(now)
+		}
+		if err != nil {
+			slog.Warn("ambient light sensor can not be used", "error", err)
+			ambient = nil
+		}
+		usage, err := RunStatus(progname, cflags, cflags.Command[1:], now, ambient, os.Stdout)
+		if err == flag.ErrHelp {
+			fmt.Println(usage)
+			return 0
+		}
+		if err != nil {
+			slog.Error("Error getting status", "error", err)
+			return 1
+		}
+		return 0
+	case "schedule":
+		usage, err := RunSchedule(progname, cflags, cflags.Command[1:], time.Now(), os.Stdout)
+		if err == flag.ErrHelp {
+			fmt.Println(usage)
+			return 0
+		}
+		if err != nil {
+			slog.Error("Error getting schedule", "error", err)
+			return 1
+		}
+		return 0
+	case "preview":
+		usage, err := RunPreview(progname, cflags, cflags.Command[1:], time.Now(), os.Stdout)
+		if err == flag.ErrHelp {
+			fmt.Println(usage)
+			return 0
+		}
+		if err != nil {
+			slog.Error("Error previewing", "error", err)
+			return 1
+		}
+		return 0
+	case "simulate":
+		usage, err := RunSimulate(progname, cflags, cflags.Command[1:], time.Now(), os.Stdout)
+		if err == flag.ErrHelp {
+			fmt.Println(usage)
+			return 0
+		}
+		if err != nil {
+			slog.Error("Error simulating", "error", err)
+			return 1
+		}
+		return 0
+	}
+	slog.Error("Unknown command", "command", cflags.Command[0])
+	return 1
+}
+
+// setLogLevel switches debug output on or off
+func setLogLevel(debug bool) {
+	if debug {
+		slog.SetLogLoggerLevel(slog.LevelDebug)
+	} else {
+		slog.SetLogLoggerLevel(slog.LevelInfo)
+	}
+}
+
+// mainLoop applies the brightness for the time clock tells once or, in loop
+// mode, continuously.
+// In loop mode, reload is called to get a new config whenever the config
+// file changes.
+func mainLoop(cflags Config, clock Clock, reload func() (Config, error)) int {
+	slog.Debug("starting", "localtime", clock.Now())
+	out, err := NewOutput(cflags)
+	if err != nil {
+		slog.Error("Error creating output", "error", err)
+		return 1
+	}
+	defer out.Close()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	opts := loopOptions{reload: reload, powerDir: powerSupplyDir}
+	if cflags.Loop {
+		opts.events = systemLoopEvents(ctx)
+	}
+	runLoop(ctx, cflags, out, clock, opts)
+	return 0
+}
+
+// loopOptions tell runLoop what to do besides applying values
+type loopOptions struct {
+	// reload is called to get a new config whenever the config file
+	// changes. Without reload, neither the config file is watched nor the
+	// control socket is created.
+	reload func() (Config, error)
+	// events wake up the loop besides its timer
+	events loopEvents
+	// powerDir is where the power supplies are read, see ReadPowerSource
+	powerDir string
+	// until, if not zero, is the last time values are applied for. The
+	// loop stops afterwards.
+	until time.Time
+	// applied is called with the config in effect after values were
+	// applied for now
+	applied func(active Config, now time.Time)
+}
+
+// openDevice opens a device from its settings. A device that can not be
+// opened is nil, which all devices treat as not used, and a warning is
+// logged.
+func openDevice[S, D any](name string, open func(S) (D, error), settings S) D {
+	d, err := open(settings)
+	if err != nil {
+		slog.Warn("device can not be used", "device", name, "error", err)
+	}
+	return d
+}
+
+// runLoop applies the brightness for the time clock tells to out once or,
+// in loop mode, continuously until ctx is done or the process is
+// interrupted. The daemon and the preview both run it, with a different
+// clock.
+func runLoop(ctx context.Context, cflags Config, out Output, clock Clock, opts loopOptions) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	out = NewChangeOutput(out, clock)
+	// cflags may be replaced by a reload and power may change while the
+	// loop is running
+	var mu sync.Mutex
+	var control *Control
+	power := ReadPowerSource(opts.powerDir)
+	slog.Debug("power source", "power", power)
+	setPower := func(source PowerSource) {
+		mu.Lock()
+		defer mu.Unlock()
+		if source != power {
+			slog.Info("power source changed", "power", source)
+			power = source
+		}
+	}
+	// backlight and the other devices are opened again when their settings
+	// change on reload
+	backlight := openDevice("backlight", NewBacklight, cflags.Backlight)
+	ambient := openDevice("ambient light sensor", NewAmbientLight, cflags.Ambient)
+	ddc := openDevice("DDC/CI monitors", NewDDC, cflags.DDC)
 	doit := func() {
-		GetAndSetBrightness(cflags, time.Now())
+		// ACPI events tell about changes right away, but they may not be
+		// available, so the power supplies are read on every update too
+		setPower(ReadPowerSource(opts.powerDir))
+		mu.Lock()
+		defer mu.Unlock()
+		now := clock.Now()
+		if !opts.until.IsZero() && now.After(opts.until) {
+			now = opts.until
+		}
+		override := control.Override(now)
+		active := cflags.WithPower(power)
+		if err := ambient.Sample(now); err != nil {
+			slog.Warn("error reading ambient light sensor", "err", err)
+		}
+		GetAndSetBrightness(active, out, now, override, ambient)
+		if override == nil || !override.Pause {
+			SetBacklight(active, backlight, ambient, now)
+			SetDDC(active, ddc, ambient, now)
+		}
+		if cflags.Waybar {
+			err := WriteWaybar(os.Stdout, active, now, override, ambient)
+			if err != nil {
+				slog.Warn("error writing waybar status", "err", err)
+			}
+		}
+		if opts.applied != nil {
+			opts.applied(active, now)
+		}
+		if now.Equal(opts.until) {
+			cancel()
+		}
+	}
+	if cflags.Loop && opts.reload != nil {
+		control = NewControl(doit)
+		l, err := listenControl()
+		if err != nil {
+			slog.Warn("control socket could not be created", "error", err)
+		} else {
+			defer l.Close()
+			go control.Serve(l)
+		}
 	}
 	doit()
 	if cflags.Loop {
-		repeatUntilInterrupt(doit, DefaultLoopInterval, syscall.SIGINT, syscall.SIGTERM)
+		if opts.reload != nil {
+			configChanges := WatchConfigFile(cmp.Or(cflags.ConfigFile, DefaultConfigFile()))
+			go func() {
+				for range configChanges {
+					newFlags, err := opts.reload()
+					if err != nil {
+						slog.Warn("not reloading config", "error", err)
+						continue
+					}
+					mu.Lock()
+					if newFlags.Backend != cflags.Backend {
+						slog.Warn("changing the backend needs a restart", "backend", cflags.Backend)
+						newFlags.Backend = cflags.Backend
+					}
+					if newFlags.Backlight != cflags.Backlight {
+						backlight = openDevice("backlight", NewBacklight, newFlags.Backlight)
+					}
+					if newFlags.Ambient != cflags.Ambient {
+						ambient = openDevice("ambient light sensor", NewAmbientLight, newFlags.Ambient)
+					}
+					if !newFlags.DDC.Equal(cflags.DDC) {
+						ddc = openDevice("DDC/CI monitors", NewDDC, newFlags.DDC)
+					}
+					cflags = newFlags
+					mu.Unlock()
+					setLogLevel(newFlags.Debug)
+					slog.Info("config reloaded")
+					doit()
+				}
+			}()
+		}
+		next := func(now time.Time) time.Time {
+			mu.Lock()
+			defer mu.Unlock()
+			active := cflags.WithPower(power)
+			// Wake up in time for ChangeOutput to check for drift, and
+			// to follow the ambient light like during transitions
+			wakeup := now.Add(driftCheckInterval)
+			if ambient != nil {
+				wakeup = now.Add(cmp.Or(active.LoopInterval, TransitionLoopInterval))
+			}
+			// and to write monitor brightness held back by rate limiting
+			if write := ddc.NextWrite(); write.After(now) && write.Before(wakeup) {
+				wakeup = write
+			}
+			if update := NextUpdate(active, now); update.Before(wakeup) {
+				wakeup = update
+			}
+			if !opts.until.IsZero() && opts.until.Before(wakeup) {
+				return opts.until
+			}
+			return wakeup
+		}
+		acpiEvent := func(e AcpiEvent) {
+			switch e.Kind {
+			case AcpiACPlugged:
+				setPower(PowerAC)
+			case AcpiACUnplugged:
+				setPower(PowerBattery)
+			case AcpiBrightnessUp, AcpiBrightnessDown:
+				mu.Lock()
+				b := backlight
+				mu.Unlock()
+				b.ManualChange()
+			}
+		}
+		events := opts.events
+		events.acpiEvent = acpiEvent
+		events.done = ctx.Done()
+		repeatUntilInterrupt(clock, doit, next, MaxLoopInterval, events, syscall.SIGINT, syscall.SIGTERM)
 	}
-	return 0
 }
 
 func main() {
@@ -126,12 +543,16 @@ func main() {
 		fmt.Println(Version)
 		os.Exit(0)
 	}
-	if cflags.Debug {
-		slog.SetLogLoggerLevel(slog.LevelDebug)
-	}
+	setLogLevel(cflags.Debug)
 	if err != nil {
 		slog.Error("Error in flags", "error", err)
 		os.Exit(1)
 	}
-	os.Exit(mainLoop(cflags))
+	if len(cflags.Command) > 0 {
+		os.Exit(runCommand(os.Args[0], cflags))
+	}
+	os.Exit(mainLoop(cflags, SystemClock{}, func() (Config, error) {
+		c, _, err := GetFlags(os.Args[0], os.Args[1:])
+		return c, err
+	}))
 }
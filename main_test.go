@@ -2,15 +2,36 @@ package main
 
 import (
 	"flag"
+	"fmt"
+	"os"
 	"strconv"
 	"strings"
 	"testing"
 	"time"
+	_ "time/tzdata"
 )
 
-//func TestMain(t *testing.T) {
-//	slog.SetDefault(slog.New(slog.NewTextHandler(io.Discard, nil)))
-//}
+// TestMain keeps the config file of the user running the tests out of
+// them, since GetFlags reads it. The expected sun times are those of the
+// default location, so the tests run in its time zone whatever TZ is.
+func TestMain(m *testing.M) {
+	//slog.SetDefault(slog.New(slog.NewTextHandler(io.Discard, nil)))
+	berlin, err := time.LoadLocation("Europe/Berlin")
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	time.Local = berlin
+	configHome, err := os.MkdirTemp("", "nerdshade-test")
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	os.Setenv("XDG_CONFIG_HOME", configHome)
+	code := m.Run()
+	os.RemoveAll(configHome)
+	os.Exit(code)
+}
 
 type roundFloatTestCase struct {
 	in        float64
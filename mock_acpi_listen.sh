@@ -6,7 +6,9 @@ output=(
 "video/brightnessdown BRTDN 00000087 00000000"
 "video/brightnessup BRTUP 00000086 00000000"
 "button/lid LID close"
+"ac_adapter ACPI0003:00 00000080 00000000"
 "button/lid LID open"
+"ac_adapter ACPI0003:00 00000080 00000001"
 "video/brightnessdown BRTDN 00000087 00000000"
 "wmi PNP0C14:05 000000d0 00000000"
 "video/brightnessup BRTUP 00000086 00000000"
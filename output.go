@@ -0,0 +1,171 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"slices"
+	"strings"
+	"time"
+)
+
+const (
+	DefaultBackend = "hyprsunset"
+	// reassertInterval is the time after which unchanged values are
+	// applied again anyway, e. g. for a restarted hyprsunset
+	reassertInterval = 10 * time.Minute
+	// driftCheckInterval is the time after which outputs that can read back
+	// their values are checked for values changed by someone else
+	driftCheckInterval = 2 * time.Minute
+)
+
+// Capabilities describes what an output backend is able to do
+type Capabilities struct {
+	// Temperature is true if the backend can change the color temperature
+	Temperature bool
+	// Gamma is true if the backend can change gamma
+	Gamma bool
+	// ReadBack is true if Current returns the values actually in effect,
+	// as opposed to only the values last applied by nerdshade.
+	ReadBack bool
+}
+
+// Output is something that can apply color temperature and gamma values to
+// the screen, e. g. hyprsunset.
+type Output interface {
+	// Name returns the backend name as used with the -backend flag
+	Name() string
+	// Apply sets temperature (in Kelvin) and gamma (in percent)
+	Apply(temperature, gamma int) error
+	// Current returns the temperature and gamma currently in effect
+	Current() (temperature, gamma int, err error)
+	// Capabilities reports what the backend supports
+	Capabilities() Capabilities
+	// Close releases all resources held by the backend
+	Close() error
+}
+
+type outputFactory func(cflags Config) (Output, error)
+
+var outputBackends = map[string]outputFactory{
+	"hyprsunset": NewHyprsunsetOutput,
+	"none":       NewNoneOutput,
+	"wlr":        NewWlrOutput,
+	"x11":        NewX11Output,
+}
+
+// BackendNames returns the sorted names of all available backends
+func BackendNames() []string {
+	names := make([]string, 0, len(outputBackends))
+	for name := range outputBackends {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	return names
+}
+
+// NewOutput creates the output backend selected in cflags
+func NewOutput(cflags Config) (Output, error) {
+	factory, ok := outputBackends[cflags.Backend]
+	if !ok {
+		return nil, fmt.Errorf("Unknown backend %q, must be one of: %s", cflags.Backend, strings.Join(BackendNames(), ", "))
+	}
+	out, err := factory(cflags)
+	if err != nil {
+		return nil, err
+	}
+	slog.Debug("output backend", "name", out.Name(), "capabilities", out.Capabilities())
+	return out, nil
+}
+
+// NoneOutput does not change anything, it only remembers the values
+// it was given. Useful for running nerdshade for its log output only.
+type NoneOutput struct {
+	temperature int
+	gamma       int
+}
+
+func NewNoneOutput(cflags Config) (Output, error) {
+	return &NoneOutput{}, nil
+}
+
+func (o *NoneOutput) Name() string {
+	return "none"
+}
+
+func (o *NoneOutput) Apply(temperature, gamma int) error {
+	slog.Debug("not applying", "temperature", temperature, "gamma", gamma)
+	o.temperature = temperature
+	o.gamma = gamma
+	return nil
+}
+
+func (o *NoneOutput) Current() (int, int, error) {
+	return o.temperature, o.gamma, nil
+}
+
+func (o *NoneOutput) Capabilities() Capabilities {
+	return Capabilities{Temperature: true, Gamma: true}
+}
+
+func (o *NoneOutput) Close() error {
+	return nil
+}
+
+// ChangeOutput passes values on to its output only when they changed since
+// the last apply. Unchanged values are applied again after
+// reassertInterval, or earlier if the output can read back its values and
+// reports different ones.
+type ChangeOutput struct {
+	Output
+	clock       Clock
+	valid       bool
+	temperature int
+	gamma       int
+	appliedAt   time.Time
+	checkedAt   time.Time
+	// Applied and Skipped count the updates passed on and left out
+	Applied int
+	Skipped int
+}
+
+func NewChangeOutput(out Output, clock Clock) *ChangeOutput {
+	return &ChangeOutput{Output: out, clock: clock}
+}
+
+func (o *ChangeOutput) Apply(temperature, gamma int) error {
+	now := o.clock.Now()
+	if o.unchanged(temperature, gamma, now) {
+		o.Skipped++
+		slog.Debug("values unchanged, not applying", "temperature", temperature, "gamma", gamma, "applied", o.Applied, "skipped", o.Skipped)
+		return nil
+	}
+	err := o.Output.Apply(temperature, gamma)
+	// After an error, the values in effect are unknown
+	o.valid = err == nil
+	o.temperature, o.gamma = temperature, gamma
+	o.appliedAt, o.checkedAt = now, now
+	o.Applied++
+	slog.Debug("applied values", "temperature", temperature, "gamma", gamma, "applied", o.Applied, "skipped", o.Skipped)
+	return err
+}
+
+// unchanged tells whether the values are known to be in effect already
+func (o *ChangeOutput) unchanged(temperature, gamma int, now time.Time) bool {
+	if !o.valid || temperature != o.temperature || gamma != o.gamma {
+		return false
+	}
+	// now before the last apply means the wall clock was set back
+	if now.Before(o.appliedAt) || now.Sub(o.appliedAt) >= reassertInterval {
+		slog.Debug("reasserting values", "last", o.appliedAt)
+		return false
+	}
+	if o.Capabilities().ReadBack && now.Sub(o.checkedAt) >= driftCheckInterval {
+		o.checkedAt = now
+		current, currentGamma, err := o.Output.Current()
+		if err != nil || current != temperature || currentGamma != gamma {
+			slog.Info("values were changed outside of nerdshade, applying again", "temperature", current, "gamma", currentGamma, "err", err)
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestNewOutput(t *testing.T) {
+	// Only backends that do not need a running graphical session
+	for _, name := range []string{"hyprsunset", "none"} {
+		t.Run(name, func(t *testing.T) {
+			out, err := NewOutput(Config{Backend: name})
+			if err != nil {
+				t.Fatalf("Got error %v", err)
+			}
+			defer out.Close()
+			if out.Name() != name {
+				t.Errorf("Got backend %q instead of %q", out.Name(), name)
+			}
+		})
+	}
+	t.Run("unknown backend", func(t *testing.T) {
+		if _, err := NewOutput(Config{Backend: "foo"}); err == nil {
+			t.Errorf("Expected error, got nil")
+		}
+	})
+}
+
+func TestHyprsunsetOutput(t *testing.T) {
+	out, _ := NewHyprsunsetOutput(Config{HyprctlCmd: MockHyprctl})
+	if err := out.Apply(5000, 95); err != nil {
+		t.Errorf("Got error %v", err)
+	}
+	temperature, gamma, err := out.Current()
+	if err != nil {
+		t.Errorf("Got error %v", err)
+	}
+	if temperature != 6500 || gamma != 100 {
+		t.Errorf("Got %d/%d instead of 6500/100", temperature, gamma)
+	}
+}
+
+func TestNoneOutput(t *testing.T) {
+	out, _ := NewNoneOutput(Config{})
+	out.Apply(4000, 90)
+	temperature, gamma, _ := out.Current()
+	if temperature != 4000 || gamma != 90 {
+		t.Errorf("Got %d/%d instead of 4000/90", temperature, gamma)
+	}
+}
+
+func TestChangeOutput(t *testing.T) {
+	clock := &fakeClock{time.Date(2025, time.April, 15, 12, 0, 0, 0, time.UTC)}
+	backend := &recordingOutput{}
+	out := NewChangeOutput(backend, clock)
+	step := func(d time.Duration, temperature, gamma int) {
+		t.Helper()
+		clock.now = clock.now.Add(d)
+		if err := out.Apply(temperature, gamma); err != nil {
+			t.Fatalf("Got error %v", err)
+		}
+	}
+	step(0, 5000, 95)
+	step(30*time.Second, 5000, 95)
+	step(30*time.Second, 5000, 95)
+	if len(backend.applied) != 1 || out.Applied != 1 || out.Skipped != 2 {
+		t.Errorf("Got %d applied (%d/%d) instead of 1 for unchanged values", len(backend.applied), out.Applied, out.Skipped)
+	}
+	step(30*time.Second, 5100, 95)
+	if len(backend.applied) != 2 {
+		t.Errorf("Changed values were not applied")
+	}
+	// Someone else changed the values, noticed at the next drift check
+	backend.temperature = 6000
+	step(30*time.Second, 5100, 95)
+	if len(backend.applied) != 2 {
+		t.Errorf("Drift was checked too early")
+	}
+	step(driftCheckInterval, 5100, 95)
+	if len(backend.applied) != 3 {
+		t.Errorf("Drift was not corrected")
+	}
+	// The wall clock was set back
+	step(-time.Hour, 5100, 95)
+	if len(backend.applied) != 4 {
+		t.Errorf("Values were not applied after the clock was set back")
+	}
+}
+
+// writeOnlyOutput can not read back its values
+type writeOnlyOutput struct {
+	recordingOutput
+}
+
+func (o *writeOnlyOutput) Capabilities() Capabilities {
+	return Capabilities{Temperature: true, Gamma: true}
+}
+
+func TestChangeOutputReassert(t *testing.T) {
+	clock := &fakeClock{time.Date(2025, time.April, 15, 12, 0, 0, 0, time.UTC)}
+	backend := &writeOnlyOutput{}
+	out := NewChangeOutput(backend, clock)
+	for range 2 * int(reassertInterval/(30*time.Second)) {
+		out.Apply(6500, 100)
+		clock.now = clock.now.Add(30 * time.Second)
+	}
+	if len(backend.applied) != 2 {
+		t.Errorf("Got %d applies instead of 2 in two reassert intervals", len(backend.applied))
+	}
+}
+
+// failingOutput fails to apply values
+type failingOutput struct {
+	NoneOutput
+	calls int
+}
+
+func (o *failingOutput) Apply(temperature, gamma int) error {
+	o.calls++
+	return errors.New("hyprsunset not running")
+}
+
+func TestChangeOutputRetriesAfterError(t *testing.T) {
+	clock := &fakeClock{time.Date(2025, time.April, 15, 12, 0, 0, 0, time.UTC)}
+	backend := &failingOutput{}
+	out := NewChangeOutput(backend, clock)
+	out.Apply(6500, 100)
+	out.Apply(6500, 100)
+	if backend.calls != 2 {
+		t.Errorf("Got %d calls instead of 2", backend.calls)
+	}
+}
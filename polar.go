@@ -0,0 +1,100 @@
+package main
+
+import (
+	"log/slog"
+	"sync"
+	"time"
+)
+
+const (
+	// sunriseElevation is the elevation of the sun's center at sunrise and
+	// sunset, as used by go-sunrise (accounting for refraction and radius)
+	sunriseElevation = -0.833
+)
+
+// Polar is set when the sun does not rise or set on a given day
+type Polar string
+
+const (
+	PolarNone  Polar = ""
+	PolarDay   Polar = "polar day"
+	PolarNight Polar = "polar night"
+)
+
+// PolarCondition tells whether there is polar day or polar night on the day
+// of when, given the sunrise and sunset times calculated for that day.
+// go-sunrise returns zero times if the sun does not rise or set, in which
+// case the elevation at solar noon decides.
+func PolarCondition(when, rise, set time.Time, latitude, longitude float64) Polar {
+	if !rise.IsZero() && !set.IsZero() {
+		return PolarNone
+	}
+	noon := time.Date(when.Year(), when.Month(), when.Day(), 12, 0, 0, 0, time.UTC).
+		Add(-time.Duration(longitude / 15 * float64(time.Hour)))
+	if SolarElevation(noon, latitude, longitude) > sunriseElevation {
+		return PolarDay
+	}
+	return PolarNight
+}
+
+// Source describes how brightness is calculated at a given time
+type Source struct {
+	// Kind is "schedule", "elevation" or "location"
+	Kind string
+	// Schedule is set for Kind "schedule"
+	Schedule *Schedule
+	// Polar is set if Kind is a fallback for polar day or night
+	Polar Polar
+}
+
+// lastPolar remembers the polar condition last logged about, so the fallback
+// is logged once when it starts and not on every update.
+var lastPolar struct {
+	sync.Mutex
+	polar Polar
+}
+
+func logPolar(polar Polar, source Source) {
+	lastPolar.Lock()
+	defer lastPolar.Unlock()
+	if polar == lastPolar.polar {
+		return
+	}
+	lastPolar.polar = polar
+	if polar == PolarNone {
+		slog.Info("sun rises and sets again, using sunrise and sunset")
+		return
+	}
+	slog.Info("sun does not rise or set", "reason", polar, "fallback", source.Kind)
+}
+
+// GetSource decides how brightness is calculated at the given time, depending
+// on which flags are present in cflags. When using the location and the sun
+// does not rise or set (polar day or night), it falls back to the fixed
+// -polarSchedule or, if not given, to the sun elevation.
+func GetSource(cflags Config, when time.Time) (Source, error) {
+	if cflags.Schedule != nil {
+		return Source{Kind: "schedule", Schedule: cflags.Schedule}, nil
+	}
+	if cflags.Wakeup != "" {
+		return Source{Kind: "schedule", Schedule: DailySchedule(cflags.Wakeup, cflags.Bedtime)}, nil
+	}
+	if cflags.Elevation {
+		return Source{Kind: "elevation"}, nil
+	}
+	rise, set := LocalTimes(when, cflags.Latitude, cflags.Longitude)
+	polar := PolarCondition(when, rise, set, cflags.Latitude, cflags.Longitude)
+	source := Source{Kind: "location"}
+	if polar != PolarNone {
+		source = Source{Kind: "elevation", Polar: polar}
+		if cflags.PolarSchedule != "" {
+			wakeup, bedtime, err := ParseSchedule(cflags.PolarSchedule)
+			if err != nil {
+				return source, err
+			}
+			source = Source{Kind: "schedule", Schedule: DailySchedule(wakeup, bedtime), Polar: polar}
+		}
+	}
+	logPolar(polar, source)
+	return source, nil
+}
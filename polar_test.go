@@ -0,0 +1,121 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+const (
+	tromsoLatitude        = 69.65
+	tromsoLongitude       = 18.96
+	longyearbyenLatitude  = 78.22
+	longyearbyenLongitude = 15.65
+)
+
+type PolarConditionTestCase struct {
+	latitude  float64
+	longitude float64
+	month     time.Month
+	day       int
+	expected  Polar
+}
+
+func TestPolarCondition(t *testing.T) {
+	tests := map[string]PolarConditionTestCase{
+		"Tromsø, early January":        {tromsoLatitude, tromsoLongitude, time.January, 5, PolarNight},
+		"Tromsø, late January":         {tromsoLatitude, tromsoLongitude, time.January, 20, PolarNone},
+		"Tromsø, March":                {tromsoLatitude, tromsoLongitude, time.March, 15, PolarNone},
+		"Tromsø, late May":             {tromsoLatitude, tromsoLongitude, time.May, 25, PolarDay},
+		"Tromsø, June":                 {tromsoLatitude, tromsoLongitude, time.June, 15, PolarDay},
+		"Tromsø, September":            {tromsoLatitude, tromsoLongitude, time.September, 15, PolarNone},
+		"Tromsø, December":             {tromsoLatitude, tromsoLongitude, time.December, 15, PolarNight},
+		"Longyearbyen, February":       {longyearbyenLatitude, longyearbyenLongitude, time.February, 1, PolarNight},
+		"Longyearbyen, March":          {longyearbyenLatitude, longyearbyenLongitude, time.March, 15, PolarNone},
+		"Longyearbyen, April":          {longyearbyenLatitude, longyearbyenLongitude, time.April, 25, PolarDay},
+		"Longyearbyen, August":         {longyearbyenLatitude, longyearbyenLongitude, time.August, 15, PolarDay},
+		"Longyearbyen, October":        {longyearbyenLatitude, longyearbyenLongitude, time.October, 30, PolarNight},
+		"Berlin, June":                 {DefaultLatitude, DefaultLongitude, time.June, 21, PolarNone},
+		"Berlin, December":             {DefaultLatitude, DefaultLongitude, time.December, 21, PolarNone},
+		"McMurdo, December (southern)": {-77.85, 166.67, time.December, 21, PolarDay},
+		"McMurdo, June (southern)":     {-77.85, 166.67, time.June, 21, PolarNight},
+	}
+	for label, test := range tests {
+		t.Run(label, func(t *testing.T) {
+			when := time.Date(2025, test.month, test.day, 12, 0, 0, 0, time.UTC)
+			rise, set := LocalTimes(when, test.latitude, test.longitude)
+			result := PolarCondition(when, rise, set, test.latitude, test.longitude)
+			if result != test.expected {
+				t.Errorf("Got %q instead of %q", result, test.expected)
+			}
+		})
+	}
+}
+
+type PolarBrightnessTestCase struct {
+	t             time.Time
+	polarSchedule string
+	source        string
+	expected      float64
+}
+
+func TestGetBrightnessPolar(t *testing.T) {
+	cet, _ := time.LoadLocation("CET")
+	tests := map[string]PolarBrightnessTestCase{
+		// Without a sunrise, brightness used to be stuck at 0.0
+		"polar day, midnight": {
+			time.Date(2025, time.June, 15, 0, 0, 0, 0, cet), "", "elevation", 1.0,
+		},
+		"polar day, noon": {
+			time.Date(2025, time.June, 15, 12, 0, 0, 0, cet), "", "elevation", 1.0,
+		},
+		// The sun stays about 3 degrees below the horizon at noon
+		"polar night, noon": {
+			time.Date(2025, time.December, 21, 11, 45, 0, 0, cet), "", "elevation", 0.323,
+		},
+		"polar night, midnight": {
+			time.Date(2025, time.December, 21, 0, 0, 0, 0, cet), "", "elevation", 0.0,
+		},
+		"polar night, schedule": {
+			time.Date(2025, time.December, 21, 12, 0, 0, 0, cet), "7:00-22:00", "schedule", 1.0,
+		},
+		"polar day, schedule": {
+			time.Date(2025, time.June, 15, 23, 0, 0, 0, cet), "7:00-22:00", "schedule", 0.0,
+		},
+		"no polar day, schedule ignored": {
+			time.Date(2025, time.March, 15, 23, 0, 0, 0, cet), "7:00-22:00", "location", 0.0,
+		},
+	}
+	for label, test := range tests {
+		t.Run(label, func(t *testing.T) {
+			cflags := statusTestConfig()
+			cflags.Latitude, cflags.Longitude = tromsoLatitude, tromsoLongitude
+			cflags.ElevationNight = DefaultElevationNight
+			cflags.ElevationDay = DefaultElevationDay
+			cflags.PolarSchedule = test.polarSchedule
+			brightness, err := GetBrightness(cflags, test.t)
+			if err != nil {
+				t.Fatalf("Got error %v", err)
+			}
+			if brightness != test.expected {
+				t.Errorf("Got %f instead of %f", brightness, test.expected)
+			}
+			s, err := GetStatus(cflags, test.t)
+			if err != nil {
+				t.Fatalf("Got error %v", err)
+			}
+			if s.Source != test.source {
+				t.Errorf("Got source %s instead of %s", s.Source, test.source)
+			}
+			if (s.Polar != PolarNone) != (test.source != "location") {
+				t.Errorf("Got polar %q with source %s", s.Polar, s.Source)
+			}
+		})
+	}
+}
+
+func TestPolarScheduleFlag(t *testing.T) {
+	_, _, err := GetFlags("foo", []string{"-polarSchedule", "7:00"})
+	if err == nil {
+		t.Errorf("Expected error, got nil")
+	}
+}
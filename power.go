@@ -0,0 +1,152 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const (
+	powerSupplyDir = "/sys/class/power_supply"
+	// PowerAuto selects the profile by the current power source
+	PowerAuto = "auto"
+)
+
+// PowerSource is what the machine currently runs on
+type PowerSource string
+
+const (
+	PowerAC      PowerSource = "ac"
+	PowerBattery PowerSource = "battery"
+)
+
+// PowerProfile holds settings replacing the flags while running on a power
+// source. Zero values keep the flag values.
+type PowerProfile struct {
+	NightTemp    int
+	NightGamma   int
+	LoopInterval time.Duration
+}
+
+// ReadPowerSource looks at the power supplies in dir (usually
+// /sys/class/power_supply). It returns PowerBattery only if there is an
+// adapter and none of them is online, so machines without adapter
+// information count as running on AC.
+func ReadPowerSource(dir string) PowerSource {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return PowerAC
+	}
+	adapters := 0
+	for _, e := range entries {
+		read := func(name string) string {
+			b, _ := os.ReadFile(filepath.Join(dir, e.Name(), name))
+			return strings.TrimSpace(string(b))
+		}
+		switch read("type") {
+		case "Mains", "USB":
+			adapters++
+			if read("online") == "1" {
+				return PowerAC
+			}
+		}
+	}
+	if adapters == 0 {
+		return PowerAC
+	}
+	return PowerBattery
+}
+
+func validatePower(value string) error {
+	switch value {
+	case PowerAuto, string(PowerAC), string(PowerBattery):
+		return nil
+	}
+	return fmt.Errorf("Unknown power profile %q", value)
+}
+
+func validateLoopInterval(value string) error {
+	d, err := time.ParseDuration(value)
+	if err != nil {
+		return err
+	}
+	if d <= 0 {
+		return errors.New("needs to be positive")
+	}
+	return nil
+}
+
+// WithPower returns the config with the profile for source applied. If a
+// profile was chosen with -power, source is ignored.
+func (c Config) WithPower(source PowerSource) Config {
+	if c.Power != "" && c.Power != PowerAuto {
+		source = PowerSource(c.Power)
+	}
+	p, ok := c.PowerProfiles[source]
+	if !ok {
+		return c
+	}
+	if p.NightTemp != 0 {
+		c.NightTemp = p.NightTemp
+	}
+	if p.NightGamma != 0 {
+		c.NightGamma = p.NightGamma
+	}
+	if p.LoopInterval != 0 {
+		c.LoopInterval = p.LoopInterval
+	}
+	return c
+}
+
+// PowerProfilesFromConfig reads the [ac] and [battery] sections, e. g.
+//
+//	[battery]
+//	gammaNight = 80
+//	loopInterval = "1m"
+func PowerProfilesFromConfig(doc *tomlDoc) (map[PowerSource]PowerProfile, error) {
+	profiles := map[PowerSource]PowerProfile{}
+	for _, source := range []PowerSource{PowerAC, PowerBattery} {
+		for _, t := range doc.Tables(string(source)) {
+			p := profiles[source]
+			for _, v := range t.values {
+				fail := func(err error) (map[PowerSource]PowerProfile, error) {
+					return nil, &ConfigError{doc.file, v.line, v.key, err}
+				}
+				switch v.key {
+				case "tempNight", "gammaNight":
+					i, ok := v.value.(int64)
+					if !ok {
+						return fail(errors.New("needs to be an integer"))
+					}
+					var err error
+					if v.key == "tempNight" {
+						p.NightTemp = int(i)
+						err = isBetween(p.NightTemp, 1000, 20000)
+					} else {
+						p.NightGamma = int(i)
+						err = isBetween(p.NightGamma, 0, 100)
+					}
+					if err != nil {
+						return fail(err)
+					}
+				case "loopInterval":
+					s, ok := v.value.(string)
+					if !ok {
+						return fail(errors.New("needs to be a string"))
+					}
+					if err := validateLoopInterval(s); err != nil {
+						return fail(err)
+					}
+					p.LoopInterval, _ = time.ParseDuration(s)
+				default:
+					return fail(errors.New("unknown setting"))
+				}
+			}
+			profiles[source] = p
+		}
+	}
+	return profiles, nil
+}
@@ -0,0 +1,209 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// fakePowerSupplies creates a /sys/class/power_supply like directory. Keys
+// are supply names, values their type and online state ("" for none).
+func fakePowerSupplies(t *testing.T, supplies map[string][2]string) string {
+	t.Helper()
+	dir := t.TempDir()
+	for name, attrs := range supplies {
+		supply := filepath.Join(dir, name)
+		if err := os.Mkdir(supply, 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(supply, "type"), []byte(attrs[0]+"\n"), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		if attrs[1] == "" {
+			continue
+		}
+		if err := os.WriteFile(filepath.Join(supply, "online"), []byte(attrs[1]+"\n"), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir
+}
+
+type ReadPowerSourceTestCase struct {
+	supplies map[string][2]string
+	expected PowerSource
+}
+
+func TestReadPowerSource(t *testing.T) {
+	tests := map[string]ReadPowerSourceTestCase{
+		"plugged": {
+			map[string][2]string{"AC": {"Mains", "1"}, "BAT0": {"Battery", ""}},
+			PowerAC,
+		},
+		"unplugged": {
+			map[string][2]string{"AC": {"Mains", "0"}, "BAT0": {"Battery", ""}},
+			PowerBattery,
+		},
+		"usb-c plugged": {
+			map[string][2]string{"AC": {"Mains", "0"}, "ucsi-source-psy-USBC000:001": {"USB", "1"}, "BAT0": {"Battery", ""}},
+			PowerAC,
+		},
+		"no adapter": {
+			map[string][2]string{"BAT0": {"Battery", ""}},
+			PowerAC,
+		},
+		"desktop": {
+			map[string][2]string{},
+			PowerAC,
+		},
+	}
+	for label, test := range tests {
+		t.Run(label, func(t *testing.T) {
+			if got := ReadPowerSource(fakePowerSupplies(t, test.supplies)); got != test.expected {
+				t.Errorf("Got %q instead of %q", got, test.expected)
+			}
+		})
+	}
+	t.Run("missing", func(t *testing.T) {
+		if got := ReadPowerSource(filepath.Join(t.TempDir(), "missing")); got != PowerAC {
+			t.Errorf("Got %q instead of %q", got, PowerAC)
+		}
+	})
+}
+
+type WithPowerTestCase struct {
+	power        string
+	source       PowerSource
+	nightTemp    int
+	nightGamma   int
+	loopInterval time.Duration
+}
+
+func TestWithPower(t *testing.T) {
+	cflags := Config{
+		NightTemp:    4000,
+		NightGamma:   90,
+		LoopInterval: TransitionLoopInterval,
+		PowerProfiles: map[PowerSource]PowerProfile{
+			PowerBattery: {NightGamma: 80, LoopInterval: time.Minute},
+			PowerAC:      {NightTemp: 3500},
+		},
+	}
+	tests := map[string]WithPowerTestCase{
+		"auto on battery":  {PowerAuto, PowerBattery, 4000, 80, time.Minute},
+		"auto on ac":       {PowerAuto, PowerAC, 3500, 90, TransitionLoopInterval},
+		"forced battery":   {"battery", PowerAC, 4000, 80, time.Minute},
+		"forced ac":        {"ac", PowerBattery, 3500, 90, TransitionLoopInterval},
+		"unset means auto": {"", PowerBattery, 4000, 80, time.Minute},
+	}
+	for label, test := range tests {
+		t.Run(label, func(t *testing.T) {
+			cflags := cflags
+			cflags.Power = test.power
+			got := cflags.WithPower(test.source)
+			if got.NightTemp != test.nightTemp || got.NightGamma != test.nightGamma || got.LoopInterval != test.loopInterval {
+				t.Errorf("Got %d/%d/%s instead of %d/%d/%s", got.NightTemp, got.NightGamma, got.LoopInterval,
+					test.nightTemp, test.nightGamma, test.loopInterval)
+			}
+		})
+	}
+	t.Run("no profiles", func(t *testing.T) {
+		got := Config{NightTemp: 4000, Power: PowerAuto}.WithPower(PowerBattery)
+		if got.NightTemp != 4000 {
+			t.Errorf("Got %d instead of 4000", got.NightTemp)
+		}
+	})
+}
+
+// Without ACPI events, the loop notices unplugging by reading the power
+// supplies again
+func TestRunLoopReadsPower(t *testing.T) {
+	dir := fakePowerSupplies(t, map[string][2]string{"AC": {"Mains", "1"}})
+	cflags := simulateTestConfig()
+	cflags.Loop = true
+	cflags.PowerProfiles = map[PowerSource]PowerProfile{PowerBattery: {NightGamma: 80}}
+	from := time.Date(2025, time.April, 15, 0, 0, 0, 0, time.Local)
+	// one hour in 36ms
+	clock := NewScaledClock(SystemClock{}, from, 100000)
+	var gammas []int
+	runLoop(context.Background(), cflags, &NoneOutput{}, clock, loopOptions{
+		powerDir: dir,
+		until:    from.Add(time.Hour),
+		applied: func(active Config, now time.Time) {
+			gammas = append(gammas, active.NightGamma)
+			if len(gammas) == 1 {
+				err := os.WriteFile(filepath.Join(dir, "AC", "online"), []byte("0\n"), 0o644)
+				if err != nil {
+					t.Error(err)
+				}
+			}
+		},
+	})
+	if len(gammas) < 2 || gammas[0] != 90 || gammas[len(gammas)-1] != 80 {
+		t.Errorf("Got night gamma %v instead of 90 on AC, then 80 on battery", gammas)
+	}
+}
+
+func TestPowerProfilesFromConfig(t *testing.T) {
+	writeConfig(t, `
+power = "battery"
+
+[battery]
+tempNight = 3000
+gammaNight = 80
+loopInterval = "1m"
+
+[ac]
+gammaNight = 95
+`)
+	c, _, err := GetFlags("foo", []string{})
+	if err != nil {
+		t.Fatalf("Got error %v", err)
+	}
+	if c.Power != "battery" {
+		t.Errorf("Got power %q instead of battery", c.Power)
+	}
+	expected := map[PowerSource]PowerProfile{
+		PowerBattery: {3000, 80, time.Minute},
+		PowerAC:      {0, 95, 0},
+	}
+	if len(c.PowerProfiles) != len(expected) || c.PowerProfiles[PowerBattery] != expected[PowerBattery] || c.PowerProfiles[PowerAC] != expected[PowerAC] {
+		t.Errorf("Got %v instead of %v", c.PowerProfiles, expected)
+	}
+}
+
+func TestPowerProfilesFromConfigErrors(t *testing.T) {
+	tests := map[string]ConfigFileErrorTestCase{
+		"unknown power": {
+			"power = \"solar\"",
+			"config.toml:1: power: Unknown power profile \"solar\"",
+		},
+		"temperature out of range": {
+			"[battery]\ntempNight = 200",
+			"config.toml:2: tempNight: Value (200) must be >=1000 and <=20000",
+		},
+		"gamma not an integer": {
+			"[ac]\ngammaNight = \"dim\"",
+			"config.toml:2: gammaNight: needs to be an integer",
+		},
+		"invalid interval": {
+			"[battery]\nloopInterval = \"-1m\"",
+			"config.toml:2: loopInterval: needs to be positive",
+		},
+		"unknown key": {
+			"[battery]\ntempDay = 5000",
+			"config.toml:2: tempDay: unknown setting",
+		},
+	}
+	for label, test := range tests {
+		t.Run(label, func(t *testing.T) {
+			path := writeConfig(t, test.content)
+			_, _, err := GetFlags("foo", []string{})
+			if err == nil || err.Error() != filepath.Dir(path)+"/"+test.expected {
+				t.Errorf("Got error %v instead of %s", err, test.expected)
+			}
+		})
+	}
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"time"
+)
+
+const DefaultPreviewDuration = 20 * time.Second
+
+// Preview plays the day starting at from on out, compressed into duration,
+// by running the loop with a ScaledClock. The values are printed to w every
+// hour of the simulated day. It stops early when ctx is done.
+// Only the screen is previewed, not the backlight or external monitors.
+func Preview(ctx context.Context, cflags Config, out Output, from time.Time, duration time.Duration, w io.Writer) error {
+	end := from.AddDate(0, 0, 1)
+	clock := NewScaledClock(SystemClock{}, from, float64(end.Sub(from))/float64(duration))
+	previewFlags := cflags
+	previewFlags.Loop = true
+	previewFlags.Waybar = false
+	previewFlags.Backlight = BacklightSettings{}
+	previewFlags.Ambient = AmbientSettings{}
+	previewFlags.DDC = DDCSettings{}
+	var printed time.Time
+	runLoop(ctx, previewFlags, out, clock, loopOptions{
+		powerDir: powerSupplyDir,
+		until:    end,
+		applied: func(active Config, now time.Time) {
+			if hour := now.Truncate(time.Hour); !hour.Equal(printed) {
+				printed = hour
+				brightness, temperature, gamma, _ := GetValues(active, now)
+				fmt.Fprintf(w, "%s  %.3f  %dK  %d%%\n", now.Format("15:04"), brightness, temperature, gamma)
+			}
+		},
+	})
+	return ctx.Err()
+}
+
+// PreviewAndRestore runs Preview and afterwards restores the values out had
+// before, if out can tell them. Other outputs restore the original values
+// when closed.
+func PreviewAndRestore(ctx context.Context, cflags Config, out Output, from time.Time, duration time.Duration, w io.Writer) error {
+	restore := func() error { return nil }
+	if out.Capabilities().ReadBack {
+		temperature, gamma, err := out.Current()
+		if err != nil {
+			slog.Warn("current values could not be read, not restoring them", "backend", out.Name(), "err", err)
+		} else {
+			restore = func() error {
+				slog.Debug("restoring values", "temperature", temperature, "gamma", gamma)
+				return out.Apply(temperature, gamma)
+			}
+		}
+	}
+	err := Preview(ctx, cflags, out, from, duration, w)
+	if errors.Is(err, context.Canceled) {
+		err = nil
+	}
+	return errors.Join(err, restore())
+}
+
+// RunPreview handles the "preview" subcommand. Without --from, the preview
+// starts at midnight of the day of when.
+func RunPreview(progname string, cflags Config, args []string, when time.Time, w io.Writer) (string, error) {
+	var out bytes.Buffer
+	flags := flag.NewFlagSet(progname+" preview", flag.ContinueOnError)
+	flags.SetOutput(&out)
+	duration := flags.Duration("duration", DefaultPreviewDuration, "Real time the preview of one day takes")
+	fromValue := flags.String("from", "", "Start of the previewed day, e. g. \"2026-12-21T00:00\" (default: today 00:00)")
+	err := flags.Parse(args)
+	if err != nil {
+		return out.String(), err
+	}
+	if *duration <= 0 {
+		return "", errors.New("Duration needs to be positive")
+	}
+	from := time.Date(when.Year(), when.Month(), when.Day(), 0, 0, 0, 0, when.Location())
+	if *fromValue != "" {
+		if from, err = parseSimulationTime(*fromValue); err != nil {
+			return "", err
+		}
+	}
+	// Outputs only restore the original values on Close in loop mode
+	previewFlags := cflags
+	previewFlags.Loop = true
+	output, err := NewOutput(previewFlags)
+	if err != nil {
+		return "", err
+	}
+	defer output.Close()
+	// The loop stops on SIGINT and SIGTERM, so the values are restored
+	return "", PreviewAndRestore(context.Background(), cflags, output, from, *duration, w)
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+// recordingOutput remembers all values applied and can read them back
+type recordingOutput struct {
+	NoneOutput
+	applied [][2]int
+}
+
+func (o *recordingOutput) Apply(temperature, gamma int) error {
+	o.applied = append(o.applied, [2]int{temperature, gamma})
+	return o.NoneOutput.Apply(temperature, gamma)
+}
+
+func (o *recordingOutput) Capabilities() Capabilities {
+	return Capabilities{Temperature: true, Gamma: true, ReadBack: true}
+}
+
+func TestPreviewAndRestore(t *testing.T) {
+	out := &recordingOutput{NoneOutput: NoneOutput{temperature: 5000, gamma: 80}}
+	var w bytes.Buffer
+	from := time.Date(2025, time.April, 15, 0, 0, 0, 0, time.Local)
+	err := PreviewAndRestore(context.Background(), simulateTestConfig(), out, from, 50*time.Millisecond, &w)
+	if err != nil {
+		t.Fatalf("Got error %v", err)
+	}
+	if len(out.applied) < 3 {
+		t.Fatalf("Got only %d values applied", len(out.applied))
+	}
+	if first := out.applied[0]; first != [2]int{4000, 90} {
+		t.Errorf("Got %v at midnight", first)
+	}
+	// The day is played completely, then the old values are restored
+	sawDay := false
+	for _, v := range out.applied {
+		sawDay = sawDay || v == [2]int{6500, 100}
+	}
+	if !sawDay {
+		t.Errorf("Day values were never applied: %v", out.applied)
+	}
+	if last := out.applied[len(out.applied)-1]; last != [2]int{5000, 80} {
+		t.Errorf("Got %v instead of restored values", last)
+	}
+	// the clock starts before the loop does, so the first minutes are not
+	// reliable, but the preview ends exactly at midnight
+	lines := strings.Split(strings.TrimSpace(w.String()), "\n")
+	if !strings.HasPrefix(lines[0], "00:") || !strings.HasSuffix(lines[0], "  0.000  4000K  90%") ||
+		lines[len(lines)-1] != "00:00  0.000  4000K  90%" {
+		t.Errorf("Got\n%s", w.String())
+	}
+}
+
+func TestPreviewCanceled(t *testing.T) {
+	out := &recordingOutput{NoneOutput: NoneOutput{temperature: 5000, gamma: 80}}
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	from := time.Date(2025, time.April, 15, 0, 0, 0, 0, time.Local)
+	start := time.Now()
+	err := PreviewAndRestore(ctx, simulateTestConfig(), out, from, time.Hour, &bytes.Buffer{})
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Got error %v", err)
+	}
+	if time.Since(start) > time.Second {
+		t.Errorf("Preview was not stopped")
+	}
+	if last := out.applied[len(out.applied)-1]; last != [2]int{5000, 80} {
+		t.Errorf("Got %v instead of restored values", last)
+	}
+}
+
+func TestRunPreview(t *testing.T) {
+	cflags := simulateTestConfig()
+	cflags.Backend = "none"
+	when := time.Date(2025, time.April, 15, 15, 0, 0, 0, time.Local)
+	var w bytes.Buffer
+	if _, err := RunPreview("foo", cflags, []string{"--duration", "20ms", "--from", "2025-04-15T12:00"}, when, &w); err != nil {
+		t.Fatalf("Got error %v", err)
+	}
+	// the preview runs a day in 20ms, so only the values are reliable, not
+	// the minutes
+	lines := strings.Split(w.String(), "\n")
+	if !strings.HasSuffix(lines[0], "  1.000  6500K  100%") ||
+		!strings.Contains(w.String(), "  0.000  4000K  90%\n") {
+		t.Errorf("Got\n%s", w.String())
+	}
+	if _, err := RunPreview("foo", cflags, []string{"--duration", "0s"}, when, &w); err == nil {
+		t.Errorf("Expected error, got nil")
+	}
+}
@@ -0,0 +1,273 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// ScheduleEntry is a wakeup and bedtime pair for one day
+type ScheduleEntry struct {
+	Wakeup  string
+	Bedtime string
+	// Origin tells where the entry comes from, e. g. "weekend" or the name
+	// of an exception
+	Origin string
+}
+
+// ScheduleException overrides the weekly schedule for all dates from From
+// to To, both inclusive and in the form "2006-01-02".
+type ScheduleException struct {
+	From string
+	To   string
+	ScheduleEntry
+}
+
+// Schedule holds fixed wakeup and bedtime times, which may vary by weekday
+// and date.
+type Schedule struct {
+	// Wakeup and Bedtime apply to all days without other entry
+	Wakeup  string
+	Bedtime string
+	// Weekdays holds entries for single weekdays, indexed by time.Weekday.
+	// Entries with empty Wakeup are not set.
+	Weekdays [7]ScheduleEntry
+	// Exceptions win over Weekdays. If several exceptions match a date, the
+	// last one wins.
+	Exceptions []ScheduleException
+}
+
+// DailySchedule returns a schedule using the same times every day
+func DailySchedule(wakeup, bedtime string) *Schedule {
+	return &Schedule{Wakeup: wakeup, Bedtime: bedtime}
+}
+
+// On returns the entry for the day of when
+func (s *Schedule) On(when time.Time) ScheduleEntry {
+	date := when.Format(time.DateOnly)
+	for i := len(s.Exceptions) - 1; i >= 0; i-- {
+		if e := s.Exceptions[i]; date >= e.From && date <= e.To {
+			return e.ScheduleEntry
+		}
+	}
+	if e := s.Weekdays[when.Weekday()]; e.Wakeup != "" {
+		return e
+	}
+	return ScheduleEntry{s.Wakeup, s.Bedtime, "default"}
+}
+
+// Times returns wakeup and bedtime starting on the day of when, date
+// completed. A bedtime before wakeup means bedtime is on the following day,
+// e. g. "9:00" and "1:30".
+func (s *Schedule) Times(when time.Time) (rise, set time.Time, err error) {
+	e := s.On(when)
+	wakeupHour, wakeupMinute, err := ParseHourMinute(e.Wakeup)
+	if err != nil {
+		return
+	}
+	bedtimeHour, bedtimeMinute, err := ParseHourMinute(e.Bedtime)
+	if err != nil {
+		return
+	}
+	bedtimeDay := when.Day()
+	if bedtimeHour*60+bedtimeMinute < wakeupHour*60+wakeupMinute {
+		bedtimeDay++
+	}
+	rise = time.Date(when.Year(), when.Month(), when.Day(), wakeupHour, wakeupMinute, 0, 0, when.Location())
+	set = time.Date(when.Year(), when.Month(), bedtimeDay, bedtimeHour, bedtimeMinute, 0, 0, when.Location())
+	return
+}
+
+// ParseSchedule splits a schedule of the form "7:00-22:00" into wakeup and
+// bedtime and checks both.
+func ParseSchedule(schedule string) (wakeup, bedtime string, err error) {
+	wakeup, bedtime, found := strings.Cut(schedule, "-")
+	if !found {
+		return "", "", fmt.Errorf("Schedule malformed, needs to be of the form \"HH:MM-HH:MM\"")
+	}
+	wakeup, bedtime = strings.TrimSpace(wakeup), strings.TrimSpace(bedtime)
+	if _, _, err = ParseHourMinute(wakeup); err != nil {
+		return
+	}
+	_, _, err = ParseHourMinute(bedtime)
+	return
+}
+
+func validateSchedule(value string) error {
+	if value == "" {
+		return nil
+	}
+	_, _, err := ParseSchedule(value)
+	return err
+}
+
+// scheduleDays maps the keys of the [schedule] section to the weekdays they
+// set. Single days win over "weekdays" and "weekend".
+var scheduleDays = []struct {
+	key  string
+	days []time.Weekday
+}{
+	{"weekdays", []time.Weekday{time.Monday, time.Tuesday, time.Wednesday, time.Thursday, time.Friday}},
+	{"weekend", []time.Weekday{time.Saturday, time.Sunday}},
+	{"monday", []time.Weekday{time.Monday}},
+	{"tuesday", []time.Weekday{time.Tuesday}},
+	{"wednesday", []time.Weekday{time.Wednesday}},
+	{"thursday", []time.Weekday{time.Thursday}},
+	{"friday", []time.Weekday{time.Friday}},
+	{"saturday", []time.Weekday{time.Saturday}},
+	{"sunday", []time.Weekday{time.Sunday}},
+}
+
+// ScheduleFromConfig builds the schedule from the [schedule] section and
+// the [[exception]] sections of the config file. wakeup and bedtime are the
+// values of -fixedWakeup and -fixedBedtime, used for days not set in the
+// config file. It returns nil if the config file has no schedule.
+func ScheduleFromConfig(doc *tomlDoc, wakeup, bedtime string) (*Schedule, error) {
+	sections := doc.Tables("schedule")
+	exceptions := doc.Tables("exception")
+	if len(sections) == 0 && len(exceptions) == 0 {
+		return nil, nil
+	}
+	s := DailySchedule(wakeup, bedtime)
+	stringValue := func(v tomlValue) (string, error) {
+		str, ok := v.value.(string)
+		if !ok {
+			return "", &ConfigError{doc.file, v.line, v.key, errors.New("needs to be a string")}
+		}
+		return str, nil
+	}
+	parse := func(v tomlValue, origin string) (ScheduleEntry, error) {
+		str, err := stringValue(v)
+		if err != nil {
+			return ScheduleEntry{}, err
+		}
+		wakeup, bedtime, err := ParseSchedule(str)
+		if err != nil {
+			return ScheduleEntry{}, &ConfigError{doc.file, v.line, v.key, err}
+		}
+		return ScheduleEntry{wakeup, bedtime, origin}, nil
+	}
+	for _, t := range sections {
+		values := map[string]tomlValue{}
+		for _, v := range t.values {
+			values[v.key] = v
+		}
+		for _, sd := range scheduleDays {
+			v, ok := values[sd.key]
+			if !ok {
+				continue
+			}
+			delete(values, sd.key)
+			e, err := parse(v, sd.key)
+			if err != nil {
+				return nil, err
+			}
+			for _, day := range sd.days {
+				s.Weekdays[day] = e
+			}
+		}
+		for _, v := range t.values {
+			if _, unknown := values[v.key]; unknown {
+				return nil, &ConfigError{doc.file, v.line, v.key, errors.New("unknown setting")}
+			}
+		}
+	}
+	first := append(sections, exceptions...)[0]
+	for day, e := range s.Weekdays {
+		if e.Wakeup == "" && s.Wakeup == "" {
+			return nil, &ConfigError{doc.file, first.line, "", fmt.Errorf("no schedule for %s, set it in [schedule] or use -fixedWakeup and -fixedBedtime", strings.ToLower(time.Weekday(day).String()))}
+		}
+	}
+	for _, t := range exceptions {
+		var e ScheduleException
+		hasSchedule := false
+		for _, v := range t.values {
+			var err error
+			switch v.key {
+			case "name":
+				e.Origin, err = stringValue(v)
+			case "date", "from", "to":
+				var date string
+				date, err = stringValue(v)
+				if err == nil {
+					if _, perr := time.Parse(time.DateOnly, date); perr != nil {
+						err = &ConfigError{doc.file, v.line, v.key, fmt.Errorf("invalid date %q, needs to be of the form \"YYYY-MM-DD\"", date)}
+					}
+				}
+				if v.key != "to" {
+					e.From = date
+				}
+				if v.key != "from" {
+					e.To = date
+				}
+			case "schedule":
+				e.ScheduleEntry, err = parse(v, e.Origin)
+				hasSchedule = true
+			default:
+				err = &ConfigError{doc.file, v.line, v.key, errors.New("unknown setting")}
+			}
+			if err != nil {
+				return nil, err
+			}
+		}
+		if e.Origin == "" {
+			e.Origin = "exception"
+		}
+		if !hasSchedule || e.From == "" || e.To == "" {
+			return nil, &ConfigError{doc.file, t.line, "", errors.New("[[exception]] needs schedule and either date or from and to")}
+		}
+		if e.To < e.From {
+			return nil, &ConfigError{doc.file, t.line, "", fmt.Errorf("[[exception]] ends (%s) before it starts (%s)", e.To, e.From)}
+		}
+		s.Exceptions = append(s.Exceptions, e)
+	}
+	return s, nil
+}
+
+// RunSchedule handles the "schedule" subcommand and prints the times in
+// effect for the days starting with the day of when.
+func RunSchedule(progname string, cflags Config, args []string, when time.Time, w io.Writer) (string, error) {
+	var out bytes.Buffer
+	flags := flag.NewFlagSet(progname+" schedule", flag.ContinueOnError)
+	flags.SetOutput(&out)
+	days := flags.Int("days", 7, "Number of days to print")
+	err := flags.Parse(args)
+	if err != nil {
+		return out.String(), err
+	}
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(tw, "Date\tStart\tEnd\tFrom\n")
+	for i := range *days {
+		// noon is safe from DST changes and polar conditions are decided then
+		noon := time.Date(when.Year(), when.Month(), when.Day()+i, 12, 0, 0, 0, when.Location())
+		source, err := GetSource(cflags, noon)
+		if err != nil {
+			return "", err
+		}
+		rise, set, origin := time.Time{}, time.Time{}, source.Kind
+		switch source.Kind {
+		case "schedule":
+			rise, set, err = source.Schedule.Times(noon)
+			origin = source.Schedule.On(noon).Origin
+		case "location":
+			rise, set = LocalTimes(noon, cflags.Latitude, cflags.Longitude)
+		}
+		if err != nil {
+			return "", err
+		}
+		if source.Polar != PolarNone {
+			origin = fmt.Sprintf("%s (%s)", origin, source.Polar)
+		}
+		bedtime := clockTime(set)
+		if !set.IsZero() && set.Day() != noon.Day() {
+			bedtime += " (+1)"
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\n", noon.Format("Mon 2006-01-02"), clockTime(rise), bedtime, origin)
+	}
+	return "", tw.Flush()
+}
@@ -0,0 +1,282 @@
+package main
+
+import (
+	"bytes"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+type ParseScheduleTestCase struct {
+	schedule string
+	wakeup   string
+	bedtime  string
+	isErr    bool
+}
+
+func TestParseSchedule(t *testing.T) {
+	tests := map[string]ParseScheduleTestCase{
+		"valid":         {"7:00-22:00", "7:00", "22:00", false},
+		"spaces":        {"07:30 - 21:15", "07:30", "21:15", false},
+		"no separator":  {"7:00", "", "", true},
+		"invalid time":  {"7:00-25:00", "", "", true},
+		"invalid start": {"x-22:00", "", "", true},
+	}
+	for label, test := range tests {
+		t.Run(label, func(t *testing.T) {
+			wakeup, bedtime, err := ParseSchedule(test.schedule)
+			if (err != nil) != test.isErr {
+				t.Fatalf("Got error %v", err)
+			}
+			if !test.isErr && (wakeup != test.wakeup || bedtime != test.bedtime) {
+				t.Errorf("Got %s-%s instead of %s-%s", wakeup, bedtime, test.wakeup, test.bedtime)
+			}
+		})
+	}
+}
+
+// testSchedule has weekday and weekend times, a vacation and a holiday
+// within the vacation.
+func testSchedule() *Schedule {
+	s := DailySchedule("6:30", "22:30")
+	for _, day := range []time.Weekday{time.Saturday, time.Sunday} {
+		s.Weekdays[day] = ScheduleEntry{"8:30", "0:30", "weekend"}
+	}
+	s.Exceptions = []ScheduleException{
+		{"2025-08-01", "2025-08-21", ScheduleEntry{"9:00", "1:00", "vacation"}},
+		{"2025-08-15", "2025-08-15", ScheduleEntry{"10:00", "23:00", "holiday"}},
+	}
+	return s
+}
+
+type ScheduledTimesTestCase struct {
+	t            time.Time
+	transitions  Transitions
+	expectedRise time.Time
+	expectedSet  time.Time
+}
+
+func TestScheduledTimes(t *testing.T) {
+	tests := map[string]ScheduledTimesTestCase{
+		"Friday": {
+			time.Date(2025, time.April, 18, 12, 0, 0, 0, time.Local),
+			UniformTransitions(time.Hour),
+			time.Date(2025, time.April, 18, 6, 30, 0, 0, time.Local),
+			time.Date(2025, time.April, 18, 22, 30, 0, 0, time.Local),
+		},
+		"Friday night": {
+			time.Date(2025, time.April, 19, 0, 15, 0, 0, time.Local),
+			UniformTransitions(time.Hour),
+			time.Date(2025, time.April, 19, 8, 30, 0, 0, time.Local),
+			time.Date(2025, time.April, 20, 0, 30, 0, 0, time.Local),
+		},
+		"Saturday night, before bedtime": {
+			time.Date(2025, time.April, 20, 0, 15, 0, 0, time.Local),
+			UniformTransitions(time.Hour),
+			time.Date(2025, time.April, 19, 8, 30, 0, 0, time.Local),
+			time.Date(2025, time.April, 20, 0, 30, 0, 0, time.Local),
+		},
+		"Sunday night, before bedtime": {
+			time.Date(2025, time.April, 21, 0, 15, 0, 0, time.Local),
+			UniformTransitions(time.Hour),
+			time.Date(2025, time.April, 20, 8, 30, 0, 0, time.Local),
+			time.Date(2025, time.April, 21, 0, 30, 0, 0, time.Local),
+		},
+		"Monday": {
+			time.Date(2025, time.April, 21, 0, 45, 0, 0, time.Local),
+			UniformTransitions(time.Hour),
+			time.Date(2025, time.April, 21, 6, 30, 0, 0, time.Local),
+			time.Date(2025, time.April, 21, 22, 30, 0, 0, time.Local),
+		},
+		"Vacation": {
+			time.Date(2025, time.August, 4, 12, 0, 0, 0, time.Local),
+			UniformTransitions(time.Hour),
+			time.Date(2025, time.August, 4, 9, 0, 0, 0, time.Local),
+			time.Date(2025, time.August, 5, 1, 0, 0, 0, time.Local),
+		},
+		"Holiday within vacation": {
+			time.Date(2025, time.August, 15, 12, 0, 0, 0, time.Local),
+			UniformTransitions(time.Hour),
+			time.Date(2025, time.August, 15, 10, 0, 0, 0, time.Local),
+			time.Date(2025, time.August, 15, 23, 0, 0, 0, time.Local),
+		},
+		"Night after the last vacation day": {
+			time.Date(2025, time.August, 22, 0, 30, 0, 0, time.Local),
+			UniformTransitions(time.Hour),
+			time.Date(2025, time.August, 21, 9, 0, 0, 0, time.Local),
+			time.Date(2025, time.August, 22, 1, 0, 0, 0, time.Local),
+		},
+		"Saturday night, in the bedtime transition": {
+			time.Date(2025, time.April, 20, 1, 0, 0, 0, time.Local),
+			Transitions{SunsetDuration: time.Hour, SunsetOffset: time.Hour},
+			time.Date(2025, time.April, 19, 8, 30, 0, 0, time.Local),
+			time.Date(2025, time.April, 20, 0, 30, 0, 0, time.Local),
+		},
+		"Sunday night, after the bedtime transition": {
+			time.Date(2025, time.April, 20, 1, 30, 0, 0, time.Local),
+			Transitions{SunsetDuration: time.Hour, SunsetOffset: time.Hour},
+			time.Date(2025, time.April, 20, 8, 30, 0, 0, time.Local),
+			time.Date(2025, time.April, 21, 0, 30, 0, 0, time.Local),
+		},
+		"Friday, in the wakeup transition of Saturday": {
+			time.Date(2025, time.April, 18, 23, 45, 0, 0, time.Local),
+			Transitions{SunriseDuration: time.Hour, SunriseOffset: -9 * time.Hour},
+			time.Date(2025, time.April, 19, 8, 30, 0, 0, time.Local),
+			time.Date(2025, time.April, 20, 0, 30, 0, 0, time.Local),
+		},
+		"After vacation": {
+			time.Date(2025, time.August, 22, 12, 0, 0, 0, time.Local),
+			UniformTransitions(time.Hour),
+			time.Date(2025, time.August, 22, 6, 30, 0, 0, time.Local),
+			time.Date(2025, time.August, 22, 22, 30, 0, 0, time.Local),
+		},
+	}
+	for label, test := range tests {
+		t.Run(label, func(t *testing.T) {
+			rise, set, err := ScheduledTimes(test.t, testSchedule(), test.transitions)
+			if err != nil {
+				t.Fatalf("Got error %v", err)
+			}
+			if !rise.Equal(test.expectedRise) || !set.Equal(test.expectedSet) {
+				t.Errorf("Got %v - %v instead of %v - %v", rise, set, test.expectedRise, test.expectedSet)
+			}
+		})
+	}
+}
+
+func TestScheduleFromConfig(t *testing.T) {
+	writeConfig(t, `
+[schedule]
+weekdays = "6:30-22:30"
+weekend = "8:30-0:30"
+friday = "6:30-0:30"
+
+[[exception]]
+name = "vacation"
+from = "2025-08-01"
+to = "2025-08-21"
+schedule = "9:00-1:00"
+
+[[exception]]
+date = "2025-12-24"
+schedule = "8:00-23:00"
+`)
+	c, _, err := GetFlags("foo", []string{})
+	if err != nil {
+		t.Fatalf("Got error %v", err)
+	}
+	s := c.Schedule
+	if s == nil {
+		t.Fatalf("Got no schedule")
+	}
+	expected := map[time.Weekday]ScheduleEntry{
+		time.Monday:   {"6:30", "22:30", "weekdays"},
+		time.Friday:   {"6:30", "0:30", "friday"},
+		time.Saturday: {"8:30", "0:30", "weekend"},
+	}
+	for day, e := range expected {
+		if s.Weekdays[day] != e {
+			t.Errorf("Got %v for %s instead of %v", s.Weekdays[day], day, e)
+		}
+	}
+	if len(s.Exceptions) != 2 {
+		t.Fatalf("Got %d exceptions instead of 2", len(s.Exceptions))
+	}
+	if e := s.Exceptions[0]; e.From != "2025-08-01" || e.To != "2025-08-21" || e.Origin != "vacation" || e.Wakeup != "9:00" {
+		t.Errorf("Got %v", e)
+	}
+	if e := s.Exceptions[1]; e.From != "2025-12-24" || e.To != "2025-12-24" || e.Origin != "exception" || e.Bedtime != "23:00" {
+		t.Errorf("Got %v", e)
+	}
+	source, _ := GetSource(c, time.Now())
+	if source.Kind != "schedule" {
+		t.Errorf("Got source %s", source.Kind)
+	}
+}
+
+func TestScheduleFromConfigErrors(t *testing.T) {
+	tests := map[string]ConfigFileErrorTestCase{
+		"incomplete week": {
+			"[schedule]\nweekdays = \"6:30-22:30\"",
+			"config.toml:1: no schedule for sunday, set it in [schedule] or use -fixedWakeup and -fixedBedtime",
+		},
+		"unknown day": {
+			"[schedule]\nweekdays = \"6:30-22:30\"\nweekends = \"8:30-0:30\"",
+			"config.toml:3: weekends: unknown setting",
+		},
+		"invalid schedule": {
+			"[schedule]\nweekdays = \"6:30\"",
+			"config.toml:2: weekdays: Schedule malformed, needs to be of the form \"HH:MM-HH:MM\"",
+		},
+		"not a string": {
+			"fixedWakeup = \"7:00\"\nfixedBedtime = \"22:00\"\n[schedule]\nmonday = 7",
+			"config.toml:4: monday: needs to be a string",
+		},
+		"exception without schedule": {
+			"fixedWakeup = \"7:00\"\nfixedBedtime = \"22:00\"\n[[exception]]\ndate = \"2025-12-24\"",
+			"config.toml:3: [[exception]] needs schedule and either date or from and to",
+		},
+		"exception with invalid date": {
+			"fixedWakeup = \"7:00\"\nfixedBedtime = \"22:00\"\n[[exception]]\ndate = \"24.12.2025\"\nschedule = \"8:00-23:00\"",
+			"config.toml:4: date: invalid date \"24.12.2025\", needs to be of the form \"YYYY-MM-DD\"",
+		},
+		"exception ending before start": {
+			"fixedWakeup = \"7:00\"\nfixedBedtime = \"22:00\"\n[[exception]]\nfrom = \"2025-08-21\"\nto = \"2025-08-01\"\nschedule = \"8:00-23:00\"",
+			"config.toml:3: [[exception]] ends (2025-08-01) before it starts (2025-08-21)",
+		},
+	}
+	for label, test := range tests {
+		t.Run(label, func(t *testing.T) {
+			path := writeConfig(t, test.content)
+			_, _, err := GetFlags("foo", []string{})
+			if err == nil || err.Error() != filepath.Dir(path)+"/"+test.expected {
+				t.Errorf("Got error %v instead of %s", err, test.expected)
+			}
+		})
+	}
+}
+
+func TestScheduleFillsInFixedTimes(t *testing.T) {
+	writeConfig(t, "[schedule]\nweekend = \"8:30-0:30\"")
+	c, _, err := GetFlags("foo", []string{"-fixedWakeup", "7:00", "-fixedBedtime", "22:00"})
+	if err != nil {
+		t.Fatalf("Got error %v", err)
+	}
+	monday := time.Date(2025, time.April, 14, 12, 0, 0, 0, time.Local)
+	if e := c.Schedule.On(monday); e.Wakeup != "7:00" || e.Origin != "default" {
+		t.Errorf("Got %v on monday", e)
+	}
+	if e := c.Schedule.On(monday.AddDate(0, 0, 5)); e.Wakeup != "8:30" || e.Origin != "weekend" {
+		t.Errorf("Got %v on saturday", e)
+	}
+}
+
+func TestRunSchedule(t *testing.T) {
+	cflags := statusTestConfig()
+	cflags.Schedule = testSchedule()
+	var out bytes.Buffer
+	when := time.Date(2025, time.August, 13, 15, 0, 0, 0, time.Local)
+	if _, err := RunSchedule("foo", cflags, []string{"-days", "4"}, when, &out); err != nil {
+		t.Fatalf("Got error %v", err)
+	}
+	expected := `Date            Start  End         From
+Wed 2025-08-13  09:00  01:00 (+1)  vacation
+Thu 2025-08-14  09:00  01:00 (+1)  vacation
+Fri 2025-08-15  10:00  23:00       holiday
+Sat 2025-08-16  09:00  01:00 (+1)  vacation
+`
+	if out.String() != expected {
+		t.Errorf("Got\n%s instead of\n%s", out.String(), expected)
+	}
+	t.Run("location", func(t *testing.T) {
+		var out bytes.Buffer
+		if _, err := RunSchedule("foo", statusTestConfig(), []string{}, when, &out); err != nil {
+			t.Fatalf("Got error %v", err)
+		}
+		lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+		if len(lines) != 8 || !strings.HasSuffix(lines[1], "location") {
+			t.Errorf("Got\n%s", out.String())
+		}
+	})
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"strconv"
+	"text/tabwriter"
+	"time"
+)
+
+const (
+	DefaultSimulationStep = 5 * time.Minute
+)
+
+// simulationTimeLayouts are accepted by --from and --to, in local time
+// unless a zone is given.
+var simulationTimeLayouts = []string{
+	time.RFC3339,
+	"2006-01-02T15:04:05",
+	"2006-01-02T15:04",
+	"2006-01-02 15:04",
+	time.DateOnly,
+}
+
+// SimulationStep is what nerdshade applies at one instant
+type SimulationStep struct {
+	Time        time.Time `json:"time"`
+	Phase       Phase     `json:"phase"`
+	Brightness  float64   `json:"brightness"`
+	Temperature int       `json:"temperature"`
+	Gamma       int       `json:"gamma"`
+}
+
+// Simulate returns the values applied from from to to (inclusive) every
+// step. The values go through GetAndSetBrightness into a NoneOutput, so they
+// are exactly what the loop would apply.
+func Simulate(cflags Config, from, to time.Time, step time.Duration) ([]SimulationStep, error) {
+	if step <= 0 {
+		return nil, errors.New("Step needs to be positive")
+	}
+	if to.Before(from) {
+		return nil, errors.New("End of simulation is before its start")
+	}
+	var steps []SimulationStep
+	out := &NoneOutput{}
+	for t := from; !t.After(to); t = t.Add(step) {
+		s, err := GetStatus(cflags, t)
+		if err != nil {
+			return nil, fmt.Errorf("at %s: %w", t.Format(time.RFC3339), err)
+		}
+		GetAndSetBrightness(cflags, out, t, nil, nil)
+		temperature, gamma, _ := out.Current()
+		steps = append(steps, SimulationStep{t, s.Phase, s.Brightness, temperature, gamma})
+	}
+	return steps, nil
+}
+
+// parseSimulationTime parses the value of --from or --to
+func parseSimulationTime(value string) (time.Time, error) {
+	for _, layout := range simulationTimeLayouts {
+		if t, err := time.ParseInLocation(layout, value, time.Local); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("invalid time %q, use e. g. \"2026-12-21T18:00\"", value)
+}
+
+// WriteSimulation prints the steps as table, csv or json
+func WriteSimulation(w io.Writer, steps []SimulationStep, format string) error {
+	switch format {
+	case "json":
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(steps)
+	case "csv":
+		cw := csv.NewWriter(w)
+		cw.Write([]string{"time", "phase", "brightness", "temperature", "gamma"})
+		for _, s := range steps {
+			cw.Write([]string{
+				s.Time.Format(time.RFC3339),
+				string(s.Phase),
+				strconv.FormatFloat(s.Brightness, 'f', 3, 64),
+				strconv.Itoa(s.Temperature),
+				strconv.Itoa(s.Gamma),
+			})
+		}
+		cw.Flush()
+		return cw.Error()
+	case "table":
+		tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+		fmt.Fprintf(tw, "Time\tPhase\tBrightness\tTemperature\tGamma\n")
+		for _, s := range steps {
+			fmt.Fprintf(tw, "%s\t%s\t%.3f\t%dK\t%d%%\n", s.Time.Format("2006-01-02 15:04 MST"), s.Phase, s.Brightness, s.Temperature, s.Gamma)
+		}
+		return tw.Flush()
+	}
+	return fmt.Errorf("Unknown format %q, use table, csv or json", format)
+}
+
+// RunSimulate handles the "simulate" subcommand. Without --from, the
+// simulation starts at midnight of the day of when and lasts one day.
+func RunSimulate(progname string, cflags Config, args []string, when time.Time, w io.Writer) (string, error) {
+	var out bytes.Buffer
+	flags := flag.NewFlagSet(progname+" simulate", flag.ContinueOnError)
+	flags.SetOutput(&out)
+	fromValue := flags.String("from", "", "Start of simulation, e. g. \"2026-12-21T00:00\" (default: today 00:00)")
+	toValue := flags.String("to", "", "End of simulation (default: one day after start)")
+	step := flags.Duration("step", DefaultSimulationStep, "Time between simulated updates")
+	format := flags.String("format", "table", "Output format, one of: table, csv, json")
+	err := flags.Parse(args)
+	if err != nil {
+		return out.String(), err
+	}
+	from := time.Date(when.Year(), when.Month(), when.Day(), 0, 0, 0, 0, when.Location())
+	if *fromValue != "" {
+		if from, err = parseSimulationTime(*fromValue); err != nil {
+			return "", err
+		}
+	}
+	to := from.AddDate(0, 0, 1)
+	if *toValue != "" {
+		if to, err = parseSimulationTime(*toValue); err != nil {
+			return "", err
+		}
+	}
+	steps, err := Simulate(cflags, from, to, *step)
+	if err != nil {
+		return "", err
+	}
+	return "", WriteSimulation(w, steps, *format)
+}
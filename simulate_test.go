@@ -0,0 +1,136 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+)
+
+func simulateTestConfig() Config {
+	cflags := statusTestConfig()
+	cflags.Wakeup = "7:00"
+	cflags.Bedtime = "22:00"
+	return cflags
+}
+
+func TestSimulate(t *testing.T) {
+	from := time.Date(2025, time.April, 15, 6, 0, 0, 0, time.Local)
+	steps, err := Simulate(simulateTestConfig(), from, from.Add(2*time.Hour), 30*time.Minute)
+	if err != nil {
+		t.Fatalf("Got error %v", err)
+	}
+	expected := []SimulationStep{
+		{from, PhaseNight, 0.0, 4000, 90},
+		{from.Add(30 * time.Minute), PhaseNight, 0.0, 4000, 90},
+		{from.Add(time.Hour), PhaseNight, 0.0, 4000, 90},
+		{from.Add(90 * time.Minute), PhaseSunrise, 0.5, 5250, 95},
+		{from.Add(2 * time.Hour), PhaseDay, 1.0, 6500, 100},
+	}
+	if len(steps) != len(expected) {
+		t.Fatalf("Got %d steps instead of %d", len(steps), len(expected))
+	}
+	for i := range expected {
+		if steps[i] != expected[i] {
+			t.Errorf("Got %v instead of %v", steps[i], expected[i])
+		}
+	}
+}
+
+// On the day DST starts, the wall clock skips an hour, so a day from
+// midnight to midnight has only 23 hourly steps plus the end.
+func TestSimulateDST(t *testing.T) {
+	cet, err := time.LoadLocation("CET")
+	if err != nil {
+		t.Skip("no time zone database")
+	}
+	from := time.Date(2025, time.March, 30, 0, 0, 0, 0, cet)
+	steps, err := Simulate(simulateTestConfig(), from, from.AddDate(0, 0, 1), time.Hour)
+	if err != nil {
+		t.Fatalf("Got error %v", err)
+	}
+	if len(steps) != 24 {
+		t.Errorf("Got %d steps instead of 24", len(steps))
+	}
+	// 7:00 is reached after 6 real hours
+	if s := steps[6]; s.Time.Hour() != 7 || s.Phase != PhaseNight {
+		t.Errorf("Got %v", s)
+	}
+	if s := steps[7]; s.Time.Hour() != 8 || s.Temperature != 6500 {
+		t.Errorf("Got %v", s)
+	}
+}
+
+func TestSimulateErrors(t *testing.T) {
+	from := time.Date(2025, time.April, 15, 6, 0, 0, 0, time.Local)
+	if _, err := Simulate(simulateTestConfig(), from, from.Add(time.Hour), 0); err == nil {
+		t.Errorf("Expected error for zero step")
+	}
+	if _, err := Simulate(simulateTestConfig(), from, from.Add(-time.Hour), time.Minute); err == nil {
+		t.Errorf("Expected error for end before start")
+	}
+}
+
+type RunSimulateTestCase struct {
+	args     []string
+	expected string
+}
+
+func TestRunSimulate(t *testing.T) {
+	when := time.Date(2025, time.April, 15, 15, 0, 0, 0, time.Local)
+	tests := map[string]RunSimulateTestCase{
+		"table": {
+			[]string{"--from", "2025-04-15T21:00", "--to", "2025-04-15T22:00", "--step", "30m"},
+			`Time                   Phase   Brightness  Temperature  Gamma
+2025-04-15 21:00 CEST  day     1.000       6500K        100%
+2025-04-15 21:30 CEST  sunset  0.500       5250K        95%
+2025-04-15 22:00 CEST  night   0.000       4000K        90%
+`,
+		},
+		"csv": {
+			[]string{"--from", "2025-04-15T21:30", "--to", "2025-04-15T22:00", "--step", "30m", "--format", "csv"},
+			`time,phase,brightness,temperature,gamma
+2025-04-15T21:30:00+02:00,sunset,0.500,5250,95
+2025-04-15T22:00:00+02:00,night,0.000,4000,90
+`,
+		},
+	}
+	for label, test := range tests {
+		t.Run(label, func(t *testing.T) {
+			var out bytes.Buffer
+			if _, err := RunSimulate("foo", simulateTestConfig(), test.args, when, &out); err != nil {
+				t.Fatalf("Got error %v", err)
+			}
+			if out.String() != test.expected {
+				t.Errorf("Got\n%s instead of\n%s", out.String(), test.expected)
+			}
+		})
+	}
+	t.Run("json", func(t *testing.T) {
+		var out bytes.Buffer
+		if _, err := RunSimulate("foo", simulateTestConfig(), []string{"--format", "json", "--step", "1h"}, when, &out); err != nil {
+			t.Fatalf("Got error %v", err)
+		}
+		var steps []SimulationStep
+		if err := json.Unmarshal(out.Bytes(), &steps); err != nil {
+			t.Fatalf("Got error %v", err)
+		}
+		// Default is one day from midnight, both ends included
+		if len(steps) != 25 || steps[0].Time.Hour() != 0 || steps[12].Temperature != 6500 {
+			t.Errorf("Got %v", steps)
+		}
+	})
+	t.Run("invalid time", func(t *testing.T) {
+		_, err := RunSimulate("foo", simulateTestConfig(), []string{"--from", "tomorrow"}, when, &bytes.Buffer{})
+		if err == nil || !strings.Contains(err.Error(), "invalid time") {
+			t.Errorf("Got error %v", err)
+		}
+	})
+	t.Run("invalid format", func(t *testing.T) {
+		_, err := RunSimulate("foo", simulateTestConfig(), []string{"--format", "xml"}, when, &bytes.Buffer{})
+		if err == nil {
+			t.Errorf("Expected error, got nil")
+		}
+	})
+}
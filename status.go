@@ -0,0 +1,213 @@
+package main
+
+import (
+	"bytes"
+	"cmp"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"text/tabwriter"
+	"time"
+)
+
+// Phase is the part of the day brightness is in
+type Phase string
+
+const (
+	PhaseNight   Phase = "night"
+	PhaseSunrise Phase = "sunrise"
+	PhaseDay     Phase = "day"
+	PhaseSunset  Phase = "sunset"
+)
+
+// PhaseAt returns the phase at the given time. The boundaries are the same
+// BrightnessLevel uses.
+func PhaseAt(when, rise, set time.Time, transitions Transitions) Phase {
+	riseStart, riseEnd, setStart, setEnd := transitions.Bounds(rise, set)
+	switch {
+	case !when.After(riseStart) || !when.Before(setEnd):
+		return PhaseNight
+	case when.Before(riseEnd):
+		return PhaseSunrise
+	case when.After(setStart):
+		return PhaseSunset
+	}
+	return PhaseDay
+}
+
+// NextPhaseChange returns the time of the next phase change after when and
+// the phase starting then. nextRise is the rise of the following day.
+func NextPhaseChange(when, rise, set, nextRise time.Time, transitions Transitions) (time.Time, Phase) {
+	riseStart, riseEnd, setStart, setEnd := transitions.Bounds(rise, set)
+	changes := []struct {
+		t     time.Time
+		phase Phase
+	}{
+		{riseStart, PhaseSunrise},
+		{riseEnd, PhaseDay},
+		{setStart, PhaseSunset},
+		{setEnd, PhaseNight},
+	}
+	for _, change := range changes {
+		if change.t.After(when) {
+			return change.t, change.phase
+		}
+	}
+	return nextRise.Add(transitions.SunriseOffset), PhaseSunrise
+}
+
+// GetPhase returns the phase at the given time for the source
+func GetPhase(source Source, cflags Config, when time.Time) (Phase, error) {
+	if source.Kind == "elevation" {
+		return ElevationPhase(when, cflags.Latitude, cflags.Longitude, cflags.ElevationNight, cflags.ElevationDay), nil
+	}
+	rise, set, err := GetTimes(source, cflags, when)
+	return PhaseAt(when, rise, set, cflags.Transitions()), err
+}
+
+// Status describes what nerdshade does at a given time
+type Status struct {
+	Time        time.Time `json:"time"`
+	Brightness  float64   `json:"brightness"`
+	Temperature int       `json:"temperature"`
+	Gamma       int       `json:"gamma"`
+	Phase       Phase     `json:"phase"`
+	// Source is "location", "elevation" or "schedule"
+	Source string `json:"source"`
+	// Polar is set if Source is a fallback for polar day or night
+	Polar Polar `json:"polar,omitempty"`
+	// Sunrise and Sunset are wakeup and bedtime for a fixed schedule and
+	// zero during polar day or night
+	Sunrise    time.Time `json:"sunrise"`
+	Sunset     time.Time `json:"sunset"`
+	NextChange time.Time `json:"next_change"`
+	NextPhase  Phase     `json:"next_phase"`
+}
+
+// GetStatus computes the status at the given time. It uses the same
+// functions the loop uses, so the values match what gets applied.
+func GetStatus(cflags Config, when time.Time) (s Status, err error) {
+	s.Time = when
+	s.Brightness, s.Temperature, s.Gamma, err = GetValues(cflags, when)
+	if err != nil {
+		return
+	}
+	source, err := GetSource(cflags, when)
+	if err != nil {
+		return
+	}
+	s.Source = source.Kind
+	s.Polar = source.Polar
+	s.Sunrise, s.Sunset, err = GetTimes(source, cflags, when)
+	if err != nil {
+		return
+	}
+	if source.Kind == "elevation" {
+		s.Phase = ElevationPhase(when, cflags.Latitude, cflags.Longitude, cflags.ElevationNight, cflags.ElevationDay)
+		s.NextChange, s.NextPhase = NextElevationChange(when, cflags.Latitude, cflags.Longitude, cflags.ElevationNight, cflags.ElevationDay)
+		return
+	}
+	nextRise, _, err := GetTimes(source, cflags, when.AddDate(0, 0, 1))
+	if err != nil {
+		return
+	}
+	s.Phase = PhaseAt(when, s.Sunrise, s.Sunset, cflags.Transitions())
+	s.NextChange, s.NextPhase = NextPhaseChange(when, s.Sunrise, s.Sunset, nextRise, cflags.Transitions())
+	return
+}
+
+// NextUpdate returns when the values to apply change next after when.
+// During transitions this is after -loopInterval, otherwise at the start
+// of the next transition. Between keyframes it is when the interpolated
+// values change next, but not before -loopInterval.
+func NextUpdate(cflags Config, when time.Time) time.Time {
+	interval := cmp.Or(cflags.LoopInterval, TransitionLoopInterval)
+	if len(cflags.Keyframes) > 0 {
+		dayTimes, err := keyframeDayTimes(cflags, when)
+		if err != nil {
+			return when.Add(interval)
+		}
+		next, ok, err := NextKeyframeChange(cflags.Keyframes, when, interval, dayTimes)
+		if err != nil {
+			return when.Add(interval)
+		}
+		if ok {
+			return next
+		}
+	}
+	s, err := GetStatus(cflags, when)
+	if err != nil || s.Phase == PhaseSunrise || s.Phase == PhaseSunset {
+		return when.Add(interval)
+	}
+	if s.NextChange.IsZero() {
+		return when.Add(MaxLoopInterval)
+	}
+	return s.NextChange
+}
+
+// NextChangeString describes the next phase change, e. g.
+// "20:14 (night, in 44m0s)"
+func (s Status) NextChangeString() string {
+	if s.NextChange.IsZero() {
+		return "none within the next two days"
+	}
+	return fmt.Sprintf("%s (%s, in %s)", s.NextChange.Format("15:04"), s.NextPhase, s.NextChange.Sub(s.Time).Round(time.Minute))
+}
+
+// clockTime formats t as "15:04", or "none" for the zero time
+func clockTime(t time.Time) string {
+	if t.IsZero() {
+		return "none"
+	}
+	return t.Format("15:04")
+}
+
+// Write prints the status in human readable form
+func (s Status) Write(w io.Writer) error {
+	rise, set := "Sunrise", "Sunset"
+	if s.Source == "schedule" {
+		rise, set = "Wakeup", "Bedtime"
+	}
+	phase := string(s.Phase)
+	if s.Phase == PhaseSunrise || s.Phase == PhaseSunset {
+		phase += " transition"
+	}
+	tw := tabwriter.NewWriter(w, 0, 0, 1, ' ', 0)
+	fmt.Fprintf(tw, "Phase:\t%s\n", phase)
+	fmt.Fprintf(tw, "Brightness:\t%.3f\n", s.Brightness)
+	fmt.Fprintf(tw, "Temperature:\t%dK\n", s.Temperature)
+	fmt.Fprintf(tw, "Gamma:\t%d%%\n", s.Gamma)
+	if s.Polar != PolarNone {
+		fmt.Fprintf(tw, "Polar:\t%s, using %s\n", s.Polar, s.Source)
+	}
+	fmt.Fprintf(tw, "%s:\t%s\n", rise, clockTime(s.Sunrise))
+	fmt.Fprintf(tw, "%s:\t%s\n", set, clockTime(s.Sunset))
+	fmt.Fprintf(tw, "Next change:\t%s\n", s.NextChangeString())
+	return tw.Flush()
+}
+
+// RunStatus handles the "status" subcommand and prints the status at
+// the given time. If ambient is not nil, the values are blended with the
+// ambient light.
+func RunStatus(progname string, cflags Config, args []string, when time.Time, ambient *AmbientLight, w io.Writer) (string, error) {
+	var out bytes.Buffer
+	flags := flag.NewFlagSet(progname+" status", flag.ContinueOnError)
+	flags.SetOutput(&out)
+	asJSON := flags.Bool("json", false, "Print status as JSON")
+	err := flags.Parse(args)
+	if err != nil {
+		return out.String(), err
+	}
+	s, err := GetStatus(cflags, when)
+	if err != nil {
+		return "", err
+	}
+	s = ambient.BlendStatus(cflags, s)
+	if *asJSON {
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return "", enc.Encode(s)
+	}
+	return "", s.Write(w)
+}
@@ -0,0 +1,268 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+)
+
+type PhaseAtTestCase struct {
+	t        time.Time
+	expected Phase
+}
+
+func TestPhaseAt(t *testing.T) {
+	rise := time.Date(2025, time.April, 15, 7, 0, 0, 0, time.Local)
+	set := time.Date(2025, time.April, 15, 21, 0, 0, 0, time.Local)
+	tests := map[string]PhaseAtTestCase{
+		"before sunrise":     {time.Date(2025, time.April, 15, 5, 0, 0, 0, time.Local), PhaseNight},
+		"exactly at sunrise": {rise, PhaseNight},
+		"during sunrise":     {time.Date(2025, time.April, 15, 7, 30, 0, 0, time.Local), PhaseSunrise},
+		"end of sunrise":     {time.Date(2025, time.April, 15, 8, 0, 0, 0, time.Local), PhaseDay},
+		"day":                {time.Date(2025, time.April, 15, 13, 0, 0, 0, time.Local), PhaseDay},
+		"during sunset":      {time.Date(2025, time.April, 15, 20, 30, 0, 0, time.Local), PhaseSunset},
+		"exactly at sunset":  {set, PhaseNight},
+		"after sunset":       {time.Date(2025, time.April, 15, 23, 0, 0, 0, time.Local), PhaseNight},
+	}
+	for label, test := range tests {
+		t.Run(label, func(t *testing.T) {
+			if phase := PhaseAt(test.t, rise, set, UniformTransitions(time.Hour)); phase != test.expected {
+				t.Errorf("Got phase %s instead of %s", phase, test.expected)
+			}
+		})
+	}
+}
+
+type NextPhaseChangeTestCase struct {
+	t             time.Time
+	expectedTime  time.Time
+	expectedPhase Phase
+}
+
+func TestNextPhaseChange(t *testing.T) {
+	rise := time.Date(2025, time.April, 15, 7, 0, 0, 0, time.Local)
+	set := time.Date(2025, time.April, 15, 21, 0, 0, 0, time.Local)
+	nextRise := time.Date(2025, time.April, 16, 6, 58, 0, 0, time.Local)
+	tests := map[string]NextPhaseChangeTestCase{
+		"early morning":  {time.Date(2025, time.April, 15, 5, 0, 0, 0, time.Local), rise, PhaseSunrise},
+		"during sunrise": {time.Date(2025, time.April, 15, 7, 10, 0, 0, time.Local), rise.Add(time.Hour), PhaseDay},
+		"day":            {time.Date(2025, time.April, 15, 12, 0, 0, 0, time.Local), set.Add(-time.Hour), PhaseSunset},
+		"during sunset":  {time.Date(2025, time.April, 15, 20, 10, 0, 0, time.Local), set, PhaseNight},
+		"late evening":   {time.Date(2025, time.April, 15, 23, 0, 0, 0, time.Local), nextRise, PhaseSunrise},
+	}
+	for label, test := range tests {
+		t.Run(label, func(t *testing.T) {
+			next, phase := NextPhaseChange(test.t, rise, set, nextRise, UniformTransitions(time.Hour))
+			if !next.Equal(test.expectedTime) || phase != test.expectedPhase {
+				t.Errorf("Got %v (%s) instead of %v (%s)", next, phase, test.expectedTime, test.expectedPhase)
+			}
+		})
+	}
+}
+
+func TestNextPhaseChangeOffsets(t *testing.T) {
+	rise := time.Date(2025, time.April, 15, 7, 0, 0, 0, time.Local)
+	set := time.Date(2025, time.April, 15, 21, 0, 0, 0, time.Local)
+	nextRise := time.Date(2025, time.April, 16, 6, 58, 0, 0, time.Local)
+	transitions := Transitions{time.Hour, 2 * time.Hour, -30 * time.Minute, 30 * time.Minute}
+	next, phase := NextPhaseChange(time.Date(2025, time.April, 15, 12, 0, 0, 0, time.Local), rise, set, nextRise, transitions)
+	if expected := set.Add(-90 * time.Minute); !next.Equal(expected) || phase != PhaseSunset {
+		t.Errorf("Got %v (%s) instead of %v", next, phase, expected)
+	}
+	next, phase = NextPhaseChange(time.Date(2025, time.April, 15, 21, 20, 0, 0, time.Local), rise, set, nextRise, transitions)
+	if expected := set.Add(30 * time.Minute); !next.Equal(expected) || phase != PhaseNight {
+		t.Errorf("Got %v (%s) instead of %v", next, phase, expected)
+	}
+	next, phase = NextPhaseChange(time.Date(2025, time.April, 15, 23, 0, 0, 0, time.Local), rise, set, nextRise, transitions)
+	if expected := nextRise.Add(-30 * time.Minute); !next.Equal(expected) || phase != PhaseSunrise {
+		t.Errorf("Got %v (%s) instead of %v", next, phase, expected)
+	}
+}
+
+func statusTestConfig() Config {
+	return Config{
+		DayTemp:            DefaultDayTemp,
+		NightTemp:          DefaultNightTemp,
+		DayGamma:           DefaultDayGamma,
+		NightGamma:         DefaultNightGamma,
+		Latitude:           DefaultLatitude,
+		Longitude:          DefaultLongitude,
+		TransitionDuration: DefaultTransitionDuration,
+	}
+}
+
+func TestGetStatus(t *testing.T) {
+	when := time.Date(2025, time.April, 15, 19, 30, 0, 0, time.Local)
+	s, err := GetStatus(statusTestConfig(), when)
+	if err != nil {
+		t.Fatalf("Got error %v", err)
+	}
+	// Same numbers as in TestBrightnessLevel
+	if s.Brightness != 0.728 || s.Temperature != 5820 || s.Gamma != 97 {
+		t.Errorf("Got %.3f %d %d", s.Brightness, s.Temperature, s.Gamma)
+	}
+	if s.Phase != PhaseSunset || s.NextPhase != PhaseNight || !s.NextChange.Equal(s.Sunset) {
+		t.Errorf("Got phase %s, next %s at %v", s.Phase, s.NextPhase, s.NextChange)
+	}
+	if s.Source != "location" {
+		t.Errorf("Got source %s", s.Source)
+	}
+}
+
+type WrappedScheduleStatusTestCase struct {
+	t             time.Time
+	expectedPhase Phase
+	expectedNext  time.Time
+}
+
+func TestGetStatusWrappedSchedule(t *testing.T) {
+	cflags := statusTestConfig()
+	cflags.Wakeup = "9:00"
+	cflags.Bedtime = "1:30"
+	tests := map[string]WrappedScheduleStatusTestCase{
+		"evening": {
+			time.Date(2025, time.April, 15, 23, 0, 0, 0, time.Local),
+			PhaseDay,
+			time.Date(2025, time.April, 16, 0, 30, 0, 0, time.Local),
+		},
+		"after midnight": {
+			time.Date(2025, time.April, 16, 1, 0, 0, 0, time.Local),
+			PhaseSunset,
+			time.Date(2025, time.April, 16, 1, 30, 0, 0, time.Local),
+		},
+		"night": {
+			time.Date(2025, time.April, 16, 3, 0, 0, 0, time.Local),
+			PhaseNight,
+			time.Date(2025, time.April, 16, 9, 0, 0, 0, time.Local),
+		},
+	}
+	for label, test := range tests {
+		t.Run(label, func(t *testing.T) {
+			s, err := GetStatus(cflags, test.t)
+			if err != nil {
+				t.Fatalf("Got error %v", err)
+			}
+			if s.Phase != test.expectedPhase || !s.NextChange.Equal(test.expectedNext) {
+				t.Errorf("Got %s, next change %v instead of %s, %v", s.Phase, s.NextChange, test.expectedPhase, test.expectedNext)
+			}
+		})
+	}
+}
+
+func TestRunStatus(t *testing.T) {
+	when := time.Date(2025, time.April, 15, 12, 0, 0, 0, time.Local)
+	cflags := statusTestConfig()
+	cflags.Wakeup = "7:00"
+	cflags.Bedtime = "22:00"
+	t.Run("text", func(t *testing.T) {
+		var out bytes.Buffer
+		if _, err := RunStatus("foo", cflags, []string{}, when, nil, &out); err != nil {
+			t.Fatalf("Got error %v", err)
+		}
+		for _, expected := range []string{"Phase:       day\n", "Temperature: 6500K\n", "Wakeup:      07:00\n", "Next change: 21:00 (sunset, in 9h0m0s)\n"} {
+			if !strings.Contains(out.String(), expected) {
+				t.Errorf("Output does not contain %q:\n%s", expected, out.String())
+			}
+		}
+	})
+	t.Run("json", func(t *testing.T) {
+		var out bytes.Buffer
+		if _, err := RunStatus("foo", cflags, []string{"--json"}, when, nil, &out); err != nil {
+			t.Fatalf("Got error %v", err)
+		}
+		var s Status
+		if err := json.Unmarshal(out.Bytes(), &s); err != nil {
+			t.Fatalf("Output is not valid JSON: %v", err)
+		}
+		if s.Phase != PhaseDay || s.Source != "schedule" || s.Gamma != DefaultDayGamma {
+			t.Errorf("Got %+v", s)
+		}
+	})
+}
+
+type NextUpdateTestCase struct {
+	t        time.Time
+	expected time.Time
+}
+
+func TestNextUpdate(t *testing.T) {
+	cflags := statusTestConfig()
+	cflags.Wakeup = "7:00"
+	cflags.Bedtime = "22:00"
+	tests := map[string]NextUpdateTestCase{
+		"day": {
+			time.Date(2025, time.April, 15, 12, 0, 0, 0, time.Local),
+			time.Date(2025, time.April, 15, 21, 0, 0, 0, time.Local),
+		},
+		"sunset": {
+			time.Date(2025, time.April, 15, 21, 30, 0, 0, time.Local),
+			time.Date(2025, time.April, 15, 21, 30, 10, 0, time.Local),
+		},
+		"night": {
+			time.Date(2025, time.April, 15, 23, 0, 0, 0, time.Local),
+			time.Date(2025, time.April, 16, 7, 0, 0, 0, time.Local),
+		},
+		"early morning": {
+			time.Date(2025, time.April, 16, 3, 0, 0, 0, time.Local),
+			time.Date(2025, time.April, 16, 7, 0, 0, 0, time.Local),
+		},
+	}
+	for label, test := range tests {
+		t.Run(label, func(t *testing.T) {
+			if next := NextUpdate(cflags, test.t); !next.Equal(test.expected) {
+				t.Errorf("Got %v instead of %v", next, test.expected)
+			}
+		})
+	}
+	keyframeCflags := cflags
+	keyframeCflags.Keyframes = []Keyframe{
+		{KeyframeTime{"", 8 * time.Hour}, 6500, 100},
+		{KeyframeTime{"", 18 * time.Hour}, 6500, 100},
+		{KeyframeTime{"", 20 * time.Hour}, 6400, 95},
+		{KeyframeTime{"", 21 * time.Hour}, 3400, 90},
+		{KeyframeTime{"", 23 * time.Hour}, 3400, 90},
+	}
+	keyframeTests := map[string]NextUpdateTestCase{
+		"same values": {
+			time.Date(2025, time.April, 15, 12, 0, 0, 0, time.Local),
+			time.Date(2025, time.April, 15, 18, 0, 0, 0, time.Local),
+		},
+		"at keyframe": {
+			time.Date(2025, time.April, 15, 8, 0, 0, 0, time.Local),
+			time.Date(2025, time.April, 15, 18, 0, 0, 0, time.Local),
+		},
+		"slow change": {
+			time.Date(2025, time.April, 15, 19, 0, 0, 0, time.Local),
+			time.Date(2025, time.April, 15, 19, 1, 12, 0, time.Local),
+		},
+		"fast change": {
+			time.Date(2025, time.April, 15, 20, 30, 0, 0, time.Local),
+			time.Date(2025, time.April, 15, 20, 30, 10, 0, time.Local),
+		},
+		"before keyframe": {
+			time.Date(2025, time.April, 15, 19, 59, 0, 0, time.Local),
+			time.Date(2025, time.April, 15, 20, 0, 0, 0, time.Local),
+		},
+		"night": {
+			time.Date(2025, time.April, 15, 22, 0, 0, 0, time.Local),
+			time.Date(2025, time.April, 15, 23, 0, 0, 0, time.Local),
+		},
+	}
+	for label, test := range keyframeTests {
+		t.Run("keyframes "+label, func(t *testing.T) {
+			if next := NextUpdate(keyframeCflags, test.t); !next.Equal(test.expected) {
+				t.Errorf("Got %v instead of %v", next, test.expected)
+			}
+		})
+	}
+	t.Run("loop interval", func(t *testing.T) {
+		cflags := cflags
+		cflags.LoopInterval = time.Minute
+		when := time.Date(2025, time.April, 15, 21, 30, 0, 0, time.Local)
+		if next := NextUpdate(cflags, when); !next.Equal(when.Add(time.Minute)) {
+			t.Errorf("Got %v", next)
+		}
+	})
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"log/slog"
+	"math"
+	"time"
+)
+
+const (
+	DefaultElevationNight = -6.0
+	DefaultElevationDay   = 3.0
+	// elevationSearchStep and elevationSearchRange limit the search for the
+	// next phase change in elevation mode
+	elevationSearchStep  = time.Minute
+	elevationSearchRange = 48 * time.Hour
+)
+
+func deg2rad(d float64) float64 {
+	return d * math.Pi / 180
+}
+
+func rad2deg(r float64) float64 {
+	return r * 180 / math.Pi
+}
+
+// SolarElevation returns the geometric elevation of the sun's center above
+// the horizon in degrees, at the given time and location.
+// This follows the NOAA solar calculator, see
+// https://gml.noaa.gov/grad/solcalc/calcdetails.html
+// Atmospheric refraction is not taken into account.
+func SolarElevation(when time.Time, latitude, longitude float64) float64 {
+	julianDay := float64(when.UnixNano())/float64(24*time.Hour) + 2440587.5
+	jc := (julianDay - 2451545) / 36525
+	meanLong := math.Mod(280.46646+jc*(36000.76983+jc*0.0003032), 360)
+	meanAnom := 357.52911 + jc*(35999.05029-0.0001537*jc)
+	eccent := 0.016708634 - jc*(0.000042037+0.0000001267*jc)
+	eqOfCenter := math.Sin(deg2rad(meanAnom))*(1.914602-jc*(0.004817+0.000014*jc)) +
+		math.Sin(deg2rad(2*meanAnom))*(0.019993-0.000101*jc) +
+		math.Sin(deg2rad(3*meanAnom))*0.000289
+	omega := deg2rad(125.04 - 1934.136*jc)
+	appLong := meanLong + eqOfCenter - 0.00569 - 0.00478*math.Sin(omega)
+	meanObliq := 23 + (26+(21.448-jc*(46.815+jc*(0.00059-jc*0.001813)))/60)/60
+	obliq := deg2rad(meanObliq + 0.00256*math.Cos(omega))
+	declination := math.Asin(math.Sin(obliq) * math.Sin(deg2rad(appLong)))
+	y := math.Pow(math.Tan(obliq/2), 2)
+	l0, m := deg2rad(meanLong), deg2rad(meanAnom)
+	// equation of time in minutes
+	eqOfTime := 4 * rad2deg(y*math.Sin(2*l0)-2*eccent*math.Sin(m)+
+		4*eccent*y*math.Sin(m)*math.Cos(2*l0)-
+		0.5*y*y*math.Sin(4*l0)-1.25*eccent*eccent*math.Sin(2*m))
+	utc := when.UTC()
+	minutes := float64(utc.Hour()*60+utc.Minute()) + float64(utc.Second())/60
+	trueSolarTime := math.Mod(minutes+eqOfTime+4*longitude, 1440)
+	if trueSolarTime < 0 {
+		trueSolarTime += 1440
+	}
+	hourAngle := deg2rad(trueSolarTime/4 - 180)
+	lat := deg2rad(latitude)
+	cosZenith := math.Sin(lat)*math.Sin(declination) + math.Cos(lat)*math.Cos(declination)*math.Cos(hourAngle)
+	return 90 - rad2deg(math.Acos(clamp(cosZenith, -1, 1)))
+}
+
+// ElevationBrightness maps a sun elevation to brightness. At or below
+// nightElevation it is 0.0, at or above dayElevation it is 1.0, linear in
+// between.
+func ElevationBrightness(elevation, nightElevation, dayElevation float64) float64 {
+	return roundFloat3(clamp((elevation-nightElevation)/(dayElevation-nightElevation), 0, 1))
+}
+
+// GetElevationBrightness returns the brightness at given location based on
+// the current sun elevation
+func GetElevationBrightness(when time.Time, latitude, longitude, nightElevation, dayElevation float64) float64 {
+	elevation := SolarElevation(when, latitude, longitude)
+	slog.Debug("calculated sun elevation", "elevation", elevation, "lat", latitude, "lon", longitude)
+	return ElevationBrightness(elevation, nightElevation, dayElevation)
+}
+
+// ElevationPhase returns the phase in elevation mode. Transitions are
+// sunrise or sunset depending on whether the sun is rising or setting.
+func ElevationPhase(when time.Time, latitude, longitude, nightElevation, dayElevation float64) Phase {
+	elevation := SolarElevation(when, latitude, longitude)
+	switch {
+	case elevation <= nightElevation:
+		return PhaseNight
+	case elevation >= dayElevation:
+		return PhaseDay
+	case SolarElevation(when.Add(time.Minute), latitude, longitude) > elevation:
+		return PhaseSunrise
+	}
+	return PhaseSunset
+}
+
+// NextElevationChange returns the time of the next phase change in
+// elevation mode and the phase starting then, with a precision of
+// elevationSearchStep. If there is no change within elevationSearchRange
+// (e. g. during polar night), the zero time is returned.
+func NextElevationChange(when time.Time, latitude, longitude, nightElevation, dayElevation float64) (time.Time, Phase) {
+	current := ElevationPhase(when, latitude, longitude, nightElevation, dayElevation)
+	for t := when.Add(elevationSearchStep); t.Before(when.Add(elevationSearchRange)); t = t.Add(elevationSearchStep) {
+		phase := ElevationPhase(t, latitude, longitude, nightElevation, dayElevation)
+		if phase != current {
+			return t, phase
+		}
+	}
+	return time.Time{}, current
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"github.com/nathan-osman/go-sunrise"
+)
+
+type SolarElevationTestCase struct {
+	t         time.Time
+	latitude  float64
+	longitude float64
+	expected  float64
+}
+
+func TestSolarElevation(t *testing.T) {
+	tests := map[string]SolarElevationTestCase{
+		"Greenwich, summer solstice noon": {
+			time.Date(2025, time.June, 21, 12, 2, 0, 0, time.UTC), 51.48, 0.0, 61.96,
+		},
+		"Greenwich, summer solstice midnight": {
+			time.Date(2025, time.June, 21, 0, 2, 0, 0, time.UTC), 51.48, 0.0, -15.07,
+		},
+		"Equator, equinox noon": {
+			time.Date(2025, time.March, 20, 12, 7, 0, 0, time.UTC), 0.0, 0.0, 89.9,
+		},
+		"Tromsø, winter solstice noon": {
+			time.Date(2025, time.December, 21, 10, 45, 0, 0, time.UTC), 69.65, 18.96, -3.09,
+		},
+	}
+	for label, test := range tests {
+		t.Run(label, func(t *testing.T) {
+			result := SolarElevation(test.t, test.latitude, test.longitude)
+			if math.Abs(result-test.expected) > 0.15 {
+				t.Errorf("Elevation %f not close to expected %f", result, test.expected)
+			}
+		})
+	}
+}
+
+// At sunrise and sunset as calculated by go-sunrise, the sun's center is
+// 0.833 degrees below the horizon (accounting for refraction and its radius).
+func TestSolarElevationMatchesSunrise(t *testing.T) {
+	for _, month := range []time.Month{time.January, time.April, time.July, time.October} {
+		rise, set := sunrise.SunriseSunset(DefaultLatitude, DefaultLongitude, 2025, month, 15)
+		for _, when := range []time.Time{rise, set} {
+			if elevation := SolarElevation(when, DefaultLatitude, DefaultLongitude); math.Abs(elevation+0.833) > 0.2 {
+				t.Errorf("Elevation at %v is %f", when, elevation)
+			}
+		}
+	}
+}
+
+type ElevationBrightnessTestCase struct {
+	elevation float64
+	expected  float64
+}
+
+func TestElevationBrightness(t *testing.T) {
+	tests := map[string]ElevationBrightnessTestCase{
+		"deep night":      {-30, 0.0},
+		"civil twilight":  {-6, 0.0},
+		"horizon":         {0, 0.667},
+		"end of twilight": {3, 1.0},
+		"noon":            {60, 1.0},
+	}
+	for label, test := range tests {
+		t.Run(label, func(t *testing.T) {
+			if result := ElevationBrightness(test.elevation, DefaultElevationNight, DefaultElevationDay); result != test.expected {
+				t.Errorf("Brightness %f not equal to expected %f", result, test.expected)
+			}
+		})
+	}
+}
+
+func TestElevationPhases(t *testing.T) {
+	morning := time.Date(2025, time.April, 15, 6, 30, 0, 0, time.Local)
+	if phase := ElevationPhase(morning, DefaultLatitude, DefaultLongitude, DefaultElevationNight, DefaultElevationDay); phase != PhaseSunrise {
+		t.Errorf("Got phase %s in the morning", phase)
+	}
+	evening := time.Date(2025, time.April, 15, 20, 30, 0, 0, time.Local)
+	if phase := ElevationPhase(evening, DefaultLatitude, DefaultLongitude, DefaultElevationNight, DefaultElevationDay); phase != PhaseSunset {
+		t.Errorf("Got phase %s in the evening", phase)
+	}
+	next, phase := NextElevationChange(evening, DefaultLatitude, DefaultLongitude, DefaultElevationNight, DefaultElevationDay)
+	if phase != PhaseNight || next.Sub(evening) > time.Hour {
+		t.Errorf("Got next change %v (%s)", next, phase)
+	}
+	// Midnight sun in Tromsø, the sun stays above 3 degrees
+	polar := time.Date(2025, time.June, 21, 12, 0, 0, 0, time.UTC)
+	next, phase = NextElevationChange(polar, 69.65, 18.96, DefaultElevationNight, 2)
+	if !next.IsZero() || phase != PhaseDay {
+		t.Errorf("Got next change %v (%s) during polar day", next, phase)
+	}
+}
+
+func TestGetBrightnessElevation(t *testing.T) {
+	cflags := statusTestConfig()
+	cflags.Elevation = true
+	cflags.ElevationNight = DefaultElevationNight
+	cflags.ElevationDay = DefaultElevationDay
+	noon := time.Date(2025, time.April, 15, 13, 0, 0, 0, time.Local)
+	if brightness, _ := GetBrightness(cflags, noon); brightness != 1.0 {
+		t.Errorf("Got brightness %f at noon", brightness)
+	}
+	s, _ := GetStatus(cflags, noon)
+	if s.Source != "elevation" || s.Phase != PhaseDay || s.NextPhase != PhaseSunset {
+		t.Errorf("Got status %+v", s)
+	}
+}
+
+func TestElevationFlags(t *testing.T) {
+	_, _, err := GetFlags("foo", []string{"-elevation", "-elevationNight", "5"})
+	if err == nil {
+		t.Errorf("Expected error, got nil")
+	}
+}
@@ -0,0 +1,235 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// This is a parser for the subset of TOML needed for the nerdshade config
+// file. Supported are comments, bare keys, basic and literal strings,
+// integers, floats, booleans, single line arrays of those, tables ([name])
+// and arrays of tables ([[name]]).
+// Every value remembers the line it was defined in, so errors can point
+// the user to the right place.
+
+// tomlValue is a single key/value pair. value is one of string, int64,
+// float64, bool or []any.
+type tomlValue struct {
+	key   string
+	value any
+	line  int
+}
+
+// tomlTable is either the root table, a [table] or one element of an
+// [[array]] of tables.
+type tomlTable struct {
+	name   string
+	line   int
+	values []tomlValue
+}
+
+// tomlDoc is a parsed file. tables holds all tables but the root table in
+// the order they appear.
+type tomlDoc struct {
+	file   string
+	root   *tomlTable
+	tables []*tomlTable
+}
+
+// ConfigError is an error located in a config file
+type ConfigError struct {
+	File string
+	Line int
+	Key  string
+	Err  error
+}
+
+func (e *ConfigError) Error() string {
+	if e.Key == "" {
+		return fmt.Sprintf("%s:%d: %v", e.File, e.Line, e.Err)
+	}
+	return fmt.Sprintf("%s:%d: %s: %v", e.File, e.Line, e.Key, e.Err)
+}
+
+func (e *ConfigError) Unwrap() error {
+	return e.Err
+}
+
+// Tables returns all tables with the given name, in order
+func (d *tomlDoc) Tables(name string) (tables []*tomlTable) {
+	for _, t := range d.tables {
+		if t.name == name {
+			tables = append(tables, t)
+		}
+	}
+	return
+}
+
+// parseToml reads a document. filename is only used for error messages.
+func parseToml(r io.Reader, filename string) (*tomlDoc, error) {
+	doc := &tomlDoc{file: filename, root: &tomlTable{}}
+	current := doc.root
+	seen := map[string]bool{}
+	scanner := bufio.NewScanner(r)
+	for lineNo := 1; scanner.Scan(); lineNo++ {
+		fail := func(key string, err error) (*tomlDoc, error) {
+			return nil, &ConfigError{filename, lineNo, key, err}
+		}
+		line := strings.TrimSpace(stripComment(scanner.Text()))
+		if line == "" {
+			continue
+		}
+		if strings.HasPrefix(line, "[") {
+			isArray := strings.HasPrefix(line, "[[")
+			prefix, suffix := "[", "]"
+			if isArray {
+				prefix, suffix = "[[", "]]"
+			}
+			name, hasSuffix := strings.CutSuffix(strings.TrimPrefix(line, prefix), suffix)
+			name = strings.TrimSpace(name)
+			if !hasSuffix || !isBareKey(name) {
+				return fail("", fmt.Errorf("invalid table header %q", line))
+			}
+			if !isArray {
+				if seen[name] {
+					return fail("", fmt.Errorf("table [%s] defined twice", name))
+				}
+				seen[name] = true
+			}
+			current = &tomlTable{name: name, line: lineNo}
+			doc.tables = append(doc.tables, current)
+			continue
+		}
+		key, rawValue, found := strings.Cut(line, "=")
+		key = strings.TrimSpace(key)
+		if !found || !isBareKey(key) {
+			return fail("", fmt.Errorf("expected key = value, got %q", line))
+		}
+		for _, v := range current.values {
+			if v.key == key {
+				return fail(key, fmt.Errorf("defined twice, first in line %d", v.line))
+			}
+		}
+		value, rest, err := parseTomlValue(strings.TrimSpace(rawValue))
+		if err != nil {
+			return fail(key, err)
+		}
+		if strings.TrimSpace(rest) != "" {
+			return fail(key, fmt.Errorf("unexpected %q after value", rest))
+		}
+		current.values = append(current.values, tomlValue{key, value, lineNo})
+	}
+	return doc, scanner.Err()
+}
+
+// stripComment removes a trailing comment, taking care of '#' in strings
+func stripComment(line string) string {
+	var quote rune
+	escaped := false
+	for i, c := range line {
+		switch {
+		case escaped:
+			escaped = false
+		case c == '\\' && quote == '"':
+			escaped = true
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			}
+		case c == '"' || c == '\'':
+			quote = c
+		case c == '#':
+			return line[:i]
+		}
+	}
+	return line
+}
+
+func isBareKey(key string) bool {
+	if key == "" {
+		return false
+	}
+	for _, c := range key {
+		if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '_' || c == '-') {
+			return false
+		}
+	}
+	return true
+}
+
+// parseTomlValue parses the value at the start of s and returns the rest
+func parseTomlValue(s string) (any, string, error) {
+	switch {
+	case s == "":
+		return nil, "", errors.New("missing value")
+	case s[0] == '"':
+		end := 1
+		for ; end < len(s) && s[end] != '"'; end++ {
+			if s[end] == '\\' {
+				end++
+			}
+		}
+		if end >= len(s) {
+			return nil, "", errors.New("unterminated string")
+		}
+		value, err := strconv.Unquote(s[:end+1])
+		return value, s[end+1:], err
+	case s[0] == '\'':
+		end := strings.IndexByte(s[1:], '\'')
+		if end < 0 {
+			return nil, "", errors.New("unterminated string")
+		}
+		return s[1 : end+1], s[end+2:], nil
+	case s[0] == '[':
+		var values []any
+		s = strings.TrimSpace(s[1:])
+		for !strings.HasPrefix(s, "]") {
+			value, rest, err := parseTomlValue(s)
+			if err != nil {
+				return nil, "", err
+			}
+			values = append(values, value)
+			s = strings.TrimSpace(rest)
+			if strings.HasPrefix(s, ",") {
+				s = strings.TrimSpace(s[1:])
+			} else if !strings.HasPrefix(s, "]") {
+				return nil, "", errors.New("expected , or ] in array")
+			}
+		}
+		return values, s[1:], nil
+	}
+	end := strings.IndexAny(s, ",] \t")
+	if end < 0 {
+		end = len(s)
+	}
+	word, rest := s[:end], s[end:]
+	switch word {
+	case "true":
+		return true, rest, nil
+	case "false":
+		return false, rest, nil
+	}
+	if i, err := strconv.ParseInt(strings.ReplaceAll(word, "_", ""), 10, 64); err == nil {
+		return i, rest, nil
+	}
+	if f, err := strconv.ParseFloat(strings.ReplaceAll(word, "_", ""), 64); err == nil {
+		return f, rest, nil
+	}
+	return nil, "", fmt.Errorf("invalid value %q (strings need to be quoted)", word)
+}
+
+// tomlString formats a value in the form command line flags expect
+func tomlString(value any) string {
+	switch v := value.(type) {
+	case string:
+		return v
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	default:
+		return fmt.Sprint(v)
+	}
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseToml(t *testing.T) {
+	input := `# nerdshade config
+tempNight = 3500 # warmer
+latitude = 48.5
+debug = true
+fixedWakeup = "6:30"
+hyperctl = '/usr/bin/hyprctl'
+days = ["mon", 'tue', 3]
+escaped = "a \"#\" b"
+
+[section]
+key = -1_000
+
+[[list]]
+at = "12:00"
+[[list]]
+at = "22:00"
+`
+	doc, err := parseToml(strings.NewReader(input), "test.toml")
+	if err != nil {
+		t.Fatalf("Got error %v", err)
+	}
+	expected := []tomlValue{
+		{"tempNight", int64(3500), 2},
+		{"latitude", 48.5, 3},
+		{"debug", true, 4},
+		{"fixedWakeup", "6:30", 5},
+		{"hyperctl", "/usr/bin/hyprctl", 6},
+		{"days", []any{"mon", "tue", int64(3)}, 7},
+		{"escaped", "a \"#\" b", 8},
+	}
+	if !reflect.DeepEqual(doc.root.values, expected) {
+		t.Errorf("Got\n%v instead of\n%v", doc.root.values, expected)
+	}
+	if len(doc.tables) != 3 {
+		t.Fatalf("Got %d tables instead of 3", len(doc.tables))
+	}
+	if v := doc.tables[0].values[0]; v.key != "key" || v.value != int64(-1000) || v.line != 11 {
+		t.Errorf("Got %v in [section]", v)
+	}
+	if lists := doc.Tables("list"); len(lists) != 2 || lists[1].values[0].value != "22:00" || lists[1].line != 15 {
+		t.Errorf("Got %v for [[list]]", lists)
+	}
+}
+
+type ParseTomlErrorTestCase struct {
+	input    string
+	expected string
+}
+
+func TestParseTomlErrors(t *testing.T) {
+	tests := map[string]ParseTomlErrorTestCase{
+		"unquoted string": {
+			"a = 1\nb = foo",
+			"test.toml:2: b: invalid value \"foo\" (strings need to be quoted)",
+		},
+		"missing value": {
+			"a =",
+			"test.toml:1: a: missing value",
+		},
+		"no key": {
+			"\n\n= 3",
+			"test.toml:3: expected key = value, got \"= 3\"",
+		},
+		"duplicate key": {
+			"a = 1\na = 2",
+			"test.toml:2: a: defined twice, first in line 1",
+		},
+		"duplicate table": {
+			"[a]\n[a]",
+			"test.toml:2: table [a] defined twice",
+		},
+		"unterminated string": {
+			"a = \"foo",
+			"test.toml:1: a: unterminated string",
+		},
+		"garbage after value": {
+			"a = \"foo\" bar",
+			"test.toml:1: a: unexpected \" bar\" after value",
+		},
+		"broken table header": {
+			"[a",
+			"test.toml:1: invalid table header \"[a\"",
+		},
+	}
+	for label, test := range tests {
+		t.Run(label, func(t *testing.T) {
+			_, err := parseToml(strings.NewReader(test.input), "test.toml")
+			if err == nil || err.Error() != test.expected {
+				t.Errorf("Got error %v instead of %s", err, test.expected)
+			}
+		})
+	}
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"strings"
+	"time"
+)
+
+// WaybarStatus is the JSON object waybar (and compatible bars) expect from
+// a custom module with "return-type": "json".
+type WaybarStatus struct {
+	Text       string `json:"text"`
+	Tooltip    string `json:"tooltip"`
+	Class      string `json:"class"`
+	Percentage int    `json:"percentage"`
+}
+
+// NewWaybarStatus builds the module output from a status and the override
+// currently in effect, if any.
+func NewWaybarStatus(s Status, override *Override) WaybarStatus {
+	temperature, gamma := s.Temperature, s.Gamma
+	class := string(s.Phase)
+	var tooltip strings.Builder
+	if override != nil {
+		temperature, gamma = override.Values(temperature, gamma)
+		class = "override"
+		if override.Pause {
+			class = "paused"
+		}
+		fmt.Fprintf(&tooltip, "%s\n", override)
+	}
+	phase := string(s.Phase)
+	if s.Phase == PhaseSunrise || s.Phase == PhaseSunset {
+		phase += " transition"
+	}
+	fmt.Fprintf(&tooltip, "%dK, gamma %d%%, %s\n", temperature, gamma, phase)
+	fmt.Fprintf(&tooltip, "next change: %s", s.NextChangeString())
+	return WaybarStatus{
+		Text:       fmt.Sprintf("%dK", temperature),
+		Tooltip:    tooltip.String(),
+		Class:      class,
+		Percentage: int(math.Round(s.Brightness * 100)),
+	}
+}
+
+// WriteWaybar writes one line of module output for the given time. If
+// ambient is not nil, the values are blended with the ambient light.
+func WriteWaybar(w io.Writer, cflags Config, when time.Time, override *Override, ambient *AmbientLight) error {
+	s, err := GetStatus(cflags, when)
+	if err != nil {
+		return err
+	}
+	return json.NewEncoder(w).Encode(NewWaybarStatus(ambient.BlendStatus(cflags, s), override))
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+type WaybarStatusTestCase struct {
+	override *Override
+	expected WaybarStatus
+}
+
+func TestNewWaybarStatus(t *testing.T) {
+	s := Status{
+		Time:        time.Date(2025, time.April, 15, 19, 30, 0, 0, time.Local),
+		Brightness:  0.728,
+		Temperature: 5820,
+		Gamma:       97,
+		Phase:       PhaseSunset,
+		NextChange:  time.Date(2025, time.April, 15, 20, 14, 0, 0, time.Local),
+		NextPhase:   PhaseNight,
+	}
+	tests := map[string]WaybarStatusTestCase{
+		"schedule": {
+			nil,
+			WaybarStatus{"5820K", "5820K, gamma 97%, sunset transition\nnext change: 20:14 (night, in 44m0s)", "sunset", 73},
+		},
+		"paused": {
+			&Override{Pause: true},
+			WaybarStatus{"6500K", "paused\n6500K, gamma 100%, sunset transition\nnext change: 20:14 (night, in 44m0s)", "paused", 73},
+		},
+		"override": {
+			&Override{Temperature: 3500},
+			WaybarStatus{"3500K", "temperature set to 3500K\n3500K, gamma 97%, sunset transition\nnext change: 20:14 (night, in 44m0s)", "override", 73},
+		},
+	}
+	for label, test := range tests {
+		t.Run(label, func(t *testing.T) {
+			if got := NewWaybarStatus(s, test.override); got != test.expected {
+				t.Errorf("Got\n%+v instead of\n%+v", got, test.expected)
+			}
+		})
+	}
+}
+
+func TestWriteWaybar(t *testing.T) {
+	var out bytes.Buffer
+	when := time.Date(2025, time.April, 15, 12, 0, 0, 0, time.Local)
+	if err := WriteWaybar(&out, statusTestConfig(), when, nil, nil); err != nil {
+		t.Fatalf("Got error %v", err)
+	}
+	if out.Bytes()[out.Len()-1] != '\n' || bytes.Count(out.Bytes(), []byte("\n")) != 1 {
+		t.Errorf("Output is not a single line: %q", out.String())
+	}
+	var s WaybarStatus
+	if err := json.Unmarshal(out.Bytes(), &s); err != nil {
+		t.Fatalf("Output is not valid JSON: %v", err)
+	}
+	if s.Text != "6500K" || s.Class != "day" || s.Percentage != 100 {
+		t.Errorf("Got %+v", s)
+	}
+}
+
+func TestWaybarImpliesLoop(t *testing.T) {
+	c, _, err := GetFlags("foo", []string{"-waybar"})
+	if err != nil || !c.Loop {
+		t.Errorf("Got %v, loop %v", err, c.Loop)
+	}
+}
@@ -0,0 +1,260 @@
+package main
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"syscall"
+)
+
+// This is a minimal implementation of the Wayland wire protocol, just enough
+// to bind globals and talk to simple protocols like wlr-gamma-control.
+// See https://wayland.freedesktop.org/docs/html/ch04.html#sect-Protocol-Wire-Format
+
+const (
+	wlDisplayID = 1
+
+	// wl_display requests
+	wlDisplaySync        = 0
+	wlDisplayGetRegistry = 1
+	// wl_display events
+	wlDisplayError    = 0
+	wlDisplayDeleteID = 1
+
+	// wl_registry requests
+	wlRegistryBind = 0
+	// wl_registry events
+	wlRegistryGlobal       = 0
+	wlRegistryGlobalRemove = 1
+
+	// wl_callback events
+	wlCallbackDone = 0
+
+	wlHeaderSize = 8
+)
+
+// wlFd marks a message argument as file descriptor, which is sent out of band
+type wlFd int
+
+// wlMessage is a single request or event
+type wlMessage struct {
+	object uint32
+	opcode uint16
+	data   []byte
+}
+
+// wlMarshal encodes a message. Supported argument types are uint32 (for
+// uint, object and new_id), int32, string and wlFd.
+func wlMarshal(object uint32, opcode uint16, args ...any) (msg []byte, fds []int) {
+	msg = make([]byte, wlHeaderSize)
+	for _, arg := range args {
+		switch v := arg.(type) {
+		case uint32:
+			msg = binary.NativeEndian.AppendUint32(msg, v)
+		case int32:
+			msg = binary.NativeEndian.AppendUint32(msg, uint32(v))
+		case string:
+			msg = binary.NativeEndian.AppendUint32(msg, uint32(len(v)+1))
+			msg = append(msg, v...)
+			msg = append(msg, 0)
+			for len(msg)%4 != 0 {
+				msg = append(msg, 0)
+			}
+		case wlFd:
+			fds = append(fds, int(v))
+		default:
+			panic(fmt.Sprintf("unsupported wayland argument type %T", arg))
+		}
+	}
+	binary.NativeEndian.PutUint32(msg[0:], object)
+	binary.NativeEndian.PutUint32(msg[4:], uint32(len(msg))<<16|uint32(opcode))
+	return msg, fds
+}
+
+// wlArgs decodes the arguments of a message in order. After the first
+// error all further calls return zero values and the error is kept.
+type wlArgs struct {
+	data []byte
+	err  error
+}
+
+func (a *wlArgs) Uint32() uint32 {
+	if a.err != nil {
+		return 0
+	}
+	if len(a.data) < 4 {
+		a.err = errors.New("wayland message too short")
+		return 0
+	}
+	v := binary.NativeEndian.Uint32(a.data)
+	a.data = a.data[4:]
+	return v
+}
+
+func (a *wlArgs) String() string {
+	size := int(a.Uint32())
+	if a.err != nil {
+		return ""
+	}
+	padded := (size + 3) &^ 3
+	if size == 0 || len(a.data) < padded {
+		a.err = errors.New("wayland string argument malformed")
+		return ""
+	}
+	s := string(a.data[:size-1])
+	a.data = a.data[padded:]
+	return s
+}
+
+// wlConn is a connection to a Wayland compositor (or, in tests, to a client)
+type wlConn struct {
+	conn   *net.UnixConn
+	lastID uint32
+	rbuf   []byte
+	// fds holds file descriptors received, in order
+	fds []int
+}
+
+// wlSocketPath returns the path of the compositor socket as determined by
+// $WAYLAND_DISPLAY and $XDG_RUNTIME_DIR.
+func wlSocketPath() (string, error) {
+	display := os.Getenv("WAYLAND_DISPLAY")
+	if display == "" {
+		display = "wayland-0"
+	}
+	if filepath.IsAbs(display) {
+		return display, nil
+	}
+	runtimeDir := os.Getenv("XDG_RUNTIME_DIR")
+	if runtimeDir == "" {
+		return "", errors.New("XDG_RUNTIME_DIR not set")
+	}
+	return filepath.Join(runtimeDir, display), nil
+}
+
+func wlDial(path string) (*wlConn, error) {
+	conn, err := net.DialUnix("unix", nil, &net.UnixAddr{Name: path, Net: "unix"})
+	if err != nil {
+		return nil, err
+	}
+	return &wlConn{conn: conn, lastID: wlDisplayID}, nil
+}
+
+func (c *wlConn) Close() error {
+	c.closeFds()
+	return c.conn.Close()
+}
+
+// newID allocates a new client side object id
+func (c *wlConn) newID() uint32 {
+	c.lastID++
+	return c.lastID
+}
+
+func (c *wlConn) send(object uint32, opcode uint16, args ...any) error {
+	msg, fds := wlMarshal(object, opcode, args...)
+	var oob []byte
+	if len(fds) > 0 {
+		oob = syscall.UnixRights(fds...)
+	}
+	_, _, err := c.conn.WriteMsgUnix(msg, oob, nil)
+	return err
+}
+
+// next returns the next message, reading from the connection as needed
+func (c *wlConn) next() (wlMessage, error) {
+	for {
+		if len(c.rbuf) >= wlHeaderSize {
+			sizeOpcode := binary.NativeEndian.Uint32(c.rbuf[4:])
+			size := int(sizeOpcode >> 16)
+			if size < wlHeaderSize {
+				return wlMessage{}, fmt.Errorf("wayland message with invalid size %d", size)
+			}
+			if len(c.rbuf) >= size {
+				msg := wlMessage{
+					object: binary.NativeEndian.Uint32(c.rbuf),
+					opcode: uint16(sizeOpcode),
+					data:   append([]byte(nil), c.rbuf[wlHeaderSize:size]...),
+				}
+				c.rbuf = c.rbuf[size:]
+				return msg, nil
+			}
+		}
+		buf := make([]byte, 4096)
+		oob := make([]byte, syscall.CmsgSpace(28*4))
+		n, oobn, _, _, err := c.conn.ReadMsgUnix(buf, oob)
+		if err != nil {
+			return wlMessage{}, err
+		}
+		if oobn > 0 {
+			c.fds = append(c.fds, parseUnixRights(oob[:oobn])...)
+		}
+		c.rbuf = append(c.rbuf, buf[:n]...)
+	}
+}
+
+// takeFd removes and returns the oldest received file descriptor
+func (c *wlConn) takeFd() (int, error) {
+	if len(c.fds) == 0 {
+		return -1, errors.New("no file descriptor received")
+	}
+	fd := c.fds[0]
+	c.fds = c.fds[1:]
+	return fd, nil
+}
+
+func (c *wlConn) closeFds() {
+	for _, fd := range c.fds {
+		syscall.Close(fd)
+	}
+	c.fds = nil
+}
+
+// roundtrip sends a sync request and passes all messages to handle until the
+// compositor confirmed it. Protocol errors are returned.
+func (c *wlConn) roundtrip(handle func(wlMessage) error) error {
+	callback := c.newID()
+	err := c.send(wlDisplayID, wlDisplaySync, callback)
+	if err != nil {
+		return err
+	}
+	for {
+		msg, err := c.next()
+		if err != nil {
+			return err
+		}
+		switch {
+		case msg.object == callback && msg.opcode == wlCallbackDone:
+			// Nothing we receive needs file descriptors
+			c.closeFds()
+			return nil
+		case msg.object == wlDisplayID && msg.opcode == wlDisplayError:
+			args := wlArgs{data: msg.data}
+			object, code, text := args.Uint32(), args.Uint32(), args.String()
+			return fmt.Errorf("wayland error on object %d (code %d): %s", object, code, text)
+		case msg.object == wlDisplayID && msg.opcode == wlDisplayDeleteID:
+			continue
+		}
+		err = handle(msg)
+		if err != nil {
+			return err
+		}
+	}
+}
+
+func parseUnixRights(oob []byte) (fds []int) {
+	msgs, err := syscall.ParseSocketControlMessage(oob)
+	if err != nil {
+		return nil
+	}
+	for _, msg := range msgs {
+		rights, err := syscall.ParseUnixRights(&msg)
+		if err == nil {
+			fds = append(fds, rights...)
+		}
+	}
+	return fds
+}
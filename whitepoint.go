@@ -0,0 +1,71 @@
+package main
+
+import (
+	"math"
+)
+
+const (
+	// NeutralTemp is the color temperature at which the screen is not tinted
+	NeutralTemp = 6500
+	// NeutralGamma is the gamma value at which the screen is not dimmed
+	NeutralGamma = 100
+)
+
+// blackbody approximates the RGB color of a black body radiator at the given
+// temperature (in Kelvin), with each channel ranging from 0.0 to 1.0.
+// This is the well known approximation by Tanner Helland, see
+// https://tannerhelland.com/2012/09/18/convert-temperature-rgb-algorithm-code.html
+func blackbody(temperature int) (r, g, b float64) {
+	t := float64(temperature) / 100
+	if t <= 66 {
+		r = 255
+		g = 99.4708025861*math.Log(t) - 161.1195681661
+	} else {
+		r = 329.698727446 * math.Pow(t-60, -0.1332047592)
+		g = 288.1221695283 * math.Pow(t-60, -0.0755148492)
+	}
+	switch {
+	case t >= 66:
+		b = 255
+	case t <= 19:
+		b = 0
+	default:
+		b = 138.5177312231*math.Log(t-10) - 305.0447927307
+	}
+	return clamp(r/255, 0, 1), clamp(g/255, 0, 1), clamp(b/255, 0, 1)
+}
+
+// Whitepoint returns the factors each color channel needs to be multiplied
+// with to shift the screen to the given temperature (in Kelvin).
+// The result is normalized so NeutralTemp yields exactly 1.0 for all channels.
+func Whitepoint(temperature int) (r, g, b float64) {
+	r, g, b = blackbody(temperature)
+	nr, ng, nb := blackbody(NeutralTemp)
+	return clamp(r/nr, 0, 1), clamp(g/ng, 0, 1), clamp(b/nb, 0, 1)
+}
+
+// GammaRamp returns a linear gamma ramp with size entries per channel,
+// tinted to the given temperature (in Kelvin) and dimmed to gamma (in
+// percent). This is the same interpretation of temperature and gamma
+// hyprsunset uses.
+func GammaRamp(size, temperature, gamma int) (r, g, b []uint16) {
+	wr, wg, wb := Whitepoint(temperature)
+	scale := clamp(float64(gamma)/100, 0, 1)
+	r = make([]uint16, size)
+	g = make([]uint16, size)
+	b = make([]uint16, size)
+	for i := range size {
+		v := scale * math.MaxUint16
+		if size > 1 {
+			v *= float64(i) / float64(size-1)
+		}
+		r[i] = uint16(math.Round(v * wr))
+		g[i] = uint16(math.Round(v * wg))
+		b[i] = uint16(math.Round(v * wb))
+	}
+	return
+}
+
+func clamp(val, low, high float64) float64 {
+	return math.Max(low, math.Min(high, val))
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"math"
+	"testing"
+)
+
+type WhitepointTestCase struct {
+	temperature int
+	r, g, b     float64
+}
+
+func TestWhitepoint(t *testing.T) {
+	tests := map[string]WhitepointTestCase{
+		"neutral":       {NeutralTemp, 1.0, 1.0, 1.0},
+		"above neutral": {10000, 0.791, 0.858, 1.0},
+		"day":           {5000, 1.0, 0.897, 0.824},
+		"night":         {4000, 1.0, 0.810, 0.664},
+		"candle":        {1900, 1.0, 0.519, 0.0},
+	}
+	for label, test := range tests {
+		t.Run(label, func(t *testing.T) {
+			r, g, b := Whitepoint(test.temperature)
+			if roundFloat3(r) != test.r || roundFloat3(g) != test.g || roundFloat3(b) != test.b {
+				t.Errorf("Got %.3f/%.3f/%.3f instead of %.3f/%.3f/%.3f", r, g, b, test.r, test.g, test.b)
+			}
+		})
+	}
+}
+
+func TestWhitepointMonotonic(t *testing.T) {
+	// Lowering the temperature must never make a channel brighter
+	pr, pg, pb := Whitepoint(NeutralTemp)
+	for temperature := NeutralTemp - 100; temperature >= 1000; temperature -= 100 {
+		r, g, b := Whitepoint(temperature)
+		if r > pr || g > pg || b > pb {
+			t.Errorf("Channel got brighter at %dK", temperature)
+		}
+		pr, pg, pb = r, g, b
+	}
+}
+
+func TestGammaRamp(t *testing.T) {
+	t.Run("identity", func(t *testing.T) {
+		r, g, b := GammaRamp(256, NeutralTemp, NeutralGamma)
+		for i := range 256 {
+			expected := uint16(math.Round(float64(i) * 65535 / 255))
+			if r[i] != expected || g[i] != expected || b[i] != expected {
+				t.Fatalf("Entry %d is %d/%d/%d instead of %d", i, r[i], g[i], b[i], expected)
+			}
+		}
+	})
+	t.Run("warm and dimmed", func(t *testing.T) {
+		r, g, b := GammaRamp(3, 4000, 50)
+		if r[0] != 0 || g[0] != 0 || b[0] != 0 {
+			t.Errorf("First entry is not black: %d/%d/%d", r[0], g[0], b[0])
+		}
+		if r[2] != 32768 {
+			t.Errorf("Red is %d instead of 32768", r[2])
+		}
+		if !(r[2] > g[2] && g[2] > b[2]) {
+			t.Errorf("Ramp is not warm: %d/%d/%d", r[2], g[2], b[2])
+		}
+	})
+	t.Run("single entry", func(t *testing.T) {
+		r, _, _ := GammaRamp(1, NeutralTemp, NeutralGamma)
+		if r[0] != math.MaxUint16 {
+			t.Errorf("Got %d", r[0])
+		}
+	})
+}
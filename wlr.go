@@ -0,0 +1,242 @@
+package main
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+)
+
+const (
+	wlrGammaManagerInterface = "zwlr_gamma_control_manager_v1"
+	wlOutputInterface        = "wl_output"
+
+	// zwlr_gamma_control_manager_v1 requests
+	wlrGammaManagerGetGammaControl = 0
+	// zwlr_gamma_control_v1 requests
+	wlrGammaControlSetGamma = 0
+	wlrGammaControlDestroy  = 1
+	// zwlr_gamma_control_v1 events
+	wlrGammaControlGammaSize = 0
+	wlrGammaControlFailed    = 1
+)
+
+// wlrGammaControl is the gamma control of a single wl_output
+type wlrGammaControl struct {
+	id     uint32
+	output uint32
+	size   uint32
+}
+
+// WlrOutput is the output backend that talks the wlr-gamma-control protocol
+// to the compositor directly, computing the gamma ramps itself.
+// This works on sway, river, Hyprland and other wlroots based compositors
+// without needing hyprsunset.
+// The compositor resets gamma as soon as the connection is closed, so the
+// backend needs to be kept open for as long as the values should stay in
+// effect.
+type WlrOutput struct {
+	conn     *wlConn
+	registry uint32
+	manager  uint32
+	// pending holds registry names of wl_output globals not yet bound
+	pending []uint32
+	// controls is keyed by registry name of the wl_output global
+	controls    map[uint32]*wlrGammaControl
+	temperature int
+	gamma       int
+}
+
+func NewWlrOutput(cflags Config) (Output, error) {
+	path, err := wlSocketPath()
+	if err != nil {
+		return nil, err
+	}
+	conn, err := wlDial(path)
+	if err != nil {
+		return nil, err
+	}
+	o := &WlrOutput{
+		conn:        conn,
+		controls:    map[uint32]*wlrGammaControl{},
+		temperature: NeutralTemp,
+		gamma:       NeutralGamma,
+	}
+	err = o.setup()
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return o, nil
+}
+
+func (o *WlrOutput) setup() error {
+	o.registry = o.conn.newID()
+	err := o.conn.send(wlDisplayID, wlDisplayGetRegistry, o.registry)
+	if err != nil {
+		return err
+	}
+	err = o.conn.roundtrip(o.handle)
+	if err != nil {
+		return err
+	}
+	if o.manager == 0 {
+		return errors.New("compositor does not support " + wlrGammaManagerInterface)
+	}
+	return o.bindPending()
+}
+
+// bindPending creates gamma controls for all newly announced outputs and
+// waits for the compositor to report their gamma sizes.
+func (o *WlrOutput) bindPending() error {
+	if len(o.pending) == 0 {
+		return nil
+	}
+	for _, name := range o.pending {
+		output := o.conn.newID()
+		err := o.conn.send(o.registry, wlRegistryBind, name, wlOutputInterface, uint32(1), output)
+		if err != nil {
+			return err
+		}
+		control := &wlrGammaControl{id: o.conn.newID(), output: output}
+		err = o.conn.send(o.manager, wlrGammaManagerGetGammaControl, control.id, output)
+		if err != nil {
+			return err
+		}
+		o.controls[name] = control
+	}
+	o.pending = nil
+	return o.conn.roundtrip(o.handle)
+}
+
+// handle processes events from the compositor
+func (o *WlrOutput) handle(msg wlMessage) error {
+	args := wlArgs{data: msg.data}
+	if msg.object == o.registry {
+		switch msg.opcode {
+		case wlRegistryGlobal:
+			name, iface, version := args.Uint32(), args.String(), args.Uint32()
+			if args.err != nil {
+				return args.err
+			}
+			slog.Debug("wayland global", "name", name, "interface", iface, "version", version)
+			switch {
+			case iface == wlrGammaManagerInterface && o.manager == 0:
+				o.manager = o.conn.newID()
+				return o.conn.send(o.registry, wlRegistryBind, name, iface, uint32(1), o.manager)
+			case iface == wlOutputInterface:
+				o.pending = append(o.pending, name)
+			}
+		case wlRegistryGlobalRemove:
+			name := args.Uint32()
+			if control, ok := o.controls[name]; ok {
+				slog.Debug("wayland output removed", "name", name)
+				delete(o.controls, name)
+				return o.conn.send(control.id, wlrGammaControlDestroy)
+			}
+		}
+		return args.err
+	}
+	for name, control := range o.controls {
+		if msg.object != control.id {
+			continue
+		}
+		switch msg.opcode {
+		case wlrGammaControlGammaSize:
+			control.size = args.Uint32()
+			slog.Debug("wayland gamma size", "output", control.output, "size", control.size)
+		case wlrGammaControlFailed:
+			// Another client might hold gamma control for this output
+			slog.Warn("gamma control failed", "output", control.output)
+			delete(o.controls, name)
+			return o.conn.send(control.id, wlrGammaControlDestroy)
+		}
+		return args.err
+	}
+	// Events for other objects (e. g. wl_output geometry) are not needed
+	return nil
+}
+
+func (o *WlrOutput) Name() string {
+	return "wlr"
+}
+
+func (o *WlrOutput) Apply(temperature, gamma int) error {
+	// Pick up outputs that were plugged in since the last call
+	err := o.conn.roundtrip(o.handle)
+	if err != nil {
+		return err
+	}
+	err = o.bindPending()
+	if err != nil {
+		return err
+	}
+	applied := 0
+	for _, control := range o.controls {
+		if control.size == 0 {
+			continue
+		}
+		err = o.setGamma(control, temperature, gamma)
+		if err != nil {
+			return err
+		}
+		applied++
+	}
+	if applied == 0 {
+		return errors.New("no output with gamma control available")
+	}
+	// Make sure failures are reported right away
+	err = o.conn.roundtrip(o.handle)
+	if err != nil {
+		return err
+	}
+	o.temperature = temperature
+	o.gamma = gamma
+	return nil
+}
+
+// setGamma hands a gamma ramp to the compositor. The ramp is passed as file
+// containing red, green and blue ramps of control.size entries each.
+func (o *WlrOutput) setGamma(control *wlrGammaControl, temperature, gamma int) error {
+	r, g, b := GammaRamp(int(control.size), temperature, gamma)
+	buf := make([]byte, 0, len(r)*3*2)
+	for _, ramp := range [][]uint16{r, g, b} {
+		for _, v := range ramp {
+			buf = binary.NativeEndian.AppendUint16(buf, v)
+		}
+	}
+	f, err := os.CreateTemp(os.Getenv("XDG_RUNTIME_DIR"), "nerdshade-gamma-")
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	// Only the file descriptor is needed
+	os.Remove(f.Name())
+	_, err = f.Write(buf)
+	if err != nil {
+		return err
+	}
+	_, err = f.Seek(0, 0)
+	if err != nil {
+		return err
+	}
+	err = o.conn.send(control.id, wlrGammaControlSetGamma, wlFd(f.Fd()))
+	if err != nil {
+		return fmt.Errorf("setting gamma on output %d: %w", control.output, err)
+	}
+	return nil
+}
+
+func (o *WlrOutput) Current() (int, int, error) {
+	return o.temperature, o.gamma, nil
+}
+
+func (o *WlrOutput) Capabilities() Capabilities {
+	return Capabilities{Temperature: true, Gamma: true}
+}
+
+// Close disconnects from the compositor, which restores the original gamma
+func (o *WlrOutput) Close() error {
+	return o.conn.Close()
+}
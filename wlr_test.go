@@ -0,0 +1,172 @@
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+// fakeCompositor is a tiny Wayland compositor that only knows about
+// wl_output and wlr-gamma-control. Gamma ramps set by clients are sent to
+// the ramps channel.
+type fakeCompositor struct {
+	outputs     int
+	withManager bool
+	fail        bool
+	gammaSize   uint32
+	ramps       chan []uint16
+}
+
+// start listens on a socket in a temporary directory and points
+// $WAYLAND_DISPLAY to it.
+func (f *fakeCompositor) start(t *testing.T) {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "wayland-test")
+	l, err := net.ListenUnix("unix", &net.UnixAddr{Name: path, Net: "unix"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+	t.Setenv("WAYLAND_DISPLAY", path)
+	f.ramps = make(chan []uint16, 10)
+	go func() {
+		for {
+			conn, err := l.AcceptUnix()
+			if err != nil {
+				return
+			}
+			go f.serve(&wlConn{conn: conn})
+		}
+	}()
+}
+
+func (f *fakeCompositor) serve(c *wlConn) {
+	defer c.Close()
+	objects := map[uint32]string{wlDisplayID: "wl_display"}
+	for {
+		msg, err := c.next()
+		if err != nil {
+			return
+		}
+		args := wlArgs{data: msg.data}
+		switch fmt.Sprintf("%s.%d", objects[msg.object], msg.opcode) {
+		case "wl_display.0": // sync
+			id := args.Uint32()
+			c.send(id, wlCallbackDone, uint32(0))
+			c.send(wlDisplayID, wlDisplayDeleteID, id)
+		case "wl_display.1": // get_registry
+			id := args.Uint32()
+			objects[id] = "wl_registry"
+			for i := range f.outputs {
+				c.send(id, wlRegistryGlobal, uint32(i+1), wlOutputInterface, uint32(4))
+			}
+			if f.withManager {
+				c.send(id, wlRegistryGlobal, uint32(100), wlrGammaManagerInterface, uint32(1))
+			}
+		case "wl_registry.0": // bind
+			_, iface, _, id := args.Uint32(), args.String(), args.Uint32(), args.Uint32()
+			objects[id] = iface
+		case wlrGammaManagerInterface + ".0": // get_gamma_control
+			id := args.Uint32()
+			objects[id] = "zwlr_gamma_control_v1"
+			if f.fail {
+				c.send(id, wlrGammaControlFailed)
+			} else {
+				c.send(id, wlrGammaControlGammaSize, f.gammaSize)
+			}
+		case "zwlr_gamma_control_v1.0": // set_gamma
+			fd, err := c.takeFd()
+			if err != nil {
+				return
+			}
+			file := os.NewFile(uintptr(fd), "gamma")
+			data, _ := io.ReadAll(file)
+			file.Close()
+			ramp := make([]uint16, len(data)/2)
+			for i := range ramp {
+				ramp[i] = binary.NativeEndian.Uint16(data[i*2:])
+			}
+			f.ramps <- ramp
+		}
+	}
+}
+
+func TestWlrOutput(t *testing.T) {
+	f := &fakeCompositor{outputs: 2, withManager: true, gammaSize: 16}
+	f.start(t)
+	out, err := NewWlrOutput(Config{})
+	if err != nil {
+		t.Fatalf("Got error %v", err)
+	}
+	defer out.Close()
+	err = out.Apply(4000, 90)
+	if err != nil {
+		t.Fatalf("Got error %v", err)
+	}
+	r, g, b := GammaRamp(16, 4000, 90)
+	expected := slices.Concat(r, g, b)
+	for i := range f.outputs {
+		got := <-f.ramps
+		if !slices.Equal(got, expected) {
+			t.Errorf("Ramp %d is\n%v instead of\n%v", i, got, expected)
+		}
+	}
+	temperature, gamma, _ := out.Current()
+	if temperature != 4000 || gamma != 90 {
+		t.Errorf("Got %d/%d instead of 4000/90", temperature, gamma)
+	}
+}
+
+func TestWlrOutputNoManager(t *testing.T) {
+	f := &fakeCompositor{outputs: 1}
+	f.start(t)
+	if _, err := NewWlrOutput(Config{}); err == nil {
+		t.Errorf("Expected error, got nil")
+	}
+}
+
+func TestWlrOutputFailed(t *testing.T) {
+	f := &fakeCompositor{outputs: 1, withManager: true, fail: true}
+	f.start(t)
+	out, err := NewWlrOutput(Config{})
+	if err != nil {
+		t.Fatalf("Got error %v", err)
+	}
+	defer out.Close()
+	if err := out.Apply(4000, 90); err == nil {
+		t.Errorf("Expected error, got nil")
+	}
+}
+
+func TestWlMarshal(t *testing.T) {
+	msg, fds := wlMarshal(3, 1, uint32(7), "abc", wlFd(5))
+	if len(msg) != 8+4+8 {
+		t.Fatalf("Message has wrong size %d", len(msg))
+	}
+	if binary.NativeEndian.Uint32(msg[4:]) != uint32(len(msg))<<16|1 {
+		t.Errorf("Header is wrong: %v", msg[:8])
+	}
+	if !slices.Equal(fds, []int{5}) {
+		t.Errorf("Got fds %v", fds)
+	}
+	args := wlArgs{data: msg[8:]}
+	if v, s := args.Uint32(), args.String(); v != 7 || s != "abc" || args.err != nil {
+		t.Errorf("Got %d %q %v", v, s, args.err)
+	}
+}
+
+func TestWlrNeedsLoop(t *testing.T) {
+	if _, _, err := GetFlags("foo", []string{"-backend", "wlr"}); err == nil {
+		t.Error("Expected error without -loop")
+	}
+	for _, args := range [][]string{{"-backend", "wlr", "-loop"}, {"-backend", "wlr", "-waybar"}, {"-backend", "wlr", "status"}} {
+		if _, _, err := GetFlags("foo", args); err != nil {
+			t.Errorf("Got error %v for %v", err, args)
+		}
+	}
+}
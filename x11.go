@@ -0,0 +1,293 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// This is a minimal implementation of the X11 protocol, just enough to use
+// simple extensions like RandR.
+// See https://www.x.org/releases/X11R7.7/doc/xproto/x11protocol.html
+
+const (
+	x11OpQueryExtension = 98
+	x11AuthName         = "MIT-MAGIC-COOKIE-1"
+	x11FamilyLocal      = 256
+	x11FamilyWild       = 65535
+	x11ReplyHeaderSize  = 32
+)
+
+// x11Display is a parsed $DISPLAY value like "host:1.0"
+type x11Display struct {
+	host   string
+	number int
+	screen int
+}
+
+// x11ParseDisplay parses a display name in the form "[host]:number[.screen]"
+func x11ParseDisplay(display string) (d x11Display, err error) {
+	colon := strings.LastIndex(display, ":")
+	if colon < 0 {
+		return d, fmt.Errorf("Invalid display %q", display)
+	}
+	d.host = display[:colon]
+	number, screen, found := strings.Cut(display[colon+1:], ".")
+	d.number, err = strconv.Atoi(number)
+	if err != nil {
+		return d, fmt.Errorf("Invalid display %q", display)
+	}
+	if found {
+		d.screen, err = strconv.Atoi(screen)
+		if err != nil {
+			return d, fmt.Errorf("Invalid display %q", display)
+		}
+	}
+	return d, nil
+}
+
+// x11Cookie looks up the MIT-MAGIC-COOKIE-1 for the given display number in
+// an Xauthority file. The file format is a sequence of entries of the form
+//
+//	family uint16, address, number, name, data
+//
+// where all but family are byte strings prefixed with their uint16 length,
+// everything big endian.
+func x11Cookie(r io.Reader, number int) ([]byte, error) {
+	want := strconv.Itoa(number)
+	readString := func() ([]byte, error) {
+		var size uint16
+		err := binary.Read(r, binary.BigEndian, &size)
+		if err != nil {
+			return nil, err
+		}
+		s := make([]byte, size)
+		_, err = io.ReadFull(r, s)
+		return s, err
+	}
+	for {
+		var family uint16
+		err := binary.Read(r, binary.BigEndian, &family)
+		if err == io.EOF {
+			return nil, errors.New("No matching Xauthority entry found")
+		}
+		if err != nil {
+			return nil, err
+		}
+		var fields [4][]byte
+		for i := range fields {
+			fields[i], err = readString()
+			if err != nil {
+				return nil, err
+			}
+		}
+		_, num, name, data := fields[0], string(fields[1]), string(fields[2]), fields[3]
+		if family != x11FamilyLocal && family != x11FamilyWild {
+			continue
+		}
+		if (num == want || num == "") && name == x11AuthName {
+			return data, nil
+		}
+	}
+}
+
+// x11Conn is a connection to an X server
+type x11Conn struct {
+	conn net.Conn
+	root uint32
+}
+
+// x11Dial connects to the X server given in $DISPLAY and authenticates
+// using $XAUTHORITY (or ~/.Xauthority) if possible.
+func x11Dial() (*x11Conn, error) {
+	display, err := x11ParseDisplay(os.Getenv("DISPLAY"))
+	if err != nil {
+		return nil, err
+	}
+	var conn net.Conn
+	if display.host == "" || display.host == "unix" {
+		conn, err = net.Dial("unix", fmt.Sprintf("/tmp/.X11-unix/X%d", display.number))
+	} else {
+		conn, err = net.Dial("tcp", net.JoinHostPort(display.host, strconv.Itoa(6000+display.number)))
+	}
+	if err != nil {
+		return nil, err
+	}
+	c := &x11Conn{conn: conn}
+	err = c.setup(display, x11AuthCookie(display.number))
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return c, nil
+}
+
+// x11AuthCookie returns the authentication cookie or nil if none was found.
+// Some servers (e. g. Xvfb by default) do not need one.
+func x11AuthCookie(number int) []byte {
+	path := os.Getenv("XAUTHORITY")
+	if path == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return nil
+		}
+		path = filepath.Join(home, ".Xauthority")
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return nil
+	}
+	defer f.Close()
+	cookie, err := x11Cookie(f, number)
+	if err != nil {
+		return nil
+	}
+	return cookie
+}
+
+func (c *x11Conn) setup(display x11Display, cookie []byte) error {
+	var authName string
+	if cookie != nil {
+		authName = x11AuthName
+	}
+	req := []byte{'l', 0}
+	req = binary.LittleEndian.AppendUint16(req, 11)
+	req = binary.LittleEndian.AppendUint16(req, 0)
+	req = binary.LittleEndian.AppendUint16(req, uint16(len(authName)))
+	req = binary.LittleEndian.AppendUint16(req, uint16(len(cookie)))
+	req = append(req, 0, 0)
+	req = x11AppendPadded(req, []byte(authName))
+	req = x11AppendPadded(req, cookie)
+	_, err := c.conn.Write(req)
+	if err != nil {
+		return err
+	}
+	header := make([]byte, 8)
+	_, err = io.ReadFull(c.conn, header)
+	if err != nil {
+		return err
+	}
+	data := make([]byte, int(binary.LittleEndian.Uint16(header[6:]))*4)
+	_, err = io.ReadFull(c.conn, data)
+	if err != nil {
+		return err
+	}
+	if header[0] != 1 {
+		reason := data
+		if header[0] == 0 {
+			reason = data[:min(int(header[1]), len(data))]
+		}
+		return fmt.Errorf("X server refused connection: %s", bytes.TrimRight(reason, "\x00"))
+	}
+	return c.parseSetup(data, display.screen)
+}
+
+// parseSetup finds the root window of the given screen in the setup reply
+func (c *x11Conn) parseSetup(data []byte, screen int) error {
+	if len(data) < 32 {
+		return errors.New("X setup reply too short")
+	}
+	vendorLen := int(binary.LittleEndian.Uint16(data[16:]))
+	numScreens := int(data[20])
+	numFormats := int(data[21])
+	offset := 32 + x11Pad(vendorLen) + numFormats*8
+	if screen >= numScreens {
+		return fmt.Errorf("Screen %d not available", screen)
+	}
+	for i := 0; ; i++ {
+		if len(data) < offset+40 {
+			return errors.New("X setup reply too short")
+		}
+		if i == screen {
+			c.root = binary.LittleEndian.Uint32(data[offset:])
+			return nil
+		}
+		numDepths := int(data[offset+39])
+		offset += 40
+		for range numDepths {
+			if len(data) < offset+8 {
+				return errors.New("X setup reply too short")
+			}
+			numVisuals := int(binary.LittleEndian.Uint16(data[offset+2:]))
+			offset += 8 + numVisuals*24
+		}
+	}
+}
+
+func (c *x11Conn) Close() error {
+	return c.conn.Close()
+}
+
+// request sends a request with the given major and minor opcode and body.
+// body must already be padded to a multiple of 4 bytes.
+func (c *x11Conn) request(major, minor byte, body []byte) error {
+	req := []byte{major, minor}
+	req = binary.LittleEndian.AppendUint16(req, uint16(1+len(body)/4))
+	_, err := c.conn.Write(append(req, body...))
+	return err
+}
+
+// reply reads the reply to the last request. Events are skipped, errors are
+// returned as such.
+func (c *x11Conn) reply() ([]byte, error) {
+	for {
+		header := make([]byte, x11ReplyHeaderSize)
+		_, err := io.ReadFull(c.conn, header)
+		if err != nil {
+			return nil, err
+		}
+		switch header[0] {
+		case 0:
+			return nil, fmt.Errorf("X error %d (major opcode %d, minor opcode %d)",
+				header[1], header[10], binary.LittleEndian.Uint16(header[8:]))
+		case 1:
+			extra := make([]byte, int(binary.LittleEndian.Uint32(header[4:]))*4)
+			_, err = io.ReadFull(c.conn, extra)
+			if err != nil {
+				return nil, err
+			}
+			return append(header, extra...), nil
+		}
+	}
+}
+
+// roundtrip sends a request and waits for its reply
+func (c *x11Conn) roundtrip(major, minor byte, body []byte) ([]byte, error) {
+	err := c.request(major, minor, body)
+	if err != nil {
+		return nil, err
+	}
+	return c.reply()
+}
+
+// queryExtension returns the major opcode of the named extension
+func (c *x11Conn) queryExtension(name string) (byte, error) {
+	body := binary.LittleEndian.AppendUint16(nil, uint16(len(name)))
+	body = append(body, 0, 0)
+	body = x11AppendPadded(body, []byte(name))
+	reply, err := c.roundtrip(x11OpQueryExtension, 0, body)
+	if err != nil {
+		return 0, err
+	}
+	if reply[8] == 0 {
+		return 0, fmt.Errorf("X server does not support the %s extension", name)
+	}
+	return reply[9], nil
+}
+
+// x11Pad returns n rounded up to a multiple of 4
+func x11Pad(n int) int {
+	return (n + 3) &^ 3
+}
+
+func x11AppendPadded(buf, data []byte) []byte {
+	buf = append(buf, data...)
+	return append(buf, make([]byte, x11Pad(len(data))-len(data))...)
+}
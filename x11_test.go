@@ -0,0 +1,264 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"testing"
+	"time"
+)
+
+type X11ParseDisplayTestCase struct {
+	display  string
+	expected x11Display
+	err      bool
+}
+
+func TestX11ParseDisplay(t *testing.T) {
+	tests := map[string]X11ParseDisplayTestCase{
+		"local":          {":0", x11Display{"", 0, 0}, false},
+		"local screen":   {":1.2", x11Display{"", 1, 2}, false},
+		"remote":         {"localhost:10.0", x11Display{"localhost", 10, 0}, false},
+		"explicit unix":  {"unix:3", x11Display{"unix", 3, 0}, false},
+		"empty":          {"", x11Display{}, true},
+		"missing number": {"localhost:", x11Display{"localhost", 0, 0}, true},
+		"bad screen":     {":0.x", x11Display{"", 0, 0}, true},
+	}
+	for label, test := range tests {
+		t.Run(label, func(t *testing.T) {
+			d, err := x11ParseDisplay(test.display)
+			if (err != nil) != test.err {
+				t.Errorf("Error expected? (%v) but got %v", test.err, err)
+			}
+			if d != test.expected {
+				t.Errorf("Got %+v instead of %+v", d, test.expected)
+			}
+		})
+	}
+}
+
+func xauthEntry(family uint16, address, number, name string, data []byte) []byte {
+	buf := binary.BigEndian.AppendUint16(nil, family)
+	for _, s := range [][]byte{[]byte(address), []byte(number), []byte(name), data} {
+		buf = binary.BigEndian.AppendUint16(buf, uint16(len(s)))
+		buf = append(buf, s...)
+	}
+	return buf
+}
+
+func TestX11Cookie(t *testing.T) {
+	var file []byte
+	file = append(file, xauthEntry(0, "\x7f\x00\x00\x01", "0", x11AuthName, []byte("internet"))...)
+	file = append(file, xauthEntry(x11FamilyLocal, "myhost", "1", "XDM-AUTHORIZATION-1", []byte("xdm"))...)
+	file = append(file, xauthEntry(x11FamilyLocal, "myhost", "1", x11AuthName, []byte("cookie1"))...)
+	file = append(file, xauthEntry(x11FamilyWild, "", "", x11AuthName, []byte("wild"))...)
+	tests := map[int]string{
+		1: "cookie1",
+		0: "wild",
+	}
+	for number, expected := range tests {
+		t.Run(strconv.Itoa(number), func(t *testing.T) {
+			cookie, err := x11Cookie(bytes.NewReader(file), number)
+			if err != nil {
+				t.Fatalf("Got error %v", err)
+			}
+			if string(cookie) != expected {
+				t.Errorf("Got cookie %q instead of %q", cookie, expected)
+			}
+		})
+	}
+	t.Run("no match", func(t *testing.T) {
+		if _, err := x11Cookie(bytes.NewReader(file[:40]), 5); err == nil {
+			t.Errorf("Expected error, got nil")
+		}
+	})
+}
+
+// fakeX11 is a tiny X server knowing just enough RandR to serve one CRTC
+// with a single connected output, plus one disconnected output.
+type fakeX11 struct {
+	ramps []uint16
+}
+
+const (
+	fakeX11RandrOpcode = 140
+	fakeX11Root        = 0x100
+	fakeX11Crtc        = 0x200
+)
+
+// start listens on TCP and points $DISPLAY to it
+func (f *fakeX11) start(t *testing.T) {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+	port := l.Addr().(*net.TCPAddr).Port
+	if port < 6000 {
+		t.Skipf("Port %d can not be expressed as X display", port)
+	}
+	t.Setenv("DISPLAY", fmt.Sprintf("127.0.0.1:%d", port-6000))
+	t.Setenv("XAUTHORITY", filepath.Join(t.TempDir(), "none"))
+	go func() {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		f.serve(conn)
+	}()
+}
+
+// fakeX11Reply builds a reply from everything following the 8 byte header
+func fakeX11Reply(fields []byte) []byte {
+	fields = append(fields, make([]byte, max(0, 24-len(fields)))...)
+	fields = x11AppendPadded(nil, fields)
+	reply := []byte{1, 0, 0, 0}
+	reply = binary.LittleEndian.AppendUint32(reply, uint32((len(fields)-24)/4))
+	return append(reply, fields...)
+}
+
+func (f *fakeX11) serve(conn net.Conn) {
+	header := make([]byte, 12)
+	if _, err := io.ReadFull(conn, header); err != nil {
+		return
+	}
+	auth := x11Pad(int(binary.LittleEndian.Uint16(header[6:]))) + x11Pad(int(binary.LittleEndian.Uint16(header[8:])))
+	io.ReadFull(conn, make([]byte, auth))
+	setup := make([]byte, 32)
+	setup[20] = 1 // one screen, no formats, empty vendor
+	screen := binary.LittleEndian.AppendUint32(nil, fakeX11Root)
+	setup = append(setup, append(screen, make([]byte, 36)...)...)
+	reply := []byte{1, 0, 11, 0, 0, 0}
+	reply = binary.LittleEndian.AppendUint16(reply, uint16(len(setup)/4))
+	conn.Write(append(reply, setup...))
+	for {
+		req := make([]byte, 4)
+		if _, err := io.ReadFull(conn, req); err != nil {
+			return
+		}
+		body := make([]byte, int(binary.LittleEndian.Uint16(req[2:]))*4-4)
+		if _, err := io.ReadFull(conn, body); err != nil {
+			return
+		}
+		if req[0] == x11OpQueryExtension {
+			conn.Write(fakeX11Reply([]byte{1, fakeX11RandrOpcode}))
+			continue
+		}
+		switch req[1] {
+		case randrQueryVersion:
+			conn.Write(fakeX11Reply(x11Uint32s(1, 6)))
+		case randrGetScreenResourcesCurrent:
+			fields := make([]byte, 8)
+			fields = binary.LittleEndian.AppendUint16(fields, 1) // crtcs
+			fields = binary.LittleEndian.AppendUint16(fields, 2) // outputs
+			fields = append(fields, make([]byte, 12)...)
+			fields = append(fields, x11Uint32s(fakeX11Crtc, 0x300, 0x301)...)
+			conn.Write(fakeX11Reply(fields))
+		case randrGetOutputInfo:
+			output := binary.LittleEndian.Uint32(body)
+			name, crtc := "eDP-1", uint32(fakeX11Crtc)
+			if output == 0x301 {
+				name, crtc = "HDMI-1", 0
+			}
+			fields := x11Uint32s(0, crtc, 0, 0)
+			fields = append(fields, make([]byte, 10)...)
+			fields = binary.LittleEndian.AppendUint16(fields, uint16(len(name)))
+			conn.Write(fakeX11Reply(append(fields, name...)))
+		case randrGetCrtcGamma:
+			fields := binary.LittleEndian.AppendUint16(nil, uint16(len(f.ramps)/3))
+			fields = append(fields, make([]byte, 22)...)
+			for _, v := range f.ramps {
+				fields = binary.LittleEndian.AppendUint16(fields, v)
+			}
+			conn.Write(fakeX11Reply(fields))
+		case randrGetCrtcGammaSize:
+			conn.Write(fakeX11Reply(binary.LittleEndian.AppendUint16(nil, uint16(len(f.ramps)/3))))
+		case randrSetCrtcGamma:
+			size := int(binary.LittleEndian.Uint16(body[4:]))
+			ramps := make([]uint16, size*3)
+			for i := range ramps {
+				ramps[i] = binary.LittleEndian.Uint16(body[8+i*2:])
+			}
+			f.ramps = ramps
+		}
+	}
+}
+
+func TestX11OutputFake(t *testing.T) {
+	original := []uint16{0, 100, 200, 300, 0, 100, 200, 300, 0, 100, 200, 300}
+	f := &fakeX11{ramps: slices.Clone(original)}
+	f.start(t)
+	out, err := NewX11Output(Config{Loop: true})
+	if err != nil {
+		t.Fatalf("Got error %v", err)
+	}
+	err = out.Apply(4000, 90)
+	if err != nil {
+		t.Fatalf("Got error %v", err)
+	}
+	r, g, b := GammaRamp(4, 4000, 90)
+	if expected := slices.Concat(r, g, b); !slices.Equal(f.ramps, expected) {
+		t.Errorf("Got ramps\n%v instead of\n%v", f.ramps, expected)
+	}
+	err = out.Close()
+	if err != nil {
+		t.Fatalf("Got error %v", err)
+	}
+	if !slices.Equal(f.ramps, original) {
+		t.Errorf("Original ramps were not restored, got %v", f.ramps)
+	}
+}
+
+// TestX11OutputXvfb runs against a real X server if Xvfb is installed
+func TestX11OutputXvfb(t *testing.T) {
+	xvfb, err := exec.LookPath("Xvfb")
+	if err != nil {
+		t.Skip("Xvfb not found")
+	}
+	display := ":93"
+	cmd := exec.Command(xvfb, display, "-nolisten", "tcp")
+	if err := cmd.Start(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		cmd.Process.Kill()
+		cmd.Wait()
+	})
+	for range 50 {
+		if _, err := os.Stat("/tmp/.X11-unix/X93"); err == nil {
+			break
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	t.Setenv("DISPLAY", display)
+	out, err := NewX11Output(Config{Loop: true})
+	if err != nil {
+		t.Fatalf("Got error %v", err)
+	}
+	o := out.(*X11Output)
+	crtc := o.crtcs[0]
+	if err := out.Apply(4000, 90); err != nil {
+		t.Fatalf("Got error %v", err)
+	}
+	r, g, b := GammaRamp(crtc.size, 4000, 90)
+	got, err := o.getGamma(crtc.id)
+	if err != nil {
+		t.Fatalf("Got error %v", err)
+	}
+	// The server may reduce precision of the ramps
+	if got[crtc.size-1] < r[crtc.size-1]-256 || got[2*crtc.size-1] < g[crtc.size-1]-256 || got[3*crtc.size-1] < b[crtc.size-1]-256 {
+		t.Errorf("Ramps were not applied")
+	}
+	if err := out.Close(); err != nil {
+		t.Fatalf("Got error %v", err)
+	}
+}
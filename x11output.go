@@ -0,0 +1,214 @@
+package main
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"log/slog"
+)
+
+const (
+	randrExtension = "RANDR"
+
+	// RandR minor opcodes
+	randrQueryVersion              = 0
+	randrGetOutputInfo             = 9
+	randrGetCrtcGammaSize          = 22
+	randrGetCrtcGamma              = 23
+	randrSetCrtcGamma              = 24
+	randrGetScreenResourcesCurrent = 25
+)
+
+// randrCrtc is a CRTC driving at least one output, together with the gamma
+// ramps it had before nerdshade touched it.
+type randrCrtc struct {
+	id      uint32
+	outputs []string
+	size    int
+	// original ramps, red, green and blue concatenated
+	original []uint16
+}
+
+// X11Output is the output backend for X11, setting CRTC gamma ramps via the
+// RandR extension. In -loop mode the original ramps are restored on exit.
+type X11Output struct {
+	conn        *x11Conn
+	opcode      byte
+	crtcs       []*randrCrtc
+	restore     bool
+	temperature int
+	gamma       int
+}
+
+func NewX11Output(cflags Config) (Output, error) {
+	conn, err := x11Dial()
+	if err != nil {
+		return nil, err
+	}
+	o := &X11Output{
+		conn:        conn,
+		restore:     cflags.Loop,
+		temperature: NeutralTemp,
+		gamma:       NeutralGamma,
+	}
+	err = o.setup()
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return o, nil
+}
+
+func (o *X11Output) setup() error {
+	var err error
+	o.opcode, err = o.conn.queryExtension(randrExtension)
+	if err != nil {
+		return err
+	}
+	// The server needs to know we speak at least RandR 1.3
+	_, err = o.conn.roundtrip(o.opcode, randrQueryVersion, x11Uint32s(1, 3))
+	if err != nil {
+		return err
+	}
+	reply, err := o.conn.roundtrip(o.opcode, randrGetScreenResourcesCurrent, x11Uint32s(o.conn.root))
+	if err != nil {
+		return err
+	}
+	configTimestamp := binary.LittleEndian.Uint32(reply[12:])
+	numCrtcs := int(binary.LittleEndian.Uint16(reply[16:]))
+	numOutputs := int(binary.LittleEndian.Uint16(reply[18:]))
+	if len(reply) < 32+(numCrtcs+numOutputs)*4 {
+		return errors.New("RandR screen resources reply too short")
+	}
+	crtcs := map[uint32]*randrCrtc{}
+	for i := range numOutputs {
+		output := binary.LittleEndian.Uint32(reply[32+(numCrtcs+i)*4:])
+		crtc, name, err := o.outputInfo(output, configTimestamp)
+		if err != nil {
+			return err
+		}
+		slog.Debug("randr output", "name", name, "crtc", crtc)
+		if crtc == 0 {
+			// Output is not connected or disabled
+			continue
+		}
+		if crtcs[crtc] == nil {
+			crtcs[crtc] = &randrCrtc{id: crtc}
+			o.crtcs = append(o.crtcs, crtcs[crtc])
+		}
+		crtcs[crtc].outputs = append(crtcs[crtc].outputs, name)
+	}
+	if len(o.crtcs) == 0 {
+		return errors.New("No active RandR outputs found")
+	}
+	for _, crtc := range o.crtcs {
+		crtc.original, err = o.getGamma(crtc.id)
+		if err != nil {
+			return err
+		}
+		crtc.size = len(crtc.original) / 3
+		slog.Debug("randr crtc", "id", crtc.id, "outputs", crtc.outputs, "gammaSize", crtc.size)
+	}
+	return nil
+}
+
+// outputInfo returns the CRTC and name of the given output
+func (o *X11Output) outputInfo(output, configTimestamp uint32) (uint32, string, error) {
+	reply, err := o.conn.roundtrip(o.opcode, randrGetOutputInfo, x11Uint32s(output, configTimestamp))
+	if err != nil {
+		return 0, "", err
+	}
+	crtc := binary.LittleEndian.Uint32(reply[12:])
+	numCrtcs := int(binary.LittleEndian.Uint16(reply[26:]))
+	numModes := int(binary.LittleEndian.Uint16(reply[28:]))
+	numClones := int(binary.LittleEndian.Uint16(reply[32:]))
+	nameLen := int(binary.LittleEndian.Uint16(reply[34:]))
+	offset := 36 + (numCrtcs+numModes+numClones)*4
+	if len(reply) < offset+nameLen {
+		return 0, "", errors.New("RandR output info reply too short")
+	}
+	return crtc, string(reply[offset : offset+nameLen]), nil
+}
+
+// getGamma returns the current ramps of a CRTC, red, green and blue
+// concatenated
+func (o *X11Output) getGamma(crtc uint32) ([]uint16, error) {
+	reply, err := o.conn.roundtrip(o.opcode, randrGetCrtcGamma, x11Uint32s(crtc))
+	if err != nil {
+		return nil, err
+	}
+	size := int(binary.LittleEndian.Uint16(reply[8:]))
+	if len(reply) < 32+size*6 {
+		return nil, errors.New("RandR gamma reply too short")
+	}
+	ramps := make([]uint16, size*3)
+	for i := range ramps {
+		ramps[i] = binary.LittleEndian.Uint16(reply[32+i*2:])
+	}
+	return ramps, nil
+}
+
+// setGamma sets the ramps of a CRTC, red, green and blue concatenated
+func (o *X11Output) setGamma(crtc uint32, ramps []uint16) error {
+	body := x11Uint32s(crtc)
+	body = binary.LittleEndian.AppendUint16(body, uint16(len(ramps)/3))
+	body = append(body, 0, 0)
+	data := make([]byte, 0, len(ramps)*2)
+	for _, v := range ramps {
+		data = binary.LittleEndian.AppendUint16(data, v)
+	}
+	err := o.conn.request(o.opcode, randrSetCrtcGamma, x11AppendPadded(body, data))
+	if err != nil {
+		return err
+	}
+	// SetCrtcGamma has no reply, use a request that has one to catch errors
+	_, err = o.conn.roundtrip(o.opcode, randrGetCrtcGammaSize, x11Uint32s(crtc))
+	return err
+}
+
+func (o *X11Output) Name() string {
+	return "x11"
+}
+
+func (o *X11Output) Apply(temperature, gamma int) error {
+	for _, crtc := range o.crtcs {
+		r, g, b := GammaRamp(crtc.size, temperature, gamma)
+		err := o.setGamma(crtc.id, append(append(r, g...), b...))
+		if err != nil {
+			return fmt.Errorf("setting gamma on %v: %w", crtc.outputs, err)
+		}
+	}
+	o.temperature = temperature
+	o.gamma = gamma
+	return nil
+}
+
+func (o *X11Output) Current() (int, int, error) {
+	return o.temperature, o.gamma, nil
+}
+
+func (o *X11Output) Capabilities() Capabilities {
+	return Capabilities{Temperature: true, Gamma: true}
+}
+
+// Close restores the original gamma ramps (in -loop mode only) and
+// disconnects from the X server.
+func (o *X11Output) Close() error {
+	var errs []error
+	if o.restore {
+		slog.Debug("restoring original gamma ramps")
+		for _, crtc := range o.crtcs {
+			errs = append(errs, o.setGamma(crtc.id, crtc.original))
+		}
+	}
+	errs = append(errs, o.conn.Close())
+	return errors.Join(errs...)
+}
+
+func x11Uint32s(values ...uint32) []byte {
+	buf := make([]byte, 0, len(values)*4)
+	for _, v := range values {
+		buf = binary.LittleEndian.AppendUint32(buf, v)
+	}
+	return buf
+}